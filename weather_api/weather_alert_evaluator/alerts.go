@@ -0,0 +1,221 @@
+// Evaluation of the alert rules and persistence of the alert states.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"weather_data_generator/weather_generator"
+)
+
+const ALERT_RULES_PK string = "ALERT_RULES"
+const ALERT_STATES_PK string = "ALERT_STATES"
+const ALERT_HISTORY_PK string = "ALERT_HISTORY"
+
+// alert states
+const (
+	// the condition holds, but not for long enough yet
+	pending = "pending"
+	firing  = "firing"
+	// the condition no longer holds after firing
+	resolved = "resolved"
+	// the condition no longer holds, without having fired
+	inactive = "inactive"
+)
+
+// AlertRule is managed by weather_alert_rules
+type AlertRule struct {
+	RuleId          string
+	Name            string
+	DeviceId        int64
+	EventType       string
+	Operator        string
+	Threshold       float64
+	DurationSeconds int64
+	Webhooks        []string
+}
+
+// AlertState is the current state of the alert raised by one rule for one device
+type AlertState struct {
+	RuleId    string
+	DeviceId  int64
+	EventType string
+	State     string
+	// unix time of the first reading of the current state
+	Since int64
+	// last value that changed the state
+	Value float64
+}
+
+// AlertNotification is sent to the websocket clients and webhooks at each firing/resolved transition
+type AlertNotification struct {
+	// always "alert", to tell alerts apart from weather events on the websocket
+	MessageType string
+	RuleId      string
+	RuleName    string
+	DeviceId    int64
+	EventType   string
+	Operator    string
+	Threshold   float64
+	Value       float64
+	// firing or resolved
+	State string
+	Time  time.Time
+}
+
+func (r AlertRule) appliesTo(event weather_generator.WeatherEvent) bool {
+	return r.EventType == event.EventType && (r.DeviceId == 0 || r.DeviceId == event.DeviceId)
+}
+
+// matches tells whether the condition of the rule holds for that value
+func (r AlertRule) matches(value float64) bool {
+	switch r.Operator {
+	case ">":
+		return value > r.Threshold
+	case ">=":
+		return value >= r.Threshold
+	case "<":
+		return value < r.Threshold
+	case "<=":
+		return value <= r.Threshold
+	case "==":
+		return value == r.Threshold
+	case "!=":
+		return value != r.Threshold
+	}
+	log.Printf("unknown operator %q in rule %s", r.Operator, r.RuleId)
+	return false
+}
+
+// evaluate returns the state of the alert after that reading
+func evaluate(rule AlertRule, state AlertState, event weather_generator.WeatherEvent) AlertState {
+	if !rule.matches(event.Value) {
+		switch state.State {
+		case firing:
+			state.State, state.Since, state.Value = resolved, event.Time.Unix(), event.Value
+		case pending:
+			state.State, state.Since, state.Value = inactive, event.Time.Unix(), event.Value
+		}
+		return state
+	}
+
+	switch state.State {
+	case firing:
+		return state
+	case pending:
+		if event.Time.Unix()-state.Since >= rule.DurationSeconds {
+			state.State, state.Value = firing, event.Value
+		}
+		return state
+	}
+
+	state.State, state.Since, state.Value = pending, event.Time.Unix(), event.Value
+	if rule.DurationSeconds == 0 {
+		state.State = firing
+	}
+	return state
+}
+
+func newNotification(rule AlertRule, state AlertState) AlertNotification {
+	return AlertNotification{
+		MessageType: "alert",
+		RuleId:      rule.RuleId,
+		RuleName:    rule.Name,
+		DeviceId:    state.DeviceId,
+		EventType:   rule.EventType,
+		Operator:    rule.Operator,
+		Threshold:   rule.Threshold,
+		Value:       state.Value,
+		State:       state.State,
+		Time:        time.Unix(state.Since, 0).UTC(),
+	}
+}
+
+func stateKey(ruleId string, deviceId int64) string {
+	return fmt.Sprintf("RuleId#%s#DeviceId#%d", ruleId, deviceId)
+}
+
+func loadRules(ctx context.Context) ([]AlertRule, error) {
+	rules := []AlertRule{}
+	err := queryPartition(ctx, ALERT_RULES_PK, &rules)
+	return rules, err
+}
+
+// loadStates fetches the current alert states, indexed by stateKey
+func loadStates(ctx context.Context) (map[string]AlertState, error) {
+	stateList := []AlertState{}
+	if err := queryPartition(ctx, ALERT_STATES_PK, &stateList); err != nil {
+		return nil, err
+	}
+	states := make(map[string]AlertState, len(stateList))
+	for _, state := range stateList {
+		states[stateKey(state.RuleId, state.DeviceId)] = state
+	}
+	return states, nil
+}
+
+// queryPartition reads all the items of that partition into items, a pointer to a slice
+func queryPartition(ctx context.Context, partitionKey string, items any) error {
+	expr, err := expression.NewBuilder().
+		WithKeyCondition(
+			expression.Key("PK").Equal(expression.Value(partitionKey)),
+		).
+		Build()
+	if err != nil {
+		return fmt.Errorf("error while building DynamoDB query: %w", err)
+	}
+
+	rawItems := []map[string]types.AttributeValue{}
+	paginator := dynamodb.NewQueryPaginator(dynamodbClient, &dynamodb.QueryInput{
+		TableName:                 dynamoTable,
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+	for paginator.HasMorePages() {
+		queryResult, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("error while querying DynamodDB: %w", err)
+		}
+		rawItems = append(rawItems, queryResult.Items...)
+	}
+
+	if err := attributevalue.UnmarshalListOfMaps(rawItems, items); err != nil {
+		return fmt.Errorf("failed to parse items of %s: %w", partitionKey, err)
+	}
+	return nil
+}
+
+func storeState(ctx context.Context, state AlertState) error {
+	return putItem(ctx, ALERT_STATES_PK, stateKey(state.RuleId, state.DeviceId), state)
+}
+
+// storeTransition keeps track of each time an alert fired or got resolved
+func storeTransition(ctx context.Context, notification AlertNotification) error {
+	sortKey := fmt.Sprintf("Time#%d#%s", notification.Time.Unix(), stateKey(notification.RuleId, notification.DeviceId))
+	return putItem(ctx, ALERT_HISTORY_PK, sortKey, notification)
+}
+
+func putItem(ctx context.Context, partitionKey, sortKey string, payload any) error {
+	item, err := attributevalue.MarshalMap(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %v: %w", payload, err)
+	}
+	item["PK"] = &types.AttributeValueMemberS{Value: partitionKey}
+	item["SK"] = &types.AttributeValueMemberS{Value: sortKey}
+
+	if _, err := dynamodbClient.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: dynamoTable,
+		Item:      item,
+	}); err != nil {
+		return fmt.Errorf("error while inserting %s %s in DyanmoDB: %w", partitionKey, sortKey, err)
+	}
+	return nil
+}
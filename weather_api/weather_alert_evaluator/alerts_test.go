@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"weather_data_generator/weather_generator"
+)
+
+func TestEvaluate(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	// Temperature > 30 for 5 minutes
+	rule := AlertRule{RuleId: "hot", EventType: "Temperature", Operator: ">", Threshold: 30, DurationSeconds: 300}
+
+	readings := []struct {
+		minutes  int
+		value    float64
+		expected string
+		since    int
+	}{
+		{0, 25, "", 0},
+		// the condition holds, but not for 5 minutes yet
+		{1, 31, pending, 1},
+		{4, 33, pending, 1},
+		// held for 5 minutes
+		{6, 32, firing, 1},
+		{8, 35, firing, 1},
+		{9, 29, resolved, 9},
+		{10, 28, resolved, 9},
+		// re-armed: pending again, and firing only after another 5 minutes
+		{11, 31, pending, 11},
+		{15, 31, pending, 11},
+		// interrupted before firing
+		{16, 30, inactive, 16},
+		{17, 34, pending, 17},
+		{22, 34, firing, 17},
+	}
+
+	state := AlertState{RuleId: rule.RuleId, DeviceId: 1001, EventType: rule.EventType}
+	for _, reading := range readings {
+		eventTime := start.Add(time.Duration(reading.minutes) * time.Minute)
+		state = evaluate(rule, state, weather_generator.WeatherEvent{DeviceId: 1001, Time: eventTime, EventType: "Temperature", Value: reading.value})
+		if state.State != reading.expected {
+			t.Fatalf("%s after %v at minute %d, expected %s", state.State, reading.value, reading.minutes, reading.expected)
+		}
+		if reading.expected != "" && state.Since != start.Add(time.Duration(reading.since)*time.Minute).Unix() {
+			t.Errorf("%s since %v at minute %d, expected since minute %d", state.State, time.Unix(state.Since, 0).UTC(), reading.minutes, reading.since)
+		}
+	}
+}
+
+func TestEvaluateWithoutDuration(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	rule := AlertRule{RuleId: "freezing", EventType: "Temperature", Operator: "<=", Threshold: 0}
+	state := AlertState{RuleId: rule.RuleId, DeviceId: 1001, EventType: rule.EventType}
+
+	for i, expected := range []struct {
+		value float64
+		state string
+	}{{-1, firing}, {0, firing}, {0.5, resolved}, {-2, firing}} {
+		state = evaluate(rule, state, weather_generator.WeatherEvent{DeviceId: 1001, Time: start.Add(time.Duration(i) * time.Minute), EventType: "Temperature", Value: expected.value})
+		if state.State != expected.state {
+			t.Errorf("%s after %v, expected %s", state.State, expected.value, expected.state)
+		}
+	}
+}
+
+func TestRuleAppliesTo(t *testing.T) {
+	event := weather_generator.WeatherEvent{DeviceId: 1001, EventType: "Temperature"}
+	tests := []struct {
+		rule     AlertRule
+		expected bool
+	}{
+		{AlertRule{EventType: "Temperature"}, true},
+		{AlertRule{EventType: "Temperature", DeviceId: 1001}, true},
+		{AlertRule{EventType: "Temperature", DeviceId: 1002}, false},
+		{AlertRule{EventType: "Humidity"}, false},
+	}
+	for _, test := range tests {
+		if test.rule.appliesTo(event) != test.expected {
+			t.Errorf("rule %+v applies to %+v: %v, expected %v", test.rule, event, !test.expected, test.expected)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Lambda listening to new weather events from DynamoDB stream, evaluating them against
+// the alert rules and notifying the alert transitions to the websocket clients and webhooks.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+
+	"github.com/aws/aws-sdk-go-v2/service/apigatewaymanagementapi"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"weather_data_generator/weather_storage"
+)
+
+var dynamodbClient *dynamodb.Client
+var dynamoTable *string
+var apiGWManagementClient *apigatewaymanagementapi.Client
+
+func init() {
+	sdkConfig, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		log.Fatal("Could not connect to AWS API", err)
+	}
+
+	dynamoTable = aws.String(os.Getenv("DYNAMO_TABLE"))
+	dynamodbClient = dynamodb.NewFromConfig(sdkConfig)
+
+	wsClientCallbackUrl := fmt.Sprintf(
+		"https://%s.execute-api.%s.amazonaws.com/%s",
+		os.Getenv("API_ID"),
+		os.Getenv("AWS_REGION"),
+		os.Getenv("API_STAGE"),
+	)
+	apiGWManagementClient = apigatewaymanagementapi.NewFromConfig(
+		sdkConfig,
+		func(o *apigatewaymanagementapi.Options) {
+			o.BaseEndpoint = &wsClientCallbackUrl
+		},
+	)
+}
+
+func handler(ctx context.Context, event events.DynamoDBEvent) error {
+	timeBoxedCtx, cancel := context.WithTimeout(ctx, 20*time.Second)
+	defer cancel()
+
+	rules, err := loadRules(timeBoxedCtx)
+	if err != nil {
+		return fmt.Errorf("could not fetch alert rules from DB: %w", err)
+	}
+	if len(rules) == 0 {
+		log.Println("no alert rule defined atm")
+		return nil
+	}
+
+	states, err := loadStates(timeBoxedCtx)
+	if err != nil {
+		return fmt.Errorf("could not fetch alert states from DB: %w", err)
+	}
+
+	changedStates := map[string]AlertState{}
+	notifications := []AlertNotification{}
+	for _, record := range event.Records {
+		weatherEvent, err := weather_storage.ParseStreamImage(record.Change.NewImage)
+		if err != nil {
+			log.Printf("not evaluating %s record: %v", record.EventName, err)
+			continue
+		}
+
+		for _, rule := range rules {
+			if !rule.appliesTo(weatherEvent) {
+				continue
+			}
+			key := stateKey(rule.RuleId, weatherEvent.DeviceId)
+			previous, ok := states[key]
+			if !ok {
+				previous = AlertState{RuleId: rule.RuleId, DeviceId: weatherEvent.DeviceId, EventType: rule.EventType}
+			}
+
+			next := evaluate(rule, previous, weatherEvent)
+			if next != previous {
+				states[key] = next
+				changedStates[key] = next
+				if next.State != previous.State && (next.State == firing || next.State == resolved) {
+					log.Printf("alert of rule %s on device %d is now %s", rule.RuleId, weatherEvent.DeviceId, next.State)
+					notifications = append(notifications, newNotification(rule, next))
+				}
+			}
+		}
+	}
+
+	for _, state := range changedStates {
+		if err := storeState(timeBoxedCtx, state); err != nil {
+			log.Println("failed to persist alert state", err)
+		}
+	}
+	for _, notification := range notifications {
+		if err := storeTransition(timeBoxedCtx, notification); err != nil {
+			log.Println("failed to persist alert transition", err)
+		}
+	}
+	if len(notifications) > 0 {
+		notify(timeBoxedCtx, rules, notifications)
+	}
+	return nil
+}
+
+func main() {
+	lambda.Start(handler)
+}
@@ -0,0 +1,116 @@
+// Delivery of the alert notifications to the websocket clients and to the webhooks of the rules.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/apigatewaymanagementapi"
+)
+
+const SESSION_PK string = "WS_SESSIONS"
+
+var webhookClient = &http.Client{
+	Timeout: 5 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{Timeout: 5 * time.Second, Control: refuseLocalAddresses}).DialContext,
+	},
+}
+
+// refuseLocalAddresses refuses to connect to loopback, private or link-local addresses, i.e. to the webhooks whose
+// host names resolve to them (weather_alert_rules refusing those addresses themselves), including after a redirect
+func refuseLocalAddresses(network, address string, conn syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("refusing to connect to webhook address %s", address)
+	}
+	return nil
+}
+
+type WsSession struct {
+	ConnectionId string
+}
+
+// notify sends those notifications to all connected websocket clients and to the webhooks
+// of their rule. Any delivery error is just logged
+func notify(ctx context.Context, rules []AlertRule, notifications []AlertNotification) {
+	webhooks := map[string][]string{}
+	for _, rule := range rules {
+		webhooks[rule.RuleId] = rule.Webhooks
+	}
+
+	sessions := []WsSession{}
+	if err := queryPartition(ctx, SESSION_PK, &sessions); err != nil {
+		log.Println("could not fetch active ws connections from DB", err)
+	}
+
+	var waiter sync.WaitGroup
+	for _, notification := range notifications {
+		payload, err := json.Marshal(notification)
+		if err != nil {
+			log.Println("failed to marshal alert notification", err)
+			continue
+		}
+
+		for _, session := range sessions {
+			waiter.Add(1)
+			go func() {
+				defer waiter.Done()
+				postInput := apigatewaymanagementapi.PostToConnectionInput{
+					ConnectionId: &session.ConnectionId,
+					Data:         payload,
+				}
+				if _, err := apiGWManagementClient.PostToConnection(ctx, &postInput); err != nil {
+					log.Println("failed to send alert to ws client", session.ConnectionId, err)
+				}
+			}()
+		}
+
+		for _, webhook := range webhooks[notification.RuleId] {
+			waiter.Add(1)
+			go func() {
+				defer waiter.Done()
+				if err := postWebhook(ctx, webhook, payload); err != nil {
+					log.Println("failed to call webhook", err)
+				}
+			}()
+		}
+	}
+	waiter.Wait()
+}
+
+func postWebhook(ctx context.Context, webhook string, payload []byte) error {
+	// rules created before webhooks were restricted to https
+	if webhookUrl, err := url.Parse(webhook); err != nil || webhookUrl.Scheme != "https" {
+		return fmt.Errorf("refusing to post to webhook %s, not an https URL", webhook)
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("invalid webhook %s: %w", webhook, err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := webhookClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("error while posting to %s: %w", webhook, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s responded %s", webhook, response.Status)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRefuseLocalAddresses(t *testing.T) {
+	tests := []struct {
+		address string
+		allowed bool
+	}{
+		{"93.184.216.34:443", true},
+		{"[2606:2800:220:1:248:1893:25c8:1946]:443", true},
+		{"127.0.0.1:443", false},
+		{"[::1]:443", false},
+		{"10.0.0.12:443", false},
+		{"172.16.5.4:443", false},
+		{"192.168.1.1:443", false},
+		// instance metadata endpoints
+		{"169.254.169.254:80", false},
+		{"[fd00:ec2::254]:80", false},
+		{"0.0.0.0:443", false},
+	}
+	for _, test := range tests {
+		if err := refuseLocalAddresses("tcp", test.address, nil); (err == nil) != test.allowed {
+			t.Errorf("%s: error %v, expected allowed %v", test.address, err, test.allowed)
+		}
+	}
+}
+
+func TestPostWebhookRefusesLocalTargets(t *testing.T) {
+	posted := false
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posted = true
+	}))
+	defer server.Close()
+
+	for _, webhook := range []string{server.URL, "http://example.com/alerts"} {
+		if err := postWebhook(context.Background(), webhook, []byte(`{}`)); err == nil {
+			t.Errorf("no error posting to %s", webhook)
+		}
+	}
+	if posted {
+		t.Error("posted to a webhook on the loopback interface")
+	}
+}
@@ -0,0 +1,457 @@
+// Lambda serving the REST requests managing the threshold alert rules, and listing
+// the current state of the alerts they raised.
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"weather_data_generator/weather_generator"
+)
+
+var dynamoClient *dynamodb.Client
+var dynamoTable *string
+
+const ALERT_RULES_PK string = "ALERT_RULES"
+const ALERT_STATES_PK string = "ALERT_STATES"
+
+func init() {
+	dynamoTable = aws.String(os.Getenv("DYNAMO_TABLE"))
+
+	awsCfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		log.Fatal(err)
+	}
+	dynamoClient = dynamodb.NewFromConfig(awsCfg)
+}
+
+// AlertRule raises an alert when the readings of some event type compare to the threshold
+// according to the operator during at least DurationSeconds, e.g. Temperature > 30 for 5 minutes.
+type AlertRule struct {
+	RuleId string
+	Name   string
+	// device whose readings are watched, or 0 to watch all devices
+	DeviceId  int64
+	EventType string
+	// one of operators
+	Operator  string
+	Threshold float64
+	// how long the condition must hold before the alert fires, 0 to fire immediately
+	DurationSeconds int64
+	// URLs to which the alert transitions are POSTed
+	Webhooks []string
+}
+
+// AlertState is the current state of the alert raised by one rule for one device
+type AlertState struct {
+	RuleId    string
+	DeviceId  int64
+	EventType string
+	// pending, firing or resolved
+	State string
+	// unix time of the first reading of the current state
+	Since int64
+	// last value that changed the state
+	Value float64
+}
+
+type AlertRules struct {
+	Rules []AlertRule
+}
+
+type AlertStates struct {
+	Alerts []AlertState
+}
+
+var operators = []string{">", ">=", "<", "<=", "==", "!="}
+
+var errRuleNotFound = errors.New("alert rule not found")
+
+// handler routes the requests of the /alerts, /alerts/rules and /alerts/rules/{rule_id} resources
+func handler(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if request.Resource == "/alerts" {
+		return listAlertStates(ctx), nil
+	}
+
+	ruleId, hasRuleId := request.PathParameters["rule_id"]
+	if !hasRuleId {
+		switch request.HTTPMethod {
+		case "GET":
+			return listRules(ctx), nil
+		case "POST":
+			return createRule(ctx, request.Body), nil
+		}
+		return clientError(405, "method not allowed"), nil
+	}
+
+	switch request.HTTPMethod {
+	case "GET":
+		return getRule(ctx, ruleId), nil
+	case "PUT":
+		return updateRule(ctx, ruleId, request.Body), nil
+	case "DELETE":
+		return deleteRule(ctx, ruleId), nil
+	}
+	return clientError(405, "method not allowed"), nil
+}
+
+func listRules(ctx context.Context) events.APIGatewayProxyResponse {
+	rules := []AlertRule{}
+	if err := queryPartition(ctx, ALERT_RULES_PK, &rules); err != nil {
+		log.Println(err)
+		return serverSideError()
+	}
+	log.Printf("returning %d alert rules", len(rules))
+	return jsonResponse(200, AlertRules{Rules: rules})
+}
+
+func listAlertStates(ctx context.Context) events.APIGatewayProxyResponse {
+	states := []AlertState{}
+	if err := queryPartition(ctx, ALERT_STATES_PK, &states); err != nil {
+		log.Println(err)
+		return serverSideError()
+	}
+	log.Printf("returning %d alert states", len(states))
+	return jsonResponse(200, AlertStates{Alerts: states})
+}
+
+func getRule(ctx context.Context, ruleId string) events.APIGatewayProxyResponse {
+	getResult, err := dynamoClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: dynamoTable,
+		Key:       ruleKey(ruleId),
+	})
+	if err != nil {
+		log.Println(err)
+		return serverSideError()
+	}
+	if getResult.Item == nil {
+		return clientError(404, errRuleNotFound.Error())
+	}
+
+	rule := AlertRule{}
+	if err := attributevalue.UnmarshalMap(getResult.Item, &rule); err != nil {
+		log.Println(err)
+		return serverSideError()
+	}
+	return jsonResponse(200, rule)
+}
+
+func createRule(ctx context.Context, body string) events.APIGatewayProxyResponse {
+	rule, err := parseRule(body)
+	if err != nil {
+		return clientError(400, err.Error())
+	}
+	if rule.RuleId, err = newRuleId(); err != nil {
+		log.Println(err)
+		return serverSideError()
+	}
+
+	if err := putRule(ctx, rule, "attribute_not_exists(PK)"); err != nil {
+		log.Println(err)
+		return serverSideError()
+	}
+	log.Printf("created alert rule %s", rule.RuleId)
+	return jsonResponse(201, rule)
+}
+
+func updateRule(ctx context.Context, ruleId string, body string) events.APIGatewayProxyResponse {
+	rule, err := parseRule(body)
+	if err != nil {
+		return clientError(400, err.Error())
+	}
+	if rule.RuleId != "" && rule.RuleId != ruleId {
+		return clientError(400, "RuleId of the body does not match the one of the path")
+	}
+	rule.RuleId = ruleId
+
+	if err := putRule(ctx, rule, "attribute_exists(PK)"); err != nil {
+		return storageError(err)
+	}
+	log.Printf("updated alert rule %s", rule.RuleId)
+	return jsonResponse(200, rule)
+}
+
+// deleteRule deletes the rule and the states of the alerts it raised, which /alerts would list otherwise.
+// The states are deleted even when the rule no longer exists, such that retrying a DELETE whose cleanup failed completes it.
+func deleteRule(ctx context.Context, ruleId string) events.APIGatewayProxyResponse {
+	_, err := dynamoClient.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:           dynamoTable,
+		Key:                 ruleKey(ruleId),
+		ConditionExpression: aws.String("attribute_exists(PK)"),
+	})
+	ruleErr := conditionalError(err)
+	if ruleErr != nil && !errors.Is(ruleErr, errRuleNotFound) {
+		return storageError(ruleErr)
+	}
+	if err := deleteAlertStates(ctx, ruleId); err != nil {
+		log.Println(err)
+		return serverSideError()
+	}
+	if ruleErr != nil {
+		return storageError(ruleErr)
+	}
+	log.Printf("removed alert rule %s", ruleId)
+	return events.APIGatewayProxyResponse{
+		StatusCode: 204,
+	}
+}
+
+// parseRule parses and validates the JSON description of an alert rule
+func parseRule(body string) (AlertRule, error) {
+	rule := AlertRule{}
+	if err := json.Unmarshal([]byte(body), &rule); err != nil {
+		return rule, fmt.Errorf("invalid alert rule: %w", err)
+	}
+	if rule.DeviceId < 0 {
+		return rule, errors.New("invalid alert rule: DeviceId should be positive, or 0 for all devices")
+	}
+	if !weather_generator.IsEventType(rule.EventType) {
+		return rule, fmt.Errorf("invalid alert rule: unknown EventType %q", rule.EventType)
+	}
+	if !slices.Contains(operators, rule.Operator) {
+		return rule, fmt.Errorf("invalid alert rule: Operator should be one of %v", operators)
+	}
+	if rule.DurationSeconds < 0 {
+		return rule, errors.New("invalid alert rule: DurationSeconds should be positive")
+	}
+	for _, webhook := range rule.Webhooks {
+		if err := checkWebhook(webhook); err != nil {
+			return rule, fmt.Errorf("invalid alert rule: %w", err)
+		}
+	}
+	return rule, nil
+}
+
+// checkWebhook refuses the webhook URLs that are not https, or whose host is a loopback, private or link-local
+// address, which the evaluator would otherwise POST to from within the VPC (e.g. the instance metadata endpoint).
+// Host names resolving to such addresses are refused by the evaluator when connecting.
+func checkWebhook(webhook string) error {
+	webhookUrl, err := url.Parse(webhook)
+	if err != nil || webhookUrl.Scheme != "https" || webhookUrl.Hostname() == "" {
+		return fmt.Errorf("invalid webhook URL %q, expected an https URL", webhook)
+	}
+	host := strings.TrimSuffix(strings.ToLower(webhookUrl.Hostname()), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("webhook URL %q targets a local address", webhook)
+	}
+	if ip := net.ParseIP(host); ip != nil && !isPublicAddress(ip) {
+		return fmt.Errorf("webhook URL %q targets a loopback, private or link-local address", webhook)
+	}
+	return nil
+}
+
+func isPublicAddress(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified())
+}
+
+func newRuleId() (string, error) {
+	randomBytes := make([]byte, 8)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", fmt.Errorf("failed to generate rule id: %w", err)
+	}
+	return hex.EncodeToString(randomBytes), nil
+}
+
+// queryPartition reads all the items of that partition into items, a pointer to a slice
+func queryPartition(ctx context.Context, partitionKey string, items any) error {
+	expr, err := expression.NewBuilder().
+		WithKeyCondition(
+			expression.Key("PK").Equal(expression.Value(partitionKey)),
+		).
+		Build()
+	if err != nil {
+		return fmt.Errorf("error while building DynamoDB query: %w", err)
+	}
+
+	rawItems := []map[string]types.AttributeValue{}
+	paginator := dynamodb.NewQueryPaginator(dynamoClient, &dynamodb.QueryInput{
+		TableName:                 dynamoTable,
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+	for paginator.HasMorePages() {
+		queryResult, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("error while querying DynamodDB: %w", err)
+		}
+		rawItems = append(rawItems, queryResult.Items...)
+	}
+
+	if err := attributevalue.UnmarshalListOfMaps(rawItems, items); err != nil {
+		return fmt.Errorf("failed to parse items of %s: %w", partitionKey, err)
+	}
+	return nil
+}
+
+// deleteAlertStates deletes the states of the alerts raised by that rule, for all devices
+func deleteAlertStates(ctx context.Context, ruleId string) error {
+	expr, err := expression.NewBuilder().
+		WithKeyCondition(
+			expression.KeyAnd(
+				expression.Key("PK").Equal(expression.Value(ALERT_STATES_PK)),
+				expression.Key("SK").BeginsWith(fmt.Sprintf("RuleId#%s#", ruleId)),
+			),
+		).
+		WithProjection(expression.NamesList(expression.Name("PK"), expression.Name("SK"))).
+		Build()
+	if err != nil {
+		return fmt.Errorf("error while building DynamoDB query: %w", err)
+	}
+
+	deleteRequests := []types.WriteRequest{}
+	paginator := dynamodb.NewQueryPaginator(dynamoClient, &dynamodb.QueryInput{
+		TableName:                 dynamoTable,
+		KeyConditionExpression:    expr.KeyCondition(),
+		ProjectionExpression:      expr.Projection(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+	for paginator.HasMorePages() {
+		queryResult, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("error while querying DynamodDB: %w", err)
+		}
+		for _, key := range queryResult.Items {
+			deleteRequests = append(deleteRequests, types.WriteRequest{DeleteRequest: &types.DeleteRequest{Key: key}})
+		}
+	}
+
+	for len(deleteRequests) > 0 {
+		batchSize := min(len(deleteRequests), maxBatchSize)
+		if err := batchDelete(ctx, deleteRequests[:batchSize]); err != nil {
+			return err
+		}
+		deleteRequests = deleteRequests[batchSize:]
+	}
+	log.Printf("removed the alert states of rule %s", ruleId)
+	return nil
+}
+
+// max number of items of one DynamoDB BatchWriteItem
+const maxBatchSize = 25
+
+// batchDelete deletes those items, retrying the ones DynamoDB leaves unprocessed when throttling
+func batchDelete(ctx context.Context, deleteRequests []types.WriteRequest) error {
+	requestItems := map[string][]types.WriteRequest{*dynamoTable: deleteRequests}
+	for attempt := 0; len(requestItems) > 0; attempt++ {
+		if attempt == 5 {
+			return fmt.Errorf("%d alert states still not deleted after %d attempts", len(requestItems[*dynamoTable]), attempt)
+		}
+		if attempt > 0 {
+			select {
+			case <-time.After(50 * time.Millisecond << attempt):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		output, err := dynamoClient.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{RequestItems: requestItems})
+		if err != nil {
+			return fmt.Errorf("error while deleting alert states in DynamoDB: %w", err)
+		}
+		requestItems = output.UnprocessedItems
+	}
+	return nil
+}
+
+// putRule writes the rule in DynamoDB, provided the condition holds
+func putRule(ctx context.Context, rule AlertRule, condition string) error {
+	item, err := attributevalue.MarshalMap(rule)
+	if err != nil {
+		return fmt.Errorf("failed to marshal alert rule %s: %w", rule.RuleId, err)
+	}
+	for k, v := range ruleKey(rule.RuleId) {
+		item[k] = v
+	}
+
+	_, err = dynamoClient.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           dynamoTable,
+		Item:                item,
+		ConditionExpression: aws.String(condition),
+	})
+	return conditionalError(err)
+}
+
+// conditionalError translates a failed DynamoDB condition into errRuleNotFound
+func conditionalError(err error) error {
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return errRuleNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("error while writing alert rule in DynamoDB: %w", err)
+	}
+	return nil
+}
+
+func ruleKey(ruleId string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{
+			Value: ALERT_RULES_PK,
+		},
+		"SK": &types.AttributeValueMemberS{
+			Value: fmt.Sprintf("RuleId#%s", ruleId),
+		},
+	}
+}
+
+func storageError(err error) events.APIGatewayProxyResponse {
+	if errors.Is(err, errRuleNotFound) {
+		return clientError(404, err.Error())
+	}
+	log.Println(err)
+	return serverSideError()
+}
+
+func jsonResponse(statusCode int, payload any) events.APIGatewayProxyResponse {
+	jsonBytes, err := json.Marshal(payload)
+	if err != nil {
+		log.Println(err)
+		return serverSideError()
+	}
+	return events.APIGatewayProxyResponse{
+		Body:       string(jsonBytes),
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}
+}
+
+func clientError(statusCode int, msg string) events.APIGatewayProxyResponse {
+	log.Println(msg)
+	return events.APIGatewayProxyResponse{
+		Body:       msg,
+		StatusCode: statusCode,
+	}
+}
+
+func serverSideError() events.APIGatewayProxyResponse {
+	return events.APIGatewayProxyResponse{
+		Body:       "failed to access the alert rules",
+		StatusCode: 500,
+	}
+}
+
+func main() {
+	lambda.Start(handler)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// attributeValue is a DynamoDB attribute value in its JSON form, e.g. {"S": "ALERT_RULES"}
+type attributeValue map[string]string
+
+// fakeDynamo is a local stand-in of DynamoDB holding the keys of its items, supporting the deletes of the rules
+// and the queries of the alert states by prefix of their sort key
+type fakeDynamo struct {
+	mutex sync.Mutex
+	// sort keys of the items, per partition key
+	items map[string][]string
+}
+
+func (f *fakeDynamo) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+
+	input := struct {
+		Key                       map[string]attributeValue
+		ExpressionAttributeValues map[string]attributeValue
+		RequestItems              map[string][]struct {
+			DeleteRequest struct{ Key map[string]attributeValue }
+		}
+	}{}
+	json.NewDecoder(r.Body).Decode(&input)
+
+	switch r.Header.Get("X-Amz-Target") {
+	case "DynamoDB_20120810.DeleteItem":
+		if !f.remove(input.Key["PK"]["S"], input.Key["SK"]["S"]) {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"__type": "com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException", "message": "failed"}`)
+			return
+		}
+		fmt.Fprint(w, `{}`)
+	case "DynamoDB_20120810.Query":
+		// the key condition PK = :0 AND begins_with(SK, :1)
+		partition, prefix := input.ExpressionAttributeValues[":0"]["S"], input.ExpressionAttributeValues[":1"]["S"]
+		items := []map[string]attributeValue{}
+		for _, sortKey := range f.items[partition] {
+			if strings.HasPrefix(sortKey, prefix) {
+				items = append(items, map[string]attributeValue{"PK": {"S": partition}, "SK": {"S": sortKey}})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"Items": items, "Count": len(items)})
+	case "DynamoDB_20120810.BatchWriteItem":
+		for _, requests := range input.RequestItems {
+			if len(requests) > maxBatchSize {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"__type": "com.amazon.coral.validate#ValidationException", "message": "too many items"}`)
+				return
+			}
+			for _, request := range requests {
+				f.remove(request.DeleteRequest.Key["PK"]["S"], request.DeleteRequest.Key["SK"]["S"])
+			}
+		}
+		fmt.Fprint(w, `{"UnprocessedItems": {}}`)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"__type": "com.amazon.coral.validate#ValidationException", "message": "unsupported %s"}`, r.Header.Get("X-Amz-Target"))
+	}
+}
+
+func (f *fakeDynamo) remove(partition, sortKey string) bool {
+	for i, existing := range f.items[partition] {
+		if existing == sortKey {
+			f.items[partition] = append(f.items[partition][:i], f.items[partition][i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// withFakeDynamo points the lambda to a local stand-in of DynamoDB
+func withFakeDynamo(t *testing.T, fake *fakeDynamo) {
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	dynamoClient = dynamodb.New(dynamodb.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "test", SecretAccessKey: "test"}, nil
+		}),
+		RetryMaxAttempts: 1,
+	})
+	dynamoTable = aws.String("weather")
+}
+
+func TestDeleteRuleDeletesItsAlertStates(t *testing.T) {
+	states := []string{"RuleId#other#DeviceId#1001"}
+	for deviceId := range 60 {
+		states = append(states, fmt.Sprintf("RuleId#hot#DeviceId#%d", 1001+deviceId))
+	}
+	fake := &fakeDynamo{items: map[string][]string{
+		ALERT_RULES_PK:  {"RuleId#hot", "RuleId#other"},
+		ALERT_STATES_PK: states,
+	}}
+	withFakeDynamo(t, fake)
+
+	request := events.APIGatewayProxyRequest{Resource: "/alerts/rules/{rule_id}", HTTPMethod: "DELETE", PathParameters: map[string]string{"rule_id": "hot"}}
+	response, err := handler(context.Background(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != 204 {
+		t.Fatalf("status %d, expected 204: %s", response.StatusCode, response.Body)
+	}
+	if fmt.Sprint(fake.items[ALERT_RULES_PK]) != "[RuleId#other]" || fmt.Sprint(fake.items[ALERT_STATES_PK]) != "[RuleId#other#DeviceId#1001]" {
+		t.Errorf("rules %v and states %v left, expected those of the other rule only", fake.items[ALERT_RULES_PK], fake.items[ALERT_STATES_PK])
+	}
+
+	// the states left by a previous DELETE are still deleted
+	fake.items[ALERT_STATES_PK] = append(fake.items[ALERT_STATES_PK], "RuleId#hot#DeviceId#1001")
+	if response, _ := handler(context.Background(), request); response.StatusCode != 404 {
+		t.Errorf("status %d, expected 404 for a deleted rule", response.StatusCode)
+	}
+	if len(fake.items[ALERT_STATES_PK]) != 1 {
+		t.Errorf("states %v left, expected those of the other rule only", fake.items[ALERT_STATES_PK])
+	}
+}
+
+func TestCheckWebhook(t *testing.T) {
+	tests := []struct {
+		webhook string
+		valid   bool
+	}{
+		{"https://hooks.example.com/alerts", true},
+		{"https://93.184.216.34/alerts", true},
+		{"https://hooks.example.com:8443/alerts?token=abc", true},
+		{"http://hooks.example.com/alerts", false},
+		{"ftp://hooks.example.com/alerts", false},
+		{"hooks.example.com/alerts", false},
+		{"https:///alerts", false},
+		{"https://localhost/alerts", false},
+		{"https://LOCALHOST./alerts", false},
+		{"https://api.localhost/alerts", false},
+		{"https://127.0.0.1/alerts", false},
+		{"https://[::1]/alerts", false},
+		{"https://10.1.2.3/alerts", false},
+		{"https://172.20.0.1/alerts", false},
+		{"https://192.168.0.10/alerts", false},
+		{"https://169.254.169.254/latest/meta-data/", false},
+		{"https://[fd00:ec2::254]/latest/meta-data/", false},
+		{"https://[::ffff:127.0.0.1]/alerts", false},
+		{"https://0.0.0.0/alerts", false},
+	}
+	for _, test := range tests {
+		if err := checkWebhook(test.webhook); (err == nil) != test.valid {
+			t.Errorf("%s: error %v, expected valid %v", test.webhook, err, test.valid)
+		}
+	}
+}
+
+func TestParseRuleRefusesLocalWebhooks(t *testing.T) {
+	body := `{"Name": "hot", "EventType": "Temperature", "Operator": ">", "Threshold": 30, "Webhooks": ["https://hooks.example.com/alerts", "http://169.254.169.254/"]}`
+	if rule, err := parseRule(body); err == nil {
+		t.Errorf("parsed %+v, expected the metadata webhook to be refused", rule)
+	}
+}
@@ -0,0 +1,149 @@
+// Lambda listening to the weather events expired from DynamoDB (through its TTL), read from the Kinesis data stream
+// of the table, and archiving them to S3 as gzipped NDJSON files, partitioned by device and date.
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"weather_data_generator/weather_storage"
+)
+
+var s3Client *s3.Client
+var archiveBucket *string
+
+// root of the archive files in the bucket
+const archivePrefix = "weather-events"
+
+func init() {
+	sdkConfig, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		log.Fatal("Could not connect to AWS API", err)
+	}
+
+	archiveBucket = aws.String(os.Getenv("ARCHIVE_BUCKET"))
+	s3Client = newS3Client(sdkConfig, os.Getenv("S3_ENDPOINT"))
+}
+
+// newS3Client connects to S3, or to the local S3-compatible stand-in (e.g. MinIO) at that endpoint if not empty
+func newS3Client(sdkConfig aws.Config, s3Endpoint string) *s3.Client {
+	return s3.NewFromConfig(sdkConfig, func(o *s3.Options) {
+		if s3Endpoint != "" {
+			o.BaseEndpoint = &s3Endpoint
+			o.UsePathStyle = true
+		}
+	})
+}
+
+// WeatherEvent is the archived form of the events, without their empty fields
+type WeatherEvent struct {
+	DeviceId  int64
+	Time      time.Time
+	EventType string
+	Value     float64
+	Unit      string `json:",omitempty"`
+	Fault     string `json:",omitempty"`
+}
+
+// partition of the archive, i.e. one directory in the bucket
+type partition struct {
+	deviceId int64
+	date     string
+}
+
+// handler archives the expired events of that batch, as one file per partition. Files are named after the
+// first stream record of the batch, such that a retried batch overwrites its previous, partial, archive.
+func handler(ctx context.Context, event events.KinesisEvent) error {
+	timeBoxedCtx, cancel := context.WithTimeout(ctx, 50*time.Second)
+	defer cancel()
+
+	partitions := map[partition][]WeatherEvent{}
+	batchId := ""
+	for _, record := range event.Records {
+		change, err := weather_storage.ChangeRecord(record)
+		if err != nil {
+			log.Printf("not archiving record: %v", err)
+			continue
+		}
+		if !isExpiry(change) {
+			continue
+		}
+		parsed, err := weather_storage.ParseStreamImage(change.Change.OldImage)
+		if err != nil {
+			log.Printf("not archiving %s record: %v", change.EventName, err)
+			continue
+		}
+		weatherEvent := WeatherEvent(parsed)
+		if batchId == "" {
+			batchId = record.Kinesis.SequenceNumber
+		}
+		key := partition{deviceId: weatherEvent.DeviceId, date: weatherEvent.Time.UTC().Format(time.DateOnly)}
+		partitions[key] = append(partitions[key], weatherEvent)
+	}
+
+	for key, weatherEvents := range partitions {
+		if err := archive(timeBoxedCtx, key, batchId, weatherEvents); err != nil {
+			// failing the whole batch, such that it is retried
+			return fmt.Errorf("failed to archive %d events of device %d on %s: %w", len(weatherEvents), key.deviceId, key.date, err)
+		}
+	}
+	return nil
+}
+
+// isExpiry tells whether that record is the removal of an event by the TTL of the table, as opposed to an explicit delete
+func isExpiry(record events.DynamoDBEventRecord) bool {
+	return record.EventName == string(events.DynamoDBOperationTypeRemove) &&
+		record.UserIdentity != nil &&
+		record.UserIdentity.Type == "Service" &&
+		record.UserIdentity.PrincipalID == "dynamodb.amazonaws.com"
+}
+
+// archive writes those events to S3 as one gzipped NDJSON file, e.g.
+// weather-events/device_id=1001/date=2024-03-01/49590338271490256608559692538361571095921575989136588898.ndjson.gz
+func archive(ctx context.Context, key partition, batchId string, weatherEvents []WeatherEvent) error {
+	sort.Slice(weatherEvents, func(i, j int) bool {
+		return weatherEvents[i].Time.Before(weatherEvents[j].Time)
+	})
+
+	var buffer bytes.Buffer
+	zipper := gzip.NewWriter(&buffer)
+	encoder := json.NewEncoder(zipper)
+	for _, weatherEvent := range weatherEvents {
+		if err := encoder.Encode(weatherEvent); err != nil {
+			return fmt.Errorf("failed to serialize %v: %w", weatherEvent, err)
+		}
+	}
+	if err := zipper.Close(); err != nil {
+		return fmt.Errorf("failed to compress archive: %w", err)
+	}
+
+	objectKey := fmt.Sprintf("%s/device_id=%d/date=%s/%s.ndjson.gz", archivePrefix, key.deviceId, key.date, batchId)
+	if _, err := s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:          archiveBucket,
+		Key:             aws.String(objectKey),
+		Body:            bytes.NewReader(buffer.Bytes()),
+		ContentType:     aws.String("application/x-ndjson"),
+		ContentEncoding: aws.String("gzip"),
+	}); err != nil {
+		return fmt.Errorf("error while writing s3://%s/%s: %w", *archiveBucket, objectKey, err)
+	}
+	log.Printf("archived %d events to s3://%s/%s", len(weatherEvents), *archiveBucket, objectKey)
+	return nil
+}
+
+func main() {
+	lambda.Start(handler)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// fakeS3 is a local S3-compatible stand-in, storing the objects put in its bucket
+type fakeS3 struct {
+	mutex   sync.Mutex
+	objects map[string][]byte
+	headers map[string]http.Header
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "unsupported", http.StatusNotImplemented)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.objects[r.URL.Path] = body
+	f.headers[r.URL.Path] = r.Header
+	w.WriteHeader(http.StatusOK)
+}
+
+// withFakeS3 points the archiver to a local stand-in serving that handler
+func withFakeS3(t *testing.T, handler http.Handler) {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	sdkConfig := aws.Config{
+		Region: "us-east-1",
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "test", SecretAccessKey: "test"}, nil
+		}),
+		RetryMaxAttempts: 1,
+	}
+	s3Client = newS3Client(sdkConfig, server.URL)
+	archiveBucket = aws.String("archive")
+}
+
+// expiry is the record of the Kinesis data stream of the table for the removal of that event by the TTL
+func expiry(sequenceNumber string, deviceId int64, unixTime int64, eventType string, value string) events.KinesisEventRecord {
+	return kinesisRecord(sequenceNumber, map[string]any{
+		"eventID":      "id-" + sequenceNumber,
+		"eventName":    string(events.DynamoDBOperationTypeRemove),
+		"userIdentity": map[string]string{"type": "Service", "principalId": "dynamodb.amazonaws.com"},
+		"recordFormat": "application/json",
+		"tableName":    "weather",
+		"eventSource":  "aws:dynamodb",
+		"dynamodb": map[string]any{
+			"OldImage": map[string]events.DynamoDBAttributeValue{
+				"DeviceId":  events.NewNumberAttribute(strconv.FormatInt(deviceId, 10)),
+				"Time":      events.NewNumberAttribute(strconv.FormatInt(unixTime, 10)),
+				"EventType": events.NewStringAttribute(eventType),
+				"Value":     events.NewNumberAttribute(value),
+				"Unit":      events.NewStringAttribute("°C"),
+			},
+		},
+	})
+}
+
+func kinesisRecord(sequenceNumber string, change map[string]any) events.KinesisEventRecord {
+	data, err := json.Marshal(change)
+	if err != nil {
+		panic(err)
+	}
+	return events.KinesisEventRecord{Kinesis: events.KinesisRecord{SequenceNumber: sequenceNumber, Data: data}}
+}
+
+func readArchive(t *testing.T, object []byte) []WeatherEvent {
+	t.Helper()
+	unzipper, err := gzip.NewReader(bytes.NewReader(object))
+	if err != nil {
+		t.Fatalf("archive not gzipped: %v", err)
+	}
+	weatherEvents := []WeatherEvent{}
+	decoder := json.NewDecoder(unzipper)
+	for decoder.More() {
+		weatherEvent := WeatherEvent{}
+		if err := decoder.Decode(&weatherEvent); err != nil {
+			t.Fatalf("invalid NDJSON: %v", err)
+		}
+		weatherEvents = append(weatherEvents, weatherEvent)
+	}
+	return weatherEvents
+}
+
+func TestHandlerArchivesExpiredEvents(t *testing.T) {
+	s3 := &fakeS3{objects: map[string][]byte{}, headers: map[string]http.Header{}}
+	withFakeS3(t, s3)
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC).Unix()
+	// an explicit delete
+	deleted := kinesisRecord("100", map[string]any{
+		"eventName": string(events.DynamoDBOperationTypeRemove),
+		"dynamodb":  map[string]any{"OldImage": map[string]events.DynamoDBAttributeValue{"DeviceId": events.NewNumberAttribute("1001")}},
+	})
+
+	err := handler(context.Background(), events.KinesisEvent{Records: []events.KinesisEventRecord{
+		deleted,
+		{Kinesis: events.KinesisRecord{SequenceNumber: "100", Data: []byte("not json")}},
+		expiry("101", 1001, day+120, "Temperature", "12.5"),
+		expiry("102", 1001, day+60, "Temperature", "12"),
+		expiry("103", 1002, day+60, "Temperature", "8"),
+		expiry("104", 1001, day+24*3600, "Temperature", "9"),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string][]float64{
+		"/archive/weather-events/device_id=1001/date=2024-03-01/101.ndjson.gz": {12, 12.5},
+		"/archive/weather-events/device_id=1002/date=2024-03-01/101.ndjson.gz": {8},
+		"/archive/weather-events/device_id=1001/date=2024-03-02/101.ndjson.gz": {9},
+	}
+	if len(s3.objects) != len(expected) {
+		t.Errorf("archived %d objects, expected %d", len(s3.objects), len(expected))
+	}
+	for path, values := range expected {
+		object, ok := s3.objects[path]
+		if !ok {
+			t.Errorf("missing object %s", path)
+			continue
+		}
+		if s3.headers[path].Get("Content-Encoding") != "gzip" || s3.headers[path].Get("Content-Type") != "application/x-ndjson" {
+			t.Errorf("object %s stored with headers %v", path, s3.headers[path])
+		}
+		weatherEvents := readArchive(t, object)
+		if len(weatherEvents) != len(values) {
+			t.Errorf("%d events in %s, expected %d", len(weatherEvents), path, len(values))
+			continue
+		}
+		for i, weatherEvent := range weatherEvents {
+			if weatherEvent.Value != values[i] || weatherEvent.Unit != "°C" {
+				t.Errorf("event %d of %s is %+v, expected value %v", i, path, weatherEvent, values[i])
+			}
+		}
+	}
+}
+
+func TestHandlerFailsWhenS3Fails(t *testing.T) {
+	withFakeS3(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+
+	err := handler(context.Background(), events.KinesisEvent{Records: []events.KinesisEventRecord{
+		expiry("101", 1001, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC).Unix(), "Temperature", "12"),
+	}})
+	if err == nil {
+		t.Error("no error, expected the batch to be retried")
+	}
+}
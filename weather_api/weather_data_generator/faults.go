@@ -0,0 +1,108 @@
+// Persistence of the faults injected by the generator, such that they can span several invocations.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"weather_data_generator/weather_generator"
+)
+
+const FAULTS_PK string = "FAULTS"
+
+// injectFaults alters the given events according to the fault config, and
+// returns the resulting events plus the duplicates to be submitted afterwards.
+func injectFaults(ctx context.Context, generator weather_generator.Generator, config weather_generator.FaultConfig, weatherEvents []WeatherEvent) ([]WeatherEvent, []WeatherEvent) {
+	activeFaults, err := loadActiveFaults(ctx)
+	if err != nil {
+		log.Println("could not load active faults, only starting new ones", err)
+		activeFaults = map[string]weather_generator.ActiveFault{}
+	}
+
+	injection := generator.InjectFaults(config, activeFaults, weatherEvents)
+
+	if err := storeActiveFaults(ctx, injection.StartedFaults); err != nil {
+		log.Println("failed to persist started faults", err)
+	}
+
+	return injection.Events, injection.Duplicates
+}
+
+// loadActiveFaults fetches the faults currently stored in DynamoDB, indexed by key
+func loadActiveFaults(ctx context.Context) (map[string]weather_generator.ActiveFault, error) {
+	expr, err := expression.NewBuilder().
+		WithKeyCondition(
+			expression.Key("PK").Equal(expression.Value(FAULTS_PK)),
+		).
+		Build()
+	if err != nil {
+		return nil, fmt.Errorf("error while building DynamoDB query: %w", err)
+	}
+
+	query := dynamodb.QueryInput{
+		TableName:                 dynamoTable,
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	}
+
+	queryResult, err := dynamodbClient.Query(ctx, &query)
+	if err != nil {
+		return nil, fmt.Errorf("error while querying DynamodDB: %w", err)
+	}
+
+	activeFaults := map[string]weather_generator.ActiveFault{}
+	for _, rawFault := range queryResult.Items {
+		fault := weather_generator.ActiveFault{}
+		if err := attributevalue.UnmarshalMap(rawFault, &fault); err != nil {
+			log.Printf("failed to parse fault %v, skipping %v", rawFault, err)
+			continue
+		}
+		activeFaults[fault.Key()] = fault
+	}
+	return activeFaults, nil
+}
+
+// storeActiveFaults persists newly started faults, overwriting any expired
+// one on the same sensor
+func storeActiveFaults(ctx context.Context, faults []weather_generator.ActiveFault) error {
+	for _, fault := range faults {
+		putItem := dynamodb.PutItemInput{
+			TableName: dynamoTable,
+			Item: map[string]types.AttributeValue{
+				"PK": &types.AttributeValueMemberS{
+					Value: FAULTS_PK,
+				},
+				"SK": &types.AttributeValueMemberS{
+					Value: fault.Key(),
+				},
+				"DeviceId": &types.AttributeValueMemberN{
+					Value: fmt.Sprintf("%d", fault.DeviceId),
+				},
+				"EventType": &types.AttributeValueMemberS{
+					Value: fault.EventType,
+				},
+				"Fault": &types.AttributeValueMemberS{
+					Value: string(fault.Fault),
+				},
+				"Until": &types.AttributeValueMemberN{
+					Value: strconv.FormatInt(fault.Until, 10),
+				},
+				"Value": &types.AttributeValueMemberN{
+					Value: fmt.Sprintf("%f", fault.Value),
+				},
+			},
+		}
+		if _, err := dynamodbClient.PutItem(ctx, &putItem); err != nil {
+			return fmt.Errorf("error while inserting fault %s in DyanmoDB: %w", fault.Key(), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+// Fleet of devices for which random events are generated: the simulated ones of the device registry.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+const DEVICES_PK string = "DEVICES"
+
+// Device is the part of a registered device relevant to the generator
+type Device struct {
+	DeviceId int64
+	// event types reported by this device, or all of them if empty
+	Sensors []string
+	Status  string
+	// whether random events are generated for this device, the other ones being real stations
+	Simulated bool
+}
+
+// defaultFleet is used as long as no device at all is registered
+func defaultFleet() []Device {
+	fleet := make([]Device, 0, 10)
+	for i := range 10 {
+		fleet = append(fleet, Device{DeviceId: int64(1000 + i), Status: "active", Simulated: true})
+	}
+	return fleet
+}
+
+// loadDevices fetches all the devices of the device registry
+func loadDevices(ctx context.Context) ([]Device, error) {
+	expr, err := expression.NewBuilder().
+		WithKeyCondition(
+			expression.Key("PK").Equal(expression.Value(DEVICES_PK)),
+		).
+		Build()
+	if err != nil {
+		return nil, fmt.Errorf("error while building DynamoDB query: %w", err)
+	}
+
+	query := dynamodb.QueryInput{
+		TableName:                 dynamoTable,
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	}
+
+	devices := []Device{}
+	paginator := dynamodb.NewQueryPaginator(dynamodbClient, &query)
+	for paginator.HasMorePages() {
+		queryResult, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error while querying DynamodDB: %w", err)
+		}
+		for _, rawDevice := range queryResult.Items {
+			device := Device{}
+			if err := attributevalue.UnmarshalMap(rawDevice, &device); err != nil {
+				log.Printf("failed to parse device %v, skipping %v", rawDevice, err)
+				continue
+			}
+			devices = append(devices, device)
+		}
+	}
+	return devices, nil
+}
+
+// simulatedFleet returns the active simulated devices among those registered, never generating events for real stations,
+// or the default fleet if no device is registered
+func simulatedFleet(devices []Device) []Device {
+	if len(devices) == 0 {
+		log.Println("no device registered, using the default fleet")
+		return defaultFleet()
+	}
+	fleet := []Device{}
+	for _, device := range devices {
+		if device.Simulated && device.Status == "active" {
+			fleet = append(fleet, device)
+		}
+	}
+	return fleet
+}
+
+// onlyInstalledSensors drops the events of sensors not installed on their device
+func onlyInstalledSensors(fleet []Device, weatherEvents []WeatherEvent) []WeatherEvent {
+	sensors := make(map[int64][]string, len(fleet))
+	for _, device := range fleet {
+		sensors[device.DeviceId] = device.Sensors
+	}
+	installed := make([]WeatherEvent, 0, len(weatherEvents))
+	for _, event := range weatherEvents {
+		deviceSensors := sensors[event.DeviceId]
+		if len(deviceSensors) == 0 || slices.Contains(deviceSensors, event.EventType) {
+			installed = append(installed, event)
+		}
+	}
+	return installed
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func fleetIds(fleet []Device) []int64 {
+	ids := []int64{}
+	for _, device := range fleet {
+		ids = append(ids, device.DeviceId)
+	}
+	return ids
+}
+
+func TestSimulatedFleetSkipsRealStations(t *testing.T) {
+	devices := []Device{
+		{DeviceId: 1001, Status: "active", Simulated: true},
+		{DeviceId: 1002, Status: "active"},
+		{DeviceId: 1003, Status: "maintenance", Simulated: true},
+		{DeviceId: 1004, Status: "active", Simulated: true},
+	}
+
+	fleet := simulatedFleet(devices)
+
+	if ids := fleetIds(fleet); !slices.Equal(ids, []int64{1001, 1004}) {
+		t.Errorf("fleet %v, expected the active simulated devices 1001 and 1004", ids)
+	}
+}
+
+func TestSimulatedFleetWithoutSimulatedDevice(t *testing.T) {
+	fleet := simulatedFleet([]Device{{DeviceId: 1000, Status: "active"}})
+
+	if len(fleet) != 0 {
+		t.Errorf("fleet %v, expected none rather than the default fleet overlapping the real station 1000", fleetIds(fleet))
+	}
+}
+
+func TestSimulatedFleetDefault(t *testing.T) {
+	fleet := simulatedFleet([]Device{})
+
+	if len(fleet) != 10 || fleet[0].DeviceId != 1000 {
+		t.Errorf("fleet %v, expected the default devices 1000 to 1009", fleetIds(fleet))
+	}
+}
+
+func TestOnlyInstalledSensors(t *testing.T) {
+	fleet := []Device{{DeviceId: 1001, Sensors: []string{"Temperature"}}, {DeviceId: 1002}}
+	events := []WeatherEvent{
+		{DeviceId: 1001, EventType: "Temperature"},
+		{DeviceId: 1001, EventType: "Humidity"},
+		{DeviceId: 1002, EventType: "Humidity"},
+	}
+
+	installed := onlyInstalledSensors(fleet, events)
+
+	if len(installed) != 2 || installed[0].EventType != "Temperature" || installed[1].DeviceId != 1002 {
+		t.Errorf("installed sensors %v, expected the Temperature of 1001 and all those of 1002", installed)
+	}
+}
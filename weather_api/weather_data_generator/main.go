@@ -4,11 +4,11 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
-	"math/rand"
 	"os"
-	"sync"
+	"strconv"
 	"time"
 
 	"github.com/aws/aws-lambda-go/events"
@@ -16,168 +16,143 @@ import (
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"weather_data_generator/weather_generator"
+	"weather_data_generator/weather_storage"
 )
 
 var dynamodbClient *dynamodb.Client
 var dynamoTable *string
+var s3Client *s3.Client
+var writer weather_storage.Writer
+var faultConfig weather_generator.FaultConfig
+var replayConfig ReplayConfig
+
+// how long events are kept in DynamoDB before expiring (and being archived), 0 to keep them forever
+var retention time.Duration
 var ctx context.Context = context.Background()
 
 func init() {
 	dynamoTable = aws.String(os.Getenv("DYNAMO_TABLE"))
 
+	var err error
+	if faultConfig, err = weather_generator.ParseFaultConfig(os.Getenv("FAULT_CONFIG")); err != nil {
+		log.Fatal(err)
+	}
+	if replayConfig, err = parseReplayConfig(os.Getenv("REPLAY_CONFIG")); err != nil {
+		log.Fatal(err)
+	}
+	if retention, err = weather_storage.ParseRetention(os.Getenv("RETENTION_DAYS")); err != nil {
+		log.Fatal(err)
+	}
+
 	sdkConfig, err := config.LoadDefaultConfig(ctx)
 	if err != nil {
 		log.Fatal("Could not connect to dynamo ", err)
 	}
 	dynamodbClient = dynamodb.NewFromConfig(sdkConfig)
+	s3Client = s3.NewFromConfig(sdkConfig)
+	writer = weather_storage.NewWriter(dynamodbClient, *dynamoTable, retention)
 }
 
-type WeatherEvent struct {
-	DeviceId  int64
-	Time      time.Time
-	EventType string
-	Value     float64
+type WeatherEvent = weather_generator.WeatherEvent
+
+// GenerationRequest holds the optional parameters of one generation, passed as
+// detail of the triggering event. Providing both makes the whole batch reproducible.
+//
+// example event: {"detail": {"Seed": 42, "Time": "2024-03-01T12:00:00Z"}}
+type GenerationRequest struct {
+	// seed of the random generator, overriding the GENERATOR_SEED env var
+	Seed *int64
+	// time of the generated events, instead of the current time
+	Time *time.Time
 }
 
 func handler(ctx context.Context, request events.EventBridgeEvent) {
-	log.Println("generating random weather event")
-	events := make([]WeatherEvent, 0, 50)
-	for i := range 10 {
-		deviceId := int64(1000 + i)
-		events = append(events, randomEvents(deviceId)...)
+	generator, err := newGenerator(request)
+	if err != nil {
+		log.Println("invalid generation request", err)
+		return
 	}
-	addAllSamples(ctx, events)
-	log.Println("done")
-}
 
-// randomEvents creates one random weather event of each type for the given deviceID
-func randomEvents(deviceId int64) []WeatherEvent {
-	return []WeatherEvent{
-		randomPressureEvent(deviceId),
-		randomTemperatureEvent(deviceId),
-		randomHumidityEvent(deviceId),
-		randomWindSpeedEvent(deviceId),
-		randomWindDirectionEvent(deviceId),
+	var events []WeatherEvent
+	if replayConfig.isEnabled() {
+		log.Println("replaying recorded weather events")
+		if events, err = replayEvents(ctx, replayConfig, generator.Now()); err != nil {
+			log.Println("failed to replay recorded events", err)
+			return
+		}
+	} else {
+		log.Println("generating random weather event")
+		devices, err := loadDevices(ctx)
+		if err != nil {
+			log.Println("failed to load the fleet from the device registry", err)
+			return
+		}
+		fleet := simulatedFleet(devices)
+		if len(fleet) == 0 {
+			log.Println("no active simulated device registered, nothing to generate")
+			return
+		}
+		deviceIds := make([]int64, 0, len(fleet))
+		for _, device := range fleet {
+			deviceIds = append(deviceIds, device.DeviceId)
+		}
+		events = onlyInstalledSensors(fleet, generator.Batch(deviceIds))
 	}
-}
 
-func randomPressureEvent(deviceId int64) WeatherEvent {
-	return WeatherEvent{
-		DeviceId:  deviceId,
-		Time:      time.Now(),
-		EventType: "Pressure",
-		Value:     float64(rand.Int31n(100) + 950),
+	duplicates := []WeatherEvent{}
+	if faultConfig.IsEnabled() {
+		events, duplicates = injectFaults(ctx, generator, faultConfig, events)
 	}
-}
 
-func randomTemperatureEvent(deviceId int64) WeatherEvent {
-	return WeatherEvent{
-		DeviceId:  deviceId,
-		Time:      time.Now(),
-		EventType: "Temperature",
-		Value:     rand.Float64()*40 - 10,
+	addAllSamples(ctx, events)
+	if len(duplicates) > 0 {
+		// submitted separately since a batch may not contain twice the same key
+		addAllSamples(ctx, duplicates)
 	}
+	log.Println("done")
 }
 
-func randomHumidityEvent(deviceId int64) WeatherEvent {
-	return WeatherEvent{
-		DeviceId:  deviceId,
-		Time:      time.Now(),
-		EventType: "Humidity",
-		Value:     rand.Float64() * 100,
+// newGenerator creates a generator seeded from the request or the GENERATOR_SEED env var, if any,
+// and whose clock is fixed to the requested time, if any.
+func newGenerator(request events.EventBridgeEvent) (weather_generator.Generator, error) {
+	generationRequest := GenerationRequest{}
+	if len(request.Detail) > 0 {
+		if err := json.Unmarshal(request.Detail, &generationRequest); err != nil {
+			return weather_generator.Generator{}, fmt.Errorf("failed to parse event detail: %w", err)
+		}
 	}
-}
 
-func randomWindSpeedEvent(deviceId int64) WeatherEvent {
-	return WeatherEvent{
-		DeviceId:  deviceId,
-		Time:      time.Now(),
-		EventType: "WindSpeed",
-		Value:     float64(rand.Int31n(50)),
+	var clock weather_generator.Clock
+	if generationRequest.Time != nil {
+		clock = weather_generator.FixedClock(*generationRequest.Time)
 	}
-}
 
-func randomWindDirectionEvent(deviceId int64) WeatherEvent {
-	return WeatherEvent{
-		DeviceId:  deviceId,
-		Time:      time.Now(),
-		EventType: "WindDirection",
-		Value:     rand.Float64() * 360,
+	if generationRequest.Seed != nil {
+		log.Printf("using seed %d from the request", *generationRequest.Seed)
+		return weather_generator.NewSeeded(*generationRequest.Seed, clock), nil
 	}
+	if envSeed := os.Getenv("GENERATOR_SEED"); envSeed != "" {
+		seed, err := strconv.ParseInt(envSeed, 10, 64)
+		if err != nil {
+			return weather_generator.Generator{}, fmt.Errorf("invalid GENERATOR_SEED %q: %w", envSeed, err)
+		}
+		log.Printf("using seed %d from GENERATOR_SEED", seed)
+		return weather_generator.NewSeeded(seed, clock), nil
+	}
+	return weather_generator.NewSeeded(time.Now().UnixNano(), clock), nil
 }
 
-// addAllSamples slices the given array into batches of 25 (i.e. the maximum allowed
-// by DynamoDB) and sends them to addSamples.
+// addAllSamples inserts the given events into DynamoDB through writer.AddAll, which writes them as
+// concurrent batches of 25 (i.e. the maximum allowed by DynamoDB).
 // (in theory we should check if keys overlap, although here we know they never do)
 func addAllSamples(ctx context.Context, weatherEvents []WeatherEvent) {
 	log.Println("sending generated data to DB")
-	var waiter = sync.WaitGroup{}
-	for i := 0; i < len(weatherEvents); i += 25 {
-		fromIdx := i
-		toIdx := min(i+25, len(weatherEvents))
-		waiter.Add(1)
-		go func() {
-			defer waiter.Done()
-			if err := addSamples(ctx, weatherEvents[fromIdx:toIdx]); err != nil {
-				log.Println("failed to insert data in Dynamo", err)
-			}
-		}()
+	if err := writer.AddAll(ctx, weatherEvents); err != nil {
+		log.Println("failed to insert data in Dynamo", err)
 	}
-	waiter.Wait()
-}
-
-// addSamples inserts the given weather events into DynamoDB as one single batch
-func addSamples(ctx context.Context, weatherEvents []WeatherEvent) error {
-	log.Println("inserting batch")
-
-	if len(weatherEvents) > 0 && len(weatherEvents) < 26 {
-
-		putRequests := make([]types.WriteRequest, 0, len(weatherEvents))
-
-		for _, weatherEvent := range weatherEvents {
-			putRequest := types.WriteRequest{
-				PutRequest: &types.PutRequest{
-					Item: map[string]types.AttributeValue{
-						"PK": &types.AttributeValueMemberS{
-							Value: fmt.Sprintf("DeviceId#%d", weatherEvent.DeviceId),
-						},
-						"SK": &types.AttributeValueMemberS{
-							Value: fmt.Sprintf("Time#%d#Type%s", weatherEvent.Time.Unix(), weatherEvent.EventType),
-						},
-						"DeviceId": &types.AttributeValueMemberN{
-							Value: fmt.Sprintf("%d", weatherEvent.DeviceId),
-						},
-						"EventType": &types.AttributeValueMemberS{
-							Value: weatherEvent.EventType,
-						},
-						"Value": &types.AttributeValueMemberN{
-							Value: fmt.Sprintf("%f", weatherEvent.Value),
-						},
-						"Time": &types.AttributeValueMemberN{
-							Value: fmt.Sprintf("%d", weatherEvent.Time.Unix()),
-						},
-					},
-				},
-			}
-			putRequests = append(putRequests, putRequest)
-		}
-
-		input := dynamodb.BatchWriteItemInput{
-			RequestItems: map[string][]types.WriteRequest{
-				*dynamoTable: putRequests,
-			},
-		}
-
-		if _, err := dynamodbClient.BatchWriteItem(ctx, &input); err != nil {
-			return fmt.Errorf("error while inserting events in DyanmoDB %w", err)
-		}
-
-	} else {
-		log.Printf("refusing to insert a batch of size %d", len(weatherEvents))
-	}
-
-	return nil
 }
 
 func main() {
@@ -0,0 +1,336 @@
+// Replay of recorded weather station exports, as an alternative to the random events.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"weather_data_generator/weather_generator"
+)
+
+// period at which the generator is triggered by the EventBridge scheduler
+const generationPeriod = time.Minute
+
+// ReplayConfig describes the recorded dataset to replay and how to map it to weather events.
+//
+// The recording is replayed from Start on: a record timestamped t is emitted with time
+// Start + (t - t0) / Speed, t0 being the time of the first record. Each invocation
+// emits the records falling in the last generation period.
+//
+// example:
+//
+//	{
+//	  "File": "s3://some-bucket/exports/station-2023.csv",
+//	  "Start": "2024-03-01T12:00:00Z",
+//	  "Speed": 60,
+//	  "Columns": {"Time": "timestamp", "DeviceId": "station", "EventType": "metric", "Value": "value"},
+//	  "Stations": {"ZRH-1": 1001},
+//	  "Metrics": {"temp_c": "Temperature"}
+//	}
+type ReplayConfig struct {
+	// local path or s3://bucket/key of a CSV (with header) or NDJSON file
+	File string
+	// "csv" or "ndjson", guessed from the file extension if empty
+	Format string
+	Start  time.Time
+	// replay speed factor, 1 to replay at the original pace
+	Speed float64
+	// name of the columns (or NDJSON fields) of each WeatherEvent field
+	Columns ReplayColumns
+	// optional mapping of non-numeric station names to device ids
+	Stations map[string]int64
+	// optional mapping of recorded metric names to event types. Records of metrics being neither
+	// mapped nor named after an event type are skipped.
+	Metrics map[string]string
+}
+
+type ReplayColumns struct {
+	Time      string
+	DeviceId  string
+	EventType string
+	Value     string
+}
+
+var defaultReplayColumns = ReplayColumns{
+	Time:      "timestamp",
+	DeviceId:  "station",
+	EventType: "metric",
+	Value:     "value",
+}
+
+// parseReplayConfig parses the JSON replay config. An empty config disables the replay.
+func parseReplayConfig(rawConfig string) (ReplayConfig, error) {
+	config := ReplayConfig{}
+	if rawConfig == "" {
+		return config, nil
+	}
+	if err := json.Unmarshal([]byte(rawConfig), &config); err != nil {
+		return config, fmt.Errorf("invalid replay config: %w", err)
+	}
+	if config.File == "" {
+		return config, errors.New("invalid replay config: missing File")
+	}
+	if config.Start.IsZero() {
+		return config, errors.New("invalid replay config: missing Start")
+	}
+	if config.Speed == 0 {
+		config.Speed = 1
+	} else if config.Speed < 0 {
+		return config, errors.New("invalid replay config: Speed should be positive")
+	}
+	if config.Format == "" {
+		config.Format = strings.TrimPrefix(path.Ext(config.File), ".")
+	}
+	if config.Format == "jsonl" {
+		config.Format = "ndjson"
+	}
+	if config.Format != "csv" && config.Format != "ndjson" {
+		return config, fmt.Errorf("invalid replay config: unsupported format %q", config.Format)
+	}
+	if config.Columns == (ReplayColumns{}) {
+		config.Columns = defaultReplayColumns
+	}
+	for metric, eventType := range config.Metrics {
+		if _, ok := weather_generator.LookupEventType(eventType); !ok {
+			return config, fmt.Errorf("invalid replay config: metric %q mapped to unknown event type %q", metric, eventType)
+		}
+	}
+	return config, nil
+}
+
+func (c ReplayConfig) isEnabled() bool {
+	return c.File != ""
+}
+
+// replayTime returns the time at which a record timestamped recordTime is replayed
+func (c ReplayConfig) replayTime(firstRecordTime, recordTime time.Time) time.Time {
+	elapsed := float64(recordTime.Sub(firstRecordTime)) / c.Speed
+	return c.Start.Add(time.Duration(elapsed))
+}
+
+// recording is kept across invocations of a warm lambda, to avoid reloading it every minute
+var recording []WeatherEvent
+
+// replayEvents returns the recorded events to be emitted at that time, with
+// their time shifted to the replay time
+func replayEvents(ctx context.Context, config ReplayConfig, now time.Time) ([]WeatherEvent, error) {
+	if recording == nil {
+		loaded, err := loadRecording(ctx, config)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("loaded %d recorded events from %s", len(loaded), config.File)
+		recording = loaded
+	}
+	if len(recording) == 0 {
+		return nil, nil
+	}
+
+	firstRecordTime := recording[0].Time
+	periodStart := now.Add(-generationPeriod)
+
+	// several records of the same sensor and second would collide in DynamoDB: keep the last one
+	replayed := map[string]WeatherEvent{}
+	for _, record := range recording {
+		replayTime := config.replayTime(firstRecordTime, record.Time)
+		if replayTime.After(now) {
+			break
+		}
+		if replayTime.After(periodStart) {
+			record.Time = replayTime
+			replayed[fmt.Sprintf("%d#%d#%s", record.DeviceId, replayTime.Unix(), record.EventType)] = record
+		}
+	}
+
+	if lastRecordTime := config.replayTime(firstRecordTime, recording[len(recording)-1].Time); lastRecordTime.Before(periodStart) {
+		log.Printf("replay of %s finished at %s", config.File, lastRecordTime)
+	}
+
+	events := make([]WeatherEvent, 0, len(replayed))
+	for _, event := range replayed {
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// loadRecording reads the whole recorded dataset, sorted by time
+func loadRecording(ctx context.Context, config ReplayConfig) ([]WeatherEvent, error) {
+	reader, err := openRecording(ctx, config.File)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var rows []map[string]string
+	if config.Format == "csv" {
+		rows, err = readCsvRows(reader)
+	} else {
+		rows, err = readNdjsonRows(reader)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", config.File, err)
+	}
+
+	events := make([]WeatherEvent, 0, len(rows))
+	for i, row := range rows {
+		event, err := config.toWeatherEvent(row)
+		if err != nil {
+			log.Printf("skipping record %d of %s: %v", i+1, config.File, err)
+			continue
+		}
+		events = append(events, event)
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time.Before(events[j].Time)
+	})
+	return events, nil
+}
+
+// openRecording opens the recording either from S3 or from the local file system
+func openRecording(ctx context.Context, file string) (io.ReadCloser, error) {
+	if bucketAndKey, isS3 := strings.CutPrefix(file, "s3://"); isS3 {
+		bucket, key, found := strings.Cut(bucketAndKey, "/")
+		if !found {
+			return nil, fmt.Errorf("invalid S3 location %s", file)
+		}
+		object, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch %s: %w", file, err)
+		}
+		return object.Body, nil
+	}
+	return os.Open(file)
+}
+
+// readCsvRows reads CSV records, indexed by the column names of the header line
+func readCsvRows(reader io.Reader) ([]map[string]string, error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.TrimLeadingSpace = true
+	header, err := csvReader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+
+	rows := []map[string]string{}
+	for {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		row := make(map[string]string, len(header))
+		for i, column := range header {
+			if i < len(record) {
+				row[column] = record[i]
+			}
+		}
+		rows = append(rows, row)
+	}
+}
+
+// readNdjsonRows reads one JSON object per line, with all values converted to strings
+func readNdjsonRows(reader io.Reader) ([]map[string]string, error) {
+	scanner := bufio.NewScanner(reader)
+	rows := []map[string]string{}
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		rawRow := map[string]any{}
+		// numbers are kept as written, e.g. epoch timestamps rather than 1.70929446e+09
+		decoder := json.NewDecoder(strings.NewReader(line))
+		decoder.UseNumber()
+		if err := decoder.Decode(&rawRow); err != nil {
+			return nil, fmt.Errorf("invalid JSON line %q: %w", line, err)
+		}
+		row := make(map[string]string, len(rawRow))
+		for k, v := range rawRow {
+			if s, ok := v.(string); ok {
+				row[k] = s
+			} else {
+				row[k] = fmt.Sprint(v)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, scanner.Err()
+}
+
+// toWeatherEvent maps one recorded row to a weather event, according to the configured columns
+func (c ReplayConfig) toWeatherEvent(row map[string]string) (WeatherEvent, error) {
+	eventTime, err := parseRecordTime(row[c.Columns.Time])
+	if err != nil {
+		return WeatherEvent{}, err
+	}
+
+	station := row[c.Columns.DeviceId]
+	deviceId, ok := c.Stations[station]
+	if !ok {
+		if deviceId, err = strconv.ParseInt(station, 10, 64); err != nil {
+			return WeatherEvent{}, fmt.Errorf("unknown station %q", station)
+		}
+	}
+
+	eventType := row[c.Columns.EventType]
+	if mapped, ok := c.Metrics[eventType]; ok {
+		eventType = mapped
+	}
+	if eventType == "" {
+		return WeatherEvent{}, errors.New("missing metric")
+	}
+	// unknown event types would not be served nor converted by the API
+	if _, ok := weather_generator.LookupEventType(eventType); !ok {
+		return WeatherEvent{}, fmt.Errorf("unknown metric %q", eventType)
+	}
+
+	value, err := strconv.ParseFloat(row[c.Columns.Value], 64)
+	if err != nil {
+		return WeatherEvent{}, fmt.Errorf("invalid value %q", row[c.Columns.Value])
+	}
+
+	return WeatherEvent{
+		DeviceId:  deviceId,
+		Time:      eventTime,
+		EventType: eventType,
+		Value:     value,
+		Unit:      weather_generator.UnitOf(eventType),
+	}, nil
+}
+
+// parseRecordTime accepts RFC 3339 timestamps as well as unix epoch seconds or milliseconds
+func parseRecordTime(raw string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, raw); err == nil {
+		return t, nil
+	}
+	epoch, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", raw)
+	}
+	// anything beyond year 33658 in seconds is rather a timestamp in milliseconds
+	if epoch > 1e12 {
+		return time.UnixMilli(epoch), nil
+	}
+	return time.Unix(epoch, 0), nil
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseReplayConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		expected ReplayConfig
+		valid    bool
+	}{
+		{"disabled", "", ReplayConfig{}, true},
+		{
+			"defaults",
+			`{"File": "s3://bucket/exports/station.csv", "Start": "2024-03-01T12:00:00Z"}`,
+			ReplayConfig{File: "s3://bucket/exports/station.csv", Format: "csv", Start: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), Speed: 1, Columns: defaultReplayColumns},
+			true,
+		},
+		{
+			"jsonl extension",
+			`{"File": "station.jsonl", "Start": "2024-03-01T12:00:00Z", "Speed": 60, "Columns": {"Time": "t", "DeviceId": "d", "EventType": "m", "Value": "v"}}`,
+			ReplayConfig{File: "station.jsonl", Format: "ndjson", Start: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), Speed: 60, Columns: ReplayColumns{"t", "d", "m", "v"}},
+			true,
+		},
+		{
+			"explicit format",
+			`{"File": "station.export", "Format": "ndjson", "Start": "2024-03-01T12:00:00Z", "Metrics": {"temp_c": "Temperature"}}`,
+			ReplayConfig{File: "station.export", Format: "ndjson", Start: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), Speed: 1, Columns: defaultReplayColumns, Metrics: map[string]string{"temp_c": "Temperature"}},
+			true,
+		},
+		{"invalid json", `{"File": `, ReplayConfig{}, false},
+		{"missing file", `{"Start": "2024-03-01T12:00:00Z"}`, ReplayConfig{}, false},
+		{"missing start", `{"File": "station.csv"}`, ReplayConfig{}, false},
+		{"negative speed", `{"File": "station.csv", "Start": "2024-03-01T12:00:00Z", "Speed": -2}`, ReplayConfig{}, false},
+		{"unsupported format", `{"File": "station.xlsx", "Start": "2024-03-01T12:00:00Z"}`, ReplayConfig{}, false},
+		{"unknown event type", `{"File": "station.csv", "Start": "2024-03-01T12:00:00Z", "Metrics": {"snow_cm": "Snow"}}`, ReplayConfig{}, false},
+	}
+	for _, test := range tests {
+		config, err := parseReplayConfig(test.config)
+		if (err == nil) != test.valid {
+			t.Errorf("%s: error %v, expected valid %v", test.name, err, test.valid)
+			continue
+		}
+		if test.valid && !reflect.DeepEqual(config, test.expected) {
+			t.Errorf("%s: config %+v, expected %+v", test.name, config, test.expected)
+		}
+	}
+}
+
+func TestReadCsvRows(t *testing.T) {
+	tests := []struct {
+		name     string
+		csv      string
+		expected []map[string]string
+		valid    bool
+	}{
+		{
+			"header and rows",
+			"timestamp,station,metric,value\n2024-03-01T12:00:00Z,ZRH-1,temp_c,4.5\n1709294460, 1001, Humidity, 80\n",
+			[]map[string]string{
+				{"timestamp": "2024-03-01T12:00:00Z", "station": "ZRH-1", "metric": "temp_c", "value": "4.5"},
+				{"timestamp": "1709294460", "station": "1001", "metric": "Humidity", "value": "80"},
+			},
+			true,
+		},
+		{"header only", "timestamp,station,metric,value\n", []map[string]string{}, true},
+		{
+			"quoted field",
+			"timestamp,station,metric,value\n2024-03-01T12:00:00Z,\"Zurich, 1\",temp_c,4.5\n",
+			[]map[string]string{{"timestamp": "2024-03-01T12:00:00Z", "station": "Zurich, 1", "metric": "temp_c", "value": "4.5"}},
+			true,
+		},
+		{"empty", "", nil, false},
+		{"missing column", "timestamp,station,metric,value\n2024-03-01T12:00:00Z,1001,temp_c\n", nil, false},
+	}
+	for _, test := range tests {
+		rows, err := readCsvRows(strings.NewReader(test.csv))
+		if (err == nil) != test.valid {
+			t.Errorf("%s: error %v, expected valid %v", test.name, err, test.valid)
+			continue
+		}
+		if test.valid && !reflect.DeepEqual(rows, test.expected) {
+			t.Errorf("%s: rows %v, expected %v", test.name, rows, test.expected)
+		}
+	}
+}
+
+func TestReadNdjsonRows(t *testing.T) {
+	tests := []struct {
+		name     string
+		ndjson   string
+		expected []map[string]string
+		valid    bool
+	}{
+		{
+			"strings and numbers",
+			`{"timestamp": "2024-03-01T12:00:00Z", "station": "ZRH-1", "metric": "temp_c", "value": 4.5}` + "\n" +
+				`{"timestamp": 1709294460, "station": 1001, "metric": "Humidity", "value": 80}` + "\n" +
+				`{"timestamp": 1709294460250, "station": 1001, "metric": "Humidity", "value": 8e1, "calibrated": true}` + "\n",
+			[]map[string]string{
+				{"timestamp": "2024-03-01T12:00:00Z", "station": "ZRH-1", "metric": "temp_c", "value": "4.5"},
+				{"timestamp": "1709294460", "station": "1001", "metric": "Humidity", "value": "80"},
+				{"timestamp": "1709294460250", "station": "1001", "metric": "Humidity", "value": "8e1", "calibrated": "true"},
+			},
+			true,
+		},
+		{
+			"blank lines and no trailing newline",
+			"\n  \n" + `{"station": "1001", "value": -3}`,
+			[]map[string]string{{"station": "1001", "value": "-3"}},
+			true,
+		},
+		{"empty", "", []map[string]string{}, true},
+		{"invalid line", `{"station": "1001"}` + "\n" + `station=1001`, nil, false},
+	}
+	for _, test := range tests {
+		rows, err := readNdjsonRows(strings.NewReader(test.ndjson))
+		if (err == nil) != test.valid {
+			t.Errorf("%s: error %v, expected valid %v", test.name, err, test.valid)
+			continue
+		}
+		if test.valid && !reflect.DeepEqual(rows, test.expected) {
+			t.Errorf("%s: rows %v, expected %v", test.name, rows, test.expected)
+		}
+	}
+}
+
+func TestParseRecordTime(t *testing.T) {
+	tests := []struct {
+		raw      string
+		expected time.Time
+		valid    bool
+	}{
+		{"2024-03-01T12:00:00Z", time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), true},
+		{"2024-03-01T13:00:00+01:00", time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), true},
+		{"2024-03-01T12:00:00.250Z", time.Date(2024, 3, 1, 12, 0, 0, 250e6, time.UTC), true},
+		{"1709294400", time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), true},
+		{"1709294400250", time.Date(2024, 3, 1, 12, 0, 0, 250e6, time.UTC), true},
+		{"0", time.Unix(0, 0), true},
+		// the largest epoch still read in seconds
+		{"1000000000000", time.Unix(1e12, 0), true},
+		{"1000000000001", time.UnixMilli(1e12 + 1), true},
+		{"", time.Time{}, false},
+		{"2024-03-01 12:00:00", time.Time{}, false},
+		{"1709294400.5", time.Time{}, false},
+		{"yesterday", time.Time{}, false},
+	}
+	for _, test := range tests {
+		parsed, err := parseRecordTime(test.raw)
+		if (err == nil) != test.valid {
+			t.Errorf("%q: error %v, expected valid %v", test.raw, err, test.valid)
+			continue
+		}
+		if test.valid && !parsed.Equal(test.expected) {
+			t.Errorf("%q: parsed %v, expected %v", test.raw, parsed, test.expected)
+		}
+	}
+}
+
+func TestToWeatherEvent(t *testing.T) {
+	config := ReplayConfig{
+		Columns:  defaultReplayColumns,
+		Stations: map[string]int64{"ZRH-1": 1001},
+		Metrics:  map[string]string{"temp_c": "Temperature"},
+	}
+	eventTime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		row      map[string]string
+		expected WeatherEvent
+		valid    bool
+	}{
+		{
+			"mapped station and metric",
+			map[string]string{"timestamp": "2024-03-01T12:00:00Z", "station": "ZRH-1", "metric": "temp_c", "value": "4.5"},
+			WeatherEvent{DeviceId: 1001, Time: eventTime, EventType: "Temperature", Value: 4.5, Unit: "°C"},
+			true,
+		},
+		{
+			"numeric station and event type name",
+			map[string]string{"timestamp": "1709294400", "station": "1005", "metric": "Humidity", "value": "80"},
+			WeatherEvent{DeviceId: 1005, Time: eventTime, EventType: "Humidity", Value: 80, Unit: "%"},
+			true,
+		},
+		{"invalid time", map[string]string{"timestamp": "noon", "station": "1005", "metric": "Humidity", "value": "80"}, WeatherEvent{}, false},
+		{"unknown station", map[string]string{"timestamp": "1709294400", "station": "GVA-1", "metric": "Humidity", "value": "80"}, WeatherEvent{}, false},
+		{"missing metric", map[string]string{"timestamp": "1709294400", "station": "1005", "value": "80"}, WeatherEvent{}, false},
+		{"unknown metric", map[string]string{"timestamp": "1709294400", "station": "1005", "metric": "snow_cm", "value": "12"}, WeatherEvent{}, false},
+		{"event type case", map[string]string{"timestamp": "1709294400", "station": "1005", "metric": "humidity", "value": "80"}, WeatherEvent{}, false},
+		{"invalid value", map[string]string{"timestamp": "1709294400", "station": "1005", "metric": "Humidity", "value": "n/a"}, WeatherEvent{}, false},
+	}
+	for _, test := range tests {
+		event, err := config.toWeatherEvent(test.row)
+		if (err == nil) != test.valid {
+			t.Errorf("%s: error %v, expected valid %v", test.name, err, test.valid)
+			continue
+		}
+		if test.valid && (!event.Time.Equal(test.expected.Time) || event.DeviceId != test.expected.DeviceId ||
+			event.EventType != test.expected.EventType || event.Value != test.expected.Value || event.Unit != test.expected.Unit) {
+			t.Errorf("%s: event %+v, expected %+v", test.name, event, test.expected)
+		}
+	}
+}
+
+func TestReplayTime(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	firstRecordTime := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		speed      float64
+		recordTime time.Time
+		expected   time.Time
+	}{
+		{1, firstRecordTime, start},
+		{1, firstRecordTime.Add(time.Hour), start.Add(time.Hour)},
+		{60, firstRecordTime, start},
+		{60, firstRecordTime.Add(time.Hour), start.Add(time.Minute)},
+		{0.5, firstRecordTime.Add(time.Minute), start.Add(2 * time.Minute)},
+		{3, firstRecordTime.Add(time.Second), start.Add(333333333 * time.Nanosecond)},
+	}
+	for _, test := range tests {
+		config := ReplayConfig{Start: start, Speed: test.speed}
+		if replayTime := config.replayTime(firstRecordTime, test.recordTime); !replayTime.Equal(test.expected) {
+			t.Errorf("speed %v, record at %v: replayed at %v, expected %v", test.speed, test.recordTime, replayTime, test.expected)
+		}
+	}
+}
+
+func TestReplayEvents(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "station.csv")
+	recorded := "timestamp,station,metric,value\n" +
+		// out of order, as loadRecording sorts the records
+		"2023-06-01T00:02:00Z,1001,Temperature,12\n" +
+		"2023-06-01T00:00:00Z,1001,Temperature,10\n" +
+		"2023-06-01T00:01:00Z,1001,Temperature,11\n" +
+		"2023-06-01T00:01:00Z,1001,Humidity,70\n" +
+		"2023-06-01T00:01:30Z,1001,snow_cm,4\n" +
+		"2023-06-01T00:03:00Z,1001,Temperature,13\n"
+	if err := os.WriteFile(file, []byte(recorded), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	recording = nil
+	t.Cleanup(func() { recording = nil })
+
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	config, err := parseReplayConfig(`{"File": "` + file + `", "Start": "2024-03-01T12:00:00Z", "Speed": 2}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// at speed 2, the records of the first 2 minutes are replayed during the first minute after Start,
+	// and those replayed exactly at the start of the period belong to the previous one
+	tests := []struct {
+		now      time.Time
+		expected []string
+	}{
+		{start.Add(-time.Minute), []string{}},
+		{start, []string{"1001 Temperature 10 at 12:00:00"}},
+		{start.Add(time.Minute), []string{"1001 Humidity 70 at 12:00:30", "1001 Temperature 11 at 12:00:30", "1001 Temperature 12 at 12:01:00"}},
+		{start.Add(90 * time.Second), []string{"1001 Temperature 12 at 12:01:00", "1001 Temperature 13 at 12:01:30"}},
+		{start.Add(time.Hour), []string{}},
+	}
+	for _, test := range tests {
+		events, err := replayEvents(context.Background(), config, test.now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		described := describeEvents(events)
+		if !reflect.DeepEqual(described, test.expected) {
+			t.Errorf("at %v: replayed %v, expected %v", test.now, described, test.expected)
+		}
+	}
+	if len(recording) != 5 {
+		t.Errorf("%d recorded events, expected all but the unknown metric", len(recording))
+	}
+}
+
+func TestReplayEventsKeepsLastRecordOfSameSecond(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	recorded := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	recording = []WeatherEvent{
+		{DeviceId: 1001, Time: recorded, EventType: "Temperature", Value: 10},
+		{DeviceId: 1001, Time: recorded.Add(time.Second), EventType: "Temperature", Value: 11},
+		{DeviceId: 1002, Time: recorded.Add(time.Second), EventType: "Temperature", Value: 20},
+	}
+	t.Cleanup(func() { recording = nil })
+
+	// at speed 10, the first two records are replayed within the same second
+	config := ReplayConfig{File: "station.csv", Start: start, Speed: 10}
+	events, err := replayEvents(context.Background(), config, start.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	described := describeEvents(events)
+	expected := []string{"1001 Temperature 11 at 12:00:00", "1002 Temperature 20 at 12:00:00"}
+	if !reflect.DeepEqual(described, expected) {
+		t.Errorf("replayed %v, expected %v", described, expected)
+	}
+}
+
+// describeEvents sorts those events, described by their device, type, value and time of day
+func describeEvents(events []WeatherEvent) []string {
+	described := make([]string, 0, len(events))
+	for _, event := range events {
+		described = append(described, fmt.Sprintf("%d %s %v at %s", event.DeviceId, event.EventType, event.Value, event.Time.UTC().Format("15:04:05")))
+	}
+	sort.Strings(described)
+	return described
+}
@@ -0,0 +1,51 @@
+package weather_generator
+
+// EventType describes one kind of sensor reading
+type EventType struct {
+	Name string
+	Unit string
+	// range of values a healthy sensor may report
+	Min float64
+	Max float64
+}
+
+// EventTypes lists all the kinds of readings reported by the weather stations
+var EventTypes = []EventType{
+	{Name: "Pressure", Unit: "hPa", Min: 950, Max: 1050},
+	{Name: "Temperature", Unit: "°C", Min: -10, Max: 30},
+	{Name: "Humidity", Unit: "%", Min: 0, Max: 100},
+	{Name: "WindSpeed", Unit: "km/h", Min: 0, Max: 50},
+	{Name: "WindDirection", Unit: "°", Min: 0, Max: 360},
+	{Name: "Precipitation", Unit: "mm/h", Min: 0, Max: 50},
+	{Name: "UVIndex", Unit: "UVI", Min: 0, Max: 12},
+	{Name: "PM25", Unit: "µg/m³", Min: 0, Max: 500},
+	{Name: "PM10", Unit: "µg/m³", Min: 0, Max: 600},
+	{Name: "CO2", Unit: "ppm", Min: 350, Max: 2000},
+	{Name: "SolarIrradiance", Unit: "W/m²", Min: 0, Max: 1400},
+}
+
+// LookupEventType returns the description of the event type of that name, if it exists
+func LookupEventType(name string) (EventType, bool) {
+	for _, eventType := range EventTypes {
+		if eventType.Name == name {
+			return eventType, true
+		}
+	}
+	return EventType{}, false
+}
+
+// IsEventType tells whether an event type of that name exists
+func IsEventType(name string) bool {
+	_, ok := LookupEventType(name)
+	return ok
+}
+
+// UnitOf returns the unit of the values of that event type, or an empty string if the type is unknown
+func UnitOf(eventTypeName string) string {
+	eventType, _ := LookupEventType(eventTypeName)
+	return eventType.Unit
+}
+
+func (t EventType) span() float64 {
+	return t.Max - t.Min
+}
@@ -0,0 +1,242 @@
+// Optional injection of sensor faults into the generated weather events, used to
+// exercise alerting and data-quality handling downstream.
+package weather_generator
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+type FaultType string
+
+const (
+	// StuckFault repeats the value the sensor had when the fault started
+	StuckFault FaultType = "Stuck"
+	// SpikeFault adds a large deviation to the real value
+	SpikeFault FaultType = "Spike"
+	// DropoutFault removes the reading altogether
+	DropoutFault FaultType = "Dropout"
+	// OutOfRangeFault replaces the value by one outside of the physical range of the sensor
+	OutOfRangeFault FaultType = "OutOfRange"
+	// ClockSkewFault shifts the time of the reading by a fixed offset
+	ClockSkewFault FaultType = "ClockSkew"
+	// DuplicateFault submits the same reading twice
+	DuplicateFault FaultType = "Duplicate"
+)
+
+// order in which faults are applied to one event: dropping the event first
+// and duplicating it last, once all other faults have altered it
+var faultTypes = []FaultType{DropoutFault, StuckFault, SpikeFault, OutOfRangeFault, ClockSkewFault, DuplicateFault}
+
+// FaultSettings defines how likely a fault is to start on one sensor at each
+// generation run, and how long it then lasts.
+type FaultSettings struct {
+	Probability float64
+	Duration    Duration
+}
+
+// FaultConfig holds the fault settings applied to all devices, and optional
+// per-device overrides.
+//
+// example:
+//
+//	{
+//	  "Default": {"Spike": {"Probability": 0.01, "Duration": "1m"}},
+//	  "Devices": {"1003": {"Stuck": {"Probability": 0.2, "Duration": "15m"}}}
+//	}
+type FaultConfig struct {
+	Default map[FaultType]FaultSettings
+	Devices map[int64]map[FaultType]FaultSettings
+}
+
+// Duration is a time.Duration read from JSON as a string like "15m"
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration should be a string like \"15m\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+// ParseFaultConfig parses the JSON fault config. An empty config disables fault injection.
+func ParseFaultConfig(rawConfig string) (FaultConfig, error) {
+	config := FaultConfig{}
+	if rawConfig == "" {
+		return config, nil
+	}
+	if err := json.Unmarshal([]byte(rawConfig), &config); err != nil {
+		return config, fmt.Errorf("invalid fault config: %w", err)
+	}
+	for _, settings := range config.allSettings() {
+		for faultType, setting := range settings {
+			if !isKnownFault(faultType) {
+				return config, fmt.Errorf("invalid fault config: unknown fault type %s", faultType)
+			}
+			if setting.Probability < 0 || setting.Probability > 1 {
+				return config, fmt.Errorf("invalid fault config: probability of %s should be within [0, 1]", faultType)
+			}
+		}
+	}
+	return config, nil
+}
+
+func isKnownFault(faultType FaultType) bool {
+	for _, known := range faultTypes {
+		if known == faultType {
+			return true
+		}
+	}
+	return false
+}
+
+func (c FaultConfig) allSettings() []map[FaultType]FaultSettings {
+	all := []map[FaultType]FaultSettings{c.Default}
+	for _, deviceSettings := range c.Devices {
+		all = append(all, deviceSettings)
+	}
+	return all
+}
+
+func (c FaultConfig) IsEnabled() bool {
+	return len(c.Default) > 0 || len(c.Devices) > 0
+}
+
+// settingsOf returns the settings of that fault for that device, if any
+func (c FaultConfig) settingsOf(deviceId int64, faultType FaultType) (FaultSettings, bool) {
+	if deviceSettings, ok := c.Devices[deviceId]; ok {
+		if settings, ok := deviceSettings[faultType]; ok {
+			return settings, true
+		}
+	}
+	settings, ok := c.Default[faultType]
+	return settings, ok
+}
+
+// ActiveFault is a fault currently affecting one sensor. Those need to be persisted
+// between generations so faults can last longer than one run.
+type ActiveFault struct {
+	DeviceId  int64
+	EventType string
+	Fault     FaultType
+	// unix time at which the fault stops
+	Until int64
+	// stuck value, or clock skew in seconds
+	Value float64
+}
+
+func (f ActiveFault) Key() string {
+	return FaultKey(f.DeviceId, f.EventType, f.Fault)
+}
+
+// FaultKey uniquely identifies one fault on one sensor
+func FaultKey(deviceId int64, eventType string, faultType FaultType) string {
+	return fmt.Sprintf("DeviceId#%d#Type#%s#Fault#%s", deviceId, eventType, faultType)
+}
+
+// FaultInjection is the outcome of injecting faults in a batch of events
+type FaultInjection struct {
+	// resulting events, without the dropped ones
+	Events []WeatherEvent
+	// duplicated events, to be submitted after Events
+	Duplicates []WeatherEvent
+	// faults started during this injection, to be persisted
+	StartedFaults []ActiveFault
+}
+
+// InjectFaults alters the given events according to the fault config, continuing the given
+// active faults (indexed by key) and randomly starting new ones.
+// Each altered event is tagged with the fault that was applied to it.
+func (g Generator) InjectFaults(config FaultConfig, activeFaults map[string]ActiveFault, weatherEvents []WeatherEvent) FaultInjection {
+	now := g.clock()
+	injection := FaultInjection{
+		Events:        make([]WeatherEvent, 0, len(weatherEvents)),
+		Duplicates:    []WeatherEvent{},
+		StartedFaults: []ActiveFault{},
+	}
+
+	for _, event := range weatherEvents {
+		dropped := false
+		for _, faultType := range faultTypes {
+			settings, ok := config.settingsOf(event.DeviceId, faultType)
+			if !ok {
+				continue
+			}
+
+			fault, ok := activeFaults[FaultKey(event.DeviceId, event.EventType, faultType)]
+			if !ok || fault.Until < now.Unix() {
+				if g.rng.Float64() >= settings.Probability {
+					continue
+				}
+				fault = g.startFault(event, faultType, now.Add(settings.Duration.Duration))
+				injection.StartedFaults = append(injection.StartedFaults, fault)
+				log.Printf("starting fault %s on device %d, sensor %s", faultType, event.DeviceId, event.EventType)
+			}
+
+			if faultType == DropoutFault {
+				dropped = true
+				break
+			}
+
+			eventType, _ := LookupEventType(event.EventType)
+			switch faultType {
+			case StuckFault:
+				event.Value = fault.Value
+			case SpikeFault:
+				event.Value += g.randomSign() * (0.5 + g.rng.Float64()/2) * eventType.span()
+			case OutOfRangeFault:
+				event.Value = g.outOfRangeValue(eventType)
+			case ClockSkewFault:
+				event.Time = event.Time.Add(time.Duration(fault.Value) * time.Second)
+			case DuplicateFault:
+				// only the second submission is tagged, the first one keeps the tag of any prior fault
+				duplicate := event
+				duplicate.Fault = string(DuplicateFault)
+				injection.Duplicates = append(injection.Duplicates, duplicate)
+				continue
+			}
+			event.Fault = string(faultType)
+		}
+
+		if !dropped {
+			injection.Events = append(injection.Events, event)
+		}
+	}
+
+	return injection
+}
+
+func (g Generator) startFault(event WeatherEvent, faultType FaultType, until time.Time) ActiveFault {
+	fault := ActiveFault{
+		DeviceId:  event.DeviceId,
+		EventType: event.EventType,
+		Fault:     faultType,
+		Until:     until.Unix(),
+	}
+	switch faultType {
+	case StuckFault:
+		fault.Value = event.Value
+	case ClockSkewFault:
+		fault.Value = g.randomSign() * float64(60+g.rng.Int31n(30*60))
+	}
+	return fault
+}
+
+// outOfRangeValue returns a value a bit below the min or above the max of that event type
+func (g Generator) outOfRangeValue(eventType EventType) float64 {
+	offset := (0.1 + g.rng.Float64()) * eventType.span()
+	if g.rng.Intn(2) == 0 {
+		return eventType.Min - offset
+	}
+	return eventType.Max + offset
+}
@@ -0,0 +1,159 @@
+package weather_generator
+
+import (
+	"testing"
+	"time"
+)
+
+func alwaysFault(faultType FaultType) FaultConfig {
+	return FaultConfig{Default: map[FaultType]FaultSettings{
+		faultType: {Probability: 1, Duration: Duration{15 * time.Minute}},
+	}}
+}
+
+func TestInjectFaultsTagsEvents(t *testing.T) {
+	tests := []struct {
+		fault FaultType
+		check func(t *testing.T, original, injected WeatherEvent)
+	}{
+		{StuckFault, func(t *testing.T, original, injected WeatherEvent) {
+			if injected.Value != original.Value {
+				t.Errorf("stuck value %v, expected the value %v at the start of the fault", injected.Value, original.Value)
+			}
+		}},
+		{SpikeFault, func(t *testing.T, original, injected WeatherEvent) {
+			if injected.Value == original.Value {
+				t.Errorf("spiked value %v unchanged", injected.Value)
+			}
+		}},
+		{OutOfRangeFault, func(t *testing.T, original, injected WeatherEvent) {
+			eventType, _ := LookupEventType(injected.EventType)
+			if injected.Value >= eventType.Min && injected.Value <= eventType.Max {
+				t.Errorf("value %v of %s within [%v, %v]", injected.Value, injected.EventType, eventType.Min, eventType.Max)
+			}
+		}},
+		{ClockSkewFault, func(t *testing.T, original, injected WeatherEvent) {
+			if injected.Time.Equal(original.Time) {
+				t.Errorf("time %v not skewed", injected.Time)
+			}
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.fault), func(t *testing.T) {
+			generator := NewSeeded(1, FixedClock(testTime))
+			events := generator.Batch([]int64{1001})
+
+			injection := generator.InjectFaults(alwaysFault(test.fault), map[string]ActiveFault{}, events)
+
+			if len(injection.Events) != len(events) {
+				t.Fatalf("%d events, expected %d", len(injection.Events), len(events))
+			}
+			if len(injection.StartedFaults) != len(events) {
+				t.Errorf("%d started faults, expected one per sensor", len(injection.StartedFaults))
+			}
+			for i, event := range injection.Events {
+				if event.Fault != string(test.fault) {
+					t.Errorf("%s tagged with fault %q, expected %q", event.EventType, event.Fault, test.fault)
+				}
+				test.check(t, events[i], event)
+			}
+		})
+	}
+}
+
+func TestInjectFaultsDropout(t *testing.T) {
+	generator := NewSeeded(1, FixedClock(testTime))
+	events := generator.Batch([]int64{1001})
+
+	injection := generator.InjectFaults(alwaysFault(DropoutFault), map[string]ActiveFault{}, events)
+
+	if len(injection.Events) != 0 || len(injection.Duplicates) != 0 {
+		t.Errorf("%d events and %d duplicates, expected all events dropped", len(injection.Events), len(injection.Duplicates))
+	}
+}
+
+func TestInjectFaultsDuplicate(t *testing.T) {
+	generator := NewSeeded(1, FixedClock(testTime))
+	events := generator.Batch([]int64{1001})
+
+	injection := generator.InjectFaults(alwaysFault(DuplicateFault), map[string]ActiveFault{}, events)
+
+	if len(injection.Events) != len(events) || len(injection.Duplicates) != len(events) {
+		t.Fatalf("%d events and %d duplicates, expected %d of each", len(injection.Events), len(injection.Duplicates), len(events))
+	}
+	for i := range events {
+		if injection.Events[i].Fault != "" {
+			t.Errorf("first submission of %s tagged with %q, expected no tag", events[i].EventType, injection.Events[i].Fault)
+		}
+		if injection.Duplicates[i].Fault != string(DuplicateFault) {
+			t.Errorf("duplicate of %s tagged with %q, expected %q", events[i].EventType, injection.Duplicates[i].Fault, DuplicateFault)
+		}
+		if injection.Duplicates[i].Value != injection.Events[i].Value || !injection.Duplicates[i].Time.Equal(injection.Events[i].Time) {
+			t.Errorf("duplicate %+v differs from %+v", injection.Duplicates[i], injection.Events[i])
+		}
+	}
+}
+
+func TestInjectFaultsContinuesActiveFaults(t *testing.T) {
+	generator := NewSeeded(1, FixedClock(testTime))
+	events := generator.Batch([]int64{1001})
+	// a fault may not start anymore, but the active one lasts until its end
+	config := FaultConfig{Default: map[FaultType]FaultSettings{StuckFault: {Probability: 0}}}
+	stuck := ActiveFault{DeviceId: 1001, EventType: "Temperature", Fault: StuckFault, Until: testTime.Add(time.Minute).Unix(), Value: 42}
+	expired := ActiveFault{DeviceId: 1001, EventType: "Humidity", Fault: StuckFault, Until: testTime.Add(-time.Minute).Unix(), Value: 42}
+	activeFaults := map[string]ActiveFault{stuck.Key(): stuck, expired.Key(): expired}
+
+	injection := generator.InjectFaults(config, activeFaults, events)
+
+	if len(injection.StartedFaults) != 0 {
+		t.Errorf("started faults %v, expected none", injection.StartedFaults)
+	}
+	for _, event := range injection.Events {
+		switch {
+		case event.EventType == "Temperature" && (event.Fault != string(StuckFault) || event.Value != 42):
+			t.Errorf("Temperature %v tagged %q, expected stuck at 42", event.Value, event.Fault)
+		case event.EventType != "Temperature" && event.Fault != "":
+			t.Errorf("%s tagged with %q, expected no fault", event.EventType, event.Fault)
+		}
+	}
+}
+
+func TestInjectFaultsDeviceOverride(t *testing.T) {
+	generator := NewSeeded(1, FixedClock(testTime))
+	events := generator.Batch([]int64{1001, 1002})
+	config := FaultConfig{Devices: map[int64]map[FaultType]FaultSettings{
+		1002: {OutOfRangeFault: {Probability: 1, Duration: Duration{time.Minute}}},
+	}}
+
+	injection := generator.InjectFaults(config, map[string]ActiveFault{}, events)
+
+	for _, event := range injection.Events {
+		expectedFault := ""
+		if event.DeviceId == 1002 {
+			expectedFault = string(OutOfRangeFault)
+		}
+		if event.Fault != expectedFault {
+			t.Errorf("%s of device %d tagged with %q, expected %q", event.EventType, event.DeviceId, event.Fault, expectedFault)
+		}
+	}
+}
+
+func TestParseFaultConfig(t *testing.T) {
+	config, err := ParseFaultConfig(`{"Default": {"Spike": {"Probability": 0.01, "Duration": "1m"}}, "Devices": {"1003": {"Stuck": {"Probability": 0.2, "Duration": "15m"}}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings, ok := config.settingsOf(1003, StuckFault); !ok || settings.Duration.Duration != 15*time.Minute {
+		t.Errorf("settings of Stuck on 1003 %+v, expected 15m", settings)
+	}
+	if _, ok := config.settingsOf(1001, StuckFault); ok {
+		t.Error("Stuck enabled on 1001, expected only on 1003")
+	}
+
+	for _, invalid := range []string{`{"Default": {"Melted": {"Probability": 0.1}}}`, `{"Default": {"Spike": {"Probability": 2}}}`, `{"Default": {"Spike": {"Duration": 15}}}`} {
+		if _, err := ParseFaultConfig(invalid); err == nil {
+			t.Errorf("no error parsing %s", invalid)
+		}
+	}
+}
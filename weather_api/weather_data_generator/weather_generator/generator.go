@@ -0,0 +1,215 @@
+// Package weather_generator creates random weather events.
+//
+// Both the clock and the random number generator are injectable, such that a
+// generator created with the same seed and clock always produces the same events.
+package weather_generator
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+type WeatherEvent struct {
+	DeviceId  int64
+	Time      time.Time
+	EventType string
+	Value     float64
+	Unit      string
+	// name of the fault injected in this event, if any
+	Fault string
+}
+
+// Clock provides the time at which events are generated
+type Clock func() time.Time
+
+// FixedClock is a clock always returning the same time
+func FixedClock(t time.Time) Clock {
+	return func() time.Time {
+		return t
+	}
+}
+
+type Generator struct {
+	rng   *rand.Rand
+	clock Clock
+}
+
+// New creates a generator drawing all its random values from rng and timestamping
+// the events with clock. A nil clock defaults to time.Now.
+func New(rng *rand.Rand, clock Clock) Generator {
+	if clock == nil {
+		clock = time.Now
+	}
+	return Generator{
+		rng:   rng,
+		clock: clock,
+	}
+}
+
+// NewSeeded creates a generator whose random values are fully determined by seed
+func NewSeeded(seed int64, clock Clock) Generator {
+	return New(rand.New(rand.NewSource(seed)), clock)
+}
+
+// Now returns the current time according to the clock of this generator
+func (g Generator) Now() time.Time {
+	return g.clock()
+}
+
+// Batch creates one random weather event of each type for each of the given devices,
+// all sharing the same timestamp
+func (g Generator) Batch(deviceIds []int64) []WeatherEvent {
+	now := g.clock()
+	events := make([]WeatherEvent, 0, len(deviceIds)*len(EventTypes))
+	for _, deviceId := range deviceIds {
+		events = append(events, g.RandomEvents(deviceId, now)...)
+	}
+	return events
+}
+
+// RandomEvents creates one random weather event of each type for the given deviceID
+func (g Generator) RandomEvents(deviceId int64, now time.Time) []WeatherEvent {
+	// shared by the precipitation, UV and solar readings so they remain consistent
+	cloudCover := g.rng.Float64()
+	pm25Event := g.randomPM25Event(deviceId, now)
+
+	events := []WeatherEvent{
+		g.randomPressureEvent(deviceId, now),
+		g.randomTemperatureEvent(deviceId, now),
+		g.randomHumidityEvent(deviceId, now),
+		g.randomWindSpeedEvent(deviceId, now),
+		g.randomWindDirectionEvent(deviceId, now),
+		g.randomPrecipitationEvent(deviceId, now, cloudCover),
+		g.randomUVIndexEvent(deviceId, now, cloudCover),
+		pm25Event,
+		g.randomPM10Event(deviceId, now, pm25Event.Value),
+		g.randomCO2Event(deviceId, now),
+		g.randomSolarIrradianceEvent(deviceId, now, cloudCover),
+	}
+	for i := range events {
+		events[i].Unit = UnitOf(events[i].EventType)
+	}
+	return events
+}
+
+func (g Generator) randomPressureEvent(deviceId int64, now time.Time) WeatherEvent {
+	return WeatherEvent{
+		DeviceId:  deviceId,
+		Time:      now,
+		EventType: "Pressure",
+		Value:     float64(g.rng.Int31n(100) + 950),
+	}
+}
+
+func (g Generator) randomTemperatureEvent(deviceId int64, now time.Time) WeatherEvent {
+	return WeatherEvent{
+		DeviceId:  deviceId,
+		Time:      now,
+		EventType: "Temperature",
+		Value:     g.rng.Float64()*40 - 10,
+	}
+}
+
+func (g Generator) randomHumidityEvent(deviceId int64, now time.Time) WeatherEvent {
+	return WeatherEvent{
+		DeviceId:  deviceId,
+		Time:      now,
+		EventType: "Humidity",
+		Value:     g.rng.Float64() * 100,
+	}
+}
+
+func (g Generator) randomWindSpeedEvent(deviceId int64, now time.Time) WeatherEvent {
+	return WeatherEvent{
+		DeviceId:  deviceId,
+		Time:      now,
+		EventType: "WindSpeed",
+		Value:     float64(g.rng.Int31n(50)),
+	}
+}
+
+func (g Generator) randomWindDirectionEvent(deviceId int64, now time.Time) WeatherEvent {
+	return WeatherEvent{
+		DeviceId:  deviceId,
+		Time:      now,
+		EventType: "WindDirection",
+		Value:     g.rng.Float64() * 360,
+	}
+}
+
+// randomPrecipitationEvent only rains under a heavy cloud cover, mostly lightly
+func (g Generator) randomPrecipitationEvent(deviceId int64, now time.Time, cloudCover float64) WeatherEvent {
+	precipitation := 0.0
+	if cloudCover > 0.7 && g.rng.Float64() < (cloudCover-0.7)/0.3 {
+		precipitation = min(g.rng.ExpFloat64()*2, 50)
+	}
+	return WeatherEvent{
+		DeviceId:  deviceId,
+		Time:      now,
+		EventType: "Precipitation",
+		Value:     precipitation,
+	}
+}
+
+func (g Generator) randomUVIndexEvent(deviceId int64, now time.Time, cloudCover float64) WeatherEvent {
+	return WeatherEvent{
+		DeviceId:  deviceId,
+		Time:      now,
+		EventType: "UVIndex",
+		Value:     math.Round(10*daylight(now)*(1-0.6*cloudCover)*(0.9+0.2*g.rng.Float64())*10) / 10,
+	}
+}
+
+// randomPM25Event follows a log-normal distribution around a typical urban concentration
+func (g Generator) randomPM25Event(deviceId int64, now time.Time) WeatherEvent {
+	return WeatherEvent{
+		DeviceId:  deviceId,
+		Time:      now,
+		EventType: "PM25",
+		Value:     min(12*math.Exp(0.5*g.rng.NormFloat64()), 500),
+	}
+}
+
+// randomPM10Event is always above PM2.5, since PM10 includes all the PM2.5 particles
+func (g Generator) randomPM10Event(deviceId int64, now time.Time, pm25 float64) WeatherEvent {
+	return WeatherEvent{
+		DeviceId:  deviceId,
+		Time:      now,
+		EventType: "PM10",
+		Value:     min(pm25*(1.3+0.7*g.rng.Float64()), 600),
+	}
+}
+
+func (g Generator) randomCO2Event(deviceId int64, now time.Time) WeatherEvent {
+	return WeatherEvent{
+		DeviceId:  deviceId,
+		Time:      now,
+		EventType: "CO2",
+		Value:     415 + g.rng.Float64()*35,
+	}
+}
+
+func (g Generator) randomSolarIrradianceEvent(deviceId int64, now time.Time, cloudCover float64) WeatherEvent {
+	return WeatherEvent{
+		DeviceId:  deviceId,
+		Time:      now,
+		EventType: "SolarIrradiance",
+		Value:     1000 * daylight(now) * (1 - 0.75*cloudCover) * (0.95 + 0.1*g.rng.Float64()),
+	}
+}
+
+// daylight is the relative height of the sun, from 0 at night to 1 at solar noon.
+// Devices have no known location, so the solar time is approximated by UTC.
+func daylight(t time.Time) float64 {
+	utc := t.UTC()
+	hour := float64(utc.Hour()) + float64(utc.Minute())/60
+	return max(0, math.Sin(math.Pi*(hour-6)/12))
+}
+
+func (g Generator) randomSign() float64 {
+	if g.rng.Intn(2) == 0 {
+		return -1
+	}
+	return 1
+}
@@ -0,0 +1,69 @@
+package weather_generator
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var testTime = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func TestSeededGeneratorIsReproducible(t *testing.T) {
+	deviceIds := []int64{1001, 1002, 1003}
+	first := NewSeeded(42, FixedClock(testTime))
+	second := NewSeeded(42, FixedClock(testTime))
+
+	// several batches, as each one advances the random source
+	for i := 0; i < 3; i++ {
+		firstBatch, secondBatch := first.Batch(deviceIds), second.Batch(deviceIds)
+		if !reflect.DeepEqual(firstBatch, secondBatch) {
+			t.Fatalf("batch %d differs with the same seed and clock:\n%v\n%v", i, firstBatch, secondBatch)
+		}
+	}
+
+	faults := alwaysFault(SpikeFault)
+	firstInjection := first.InjectFaults(faults, map[string]ActiveFault{}, first.Batch(deviceIds))
+	secondInjection := second.InjectFaults(faults, map[string]ActiveFault{}, second.Batch(deviceIds))
+	if !reflect.DeepEqual(firstInjection, secondInjection) {
+		t.Errorf("fault injection differs with the same seed and clock:\n%v\n%v", firstInjection, secondInjection)
+	}
+}
+
+func TestSeededGeneratorDependsOnSeed(t *testing.T) {
+	deviceIds := []int64{1001}
+	if reflect.DeepEqual(NewSeeded(1, FixedClock(testTime)).Batch(deviceIds), NewSeeded(2, FixedClock(testTime)).Batch(deviceIds)) {
+		t.Error("same batch with different seeds")
+	}
+}
+
+func TestBatch(t *testing.T) {
+	generator := NewSeeded(42, FixedClock(testTime))
+
+	events := generator.Batch([]int64{1001, 1002})
+
+	if len(events) != 2*len(EventTypes) {
+		t.Fatalf("%d events, expected one per device and event type", len(events))
+	}
+	seen := map[string]bool{}
+	for _, event := range events {
+		eventType, ok := LookupEventType(event.EventType)
+		if !ok {
+			t.Errorf("unknown event type %s", event.EventType)
+			continue
+		}
+		if !event.Time.Equal(testTime) {
+			t.Errorf("time %v, expected the one of the clock %v", event.Time, testTime)
+		}
+		if event.Unit != eventType.Unit {
+			t.Errorf("unit %q of %s, expected %q", event.Unit, event.EventType, eventType.Unit)
+		}
+		if event.Value < eventType.Min || event.Value > eventType.Max {
+			t.Errorf("value %v of %s outside of [%v, %v]", event.Value, event.EventType, eventType.Min, eventType.Max)
+		}
+		seen[fmt.Sprint(event.DeviceId, event.EventType)] = true
+	}
+	if len(seen) != len(events) {
+		t.Errorf("%d distinct device and event types among %d events", len(seen), len(events))
+	}
+}
@@ -0,0 +1,39 @@
+// Error responses of the REST lambdas, as RFC 7807 problem documents.
+package weather_problem
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+const ContentType = "application/problem+json"
+
+// Problem is an RFC 7807 problem document, extended with the offending query parameter, if any,
+// and the id of the request, to be mentioned when reporting an issue.
+// Type is relative to the API URL, e.g. "/problems/invalid-parameter".
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Param     string `json:"param,omitempty"`
+	RequestId string `json:"requestId,omitempty"`
+}
+
+// Response returns that problem as the response of an API Gateway proxy integration
+func Response(problem Problem) events.APIGatewayProxyResponse {
+	log.Printf("responding with problem %+v", problem)
+	body, err := json.Marshal(problem)
+	if err != nil {
+		// cannot happen with those field types
+		body = []byte(fmt.Sprintf(`{"title": %q, "status": %d}`, problem.Title, problem.Status))
+	}
+	return events.APIGatewayProxyResponse{
+		Body:       string(body),
+		StatusCode: problem.Status,
+		Headers:    map[string]string{"Content-Type": ContentType},
+	}
+}
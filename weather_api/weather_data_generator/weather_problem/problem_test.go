@@ -0,0 +1,55 @@
+package weather_problem
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestResponse(t *testing.T) {
+	response := Response(Problem{
+		Type:      "/problems/invalid-parameter",
+		Title:     "Invalid query parameter",
+		Status:    400,
+		Detail:    "invalid from param: not a time",
+		Param:     "from",
+		RequestId: "c6af9ac6-7b61-11e6-9a41-93e8deadbeef",
+	})
+
+	if response.StatusCode != 400 {
+		t.Errorf("status %d, want 400", response.StatusCode)
+	}
+	if contentType := response.Headers["Content-Type"]; contentType != ContentType {
+		t.Errorf("Content-Type %q, want %q", contentType, ContentType)
+	}
+	body := map[string]any{}
+	if err := json.Unmarshal([]byte(response.Body), &body); err != nil {
+		t.Fatalf("invalid body %s: %v", response.Body, err)
+	}
+	want := map[string]any{
+		"type":      "/problems/invalid-parameter",
+		"title":     "Invalid query parameter",
+		"status":    400.0,
+		"detail":    "invalid from param: not a time",
+		"param":     "from",
+		"requestId": "c6af9ac6-7b61-11e6-9a41-93e8deadbeef",
+	}
+	for k, v := range want {
+		if body[k] != v {
+			t.Errorf("%s = %v, want %v", k, body[k], v)
+		}
+	}
+}
+
+func TestResponseOmitsEmptyFields(t *testing.T) {
+	response := Response(Problem{Type: "/problems/storage-failure", Title: "Failed to store readings", Status: 500})
+
+	body := map[string]any{}
+	if err := json.Unmarshal([]byte(response.Body), &body); err != nil {
+		t.Fatalf("invalid body %s: %v", response.Body, err)
+	}
+	for _, field := range []string{"detail", "param", "requestId"} {
+		if _, ok := body[field]; ok {
+			t.Errorf("unexpected %s in %s", field, response.Body)
+		}
+	}
+}
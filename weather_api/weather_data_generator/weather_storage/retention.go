@@ -0,0 +1,20 @@
+package weather_storage
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ParseRetention parses the retention period of the events in DynamoDB (the RETENTION_DAYS variable), as a number of days.
+// It returns 0 when events never expire.
+func ParseRetention(retentionDays string) (time.Duration, error) {
+	if retentionDays == "" {
+		return 0, nil
+	}
+	days, err := strconv.Atoi(retentionDays)
+	if err != nil || days < 0 {
+		return 0, fmt.Errorf("invalid RETENTION_DAYS %q: should be a positive number of days, or 0 to disable expiry", retentionDays)
+	}
+	return time.Duration(days) * 24 * time.Hour, nil
+}
@@ -0,0 +1,29 @@
+package weather_storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRetention(t *testing.T) {
+	tests := []struct {
+		retentionDays string
+		expected      time.Duration
+	}{
+		{"", 0},
+		{"0", 0},
+		{"30", 30 * 24 * time.Hour},
+	}
+	for _, test := range tests {
+		retention, err := ParseRetention(test.retentionDays)
+		if err != nil || retention != test.expected {
+			t.Errorf("ParseRetention(%q) = %v, %v, expected %v", test.retentionDays, retention, err, test.expected)
+		}
+	}
+
+	for _, invalid := range []string{"-1", "1.5", "30d"} {
+		if _, err := ParseRetention(invalid); err == nil {
+			t.Errorf("no error parsing %q", invalid)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package weather_storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	"weather_data_generator/weather_generator"
+)
+
+// ParseStreamImage reads the weather event from the new or old image of a DynamoDB stream record,
+// i.e. an item written by Writer
+func ParseStreamImage(image map[string]events.DynamoDBAttributeValue) (weather_generator.WeatherEvent, error) {
+	for _, attribute := range []string{"DeviceId", "Time", "EventType", "Value"} {
+		if _, ok := image[attribute]; !ok {
+			return weather_generator.WeatherEvent{}, fmt.Errorf("missing %s", attribute)
+		}
+	}
+	if image["DeviceId"].DataType() != events.DataTypeNumber ||
+		image["Time"].DataType() != events.DataTypeNumber ||
+		image["Value"].DataType() != events.DataTypeNumber ||
+		image["EventType"].DataType() != events.DataTypeString {
+		return weather_generator.WeatherEvent{}, fmt.Errorf("unexpected attribute types in %v", image)
+	}
+
+	deviceId, err1 := strconv.ParseInt(image["DeviceId"].Number(), 10, 64)
+	unixTime, err2 := strconv.ParseInt(image["Time"].Number(), 10, 64)
+	value, err3 := strconv.ParseFloat(image["Value"].Number(), 64)
+	for _, err := range []error{err1, err2, err3} {
+		if err != nil {
+			return weather_generator.WeatherEvent{}, fmt.Errorf("invalid number: %w", err)
+		}
+	}
+
+	weatherEvent := weather_generator.WeatherEvent{
+		DeviceId:  deviceId,
+		Time:      time.Unix(unixTime, 0).UTC(),
+		EventType: image["EventType"].String(),
+		Value:     value,
+	}
+	if unit, ok := image["Unit"]; ok && unit.DataType() == events.DataTypeString {
+		weatherEvent.Unit = unit.String()
+	}
+	if fault, ok := image["Fault"]; ok && fault.DataType() == events.DataTypeString {
+		weatherEvent.Fault = fault.String()
+	}
+	return weatherEvent, nil
+}
+
+// ChangeRecord reads the DynamoDB change record carried by that record of the Kinesis data stream of the table,
+// which has the same layout as those of the DynamoDB stream (except for the sequence number, that of the Kinesis record)
+func ChangeRecord(record events.KinesisEventRecord) (events.DynamoDBEventRecord, error) {
+	change := events.DynamoDBEventRecord{}
+	if err := json.Unmarshal(record.Kinesis.Data, &change); err != nil {
+		return events.DynamoDBEventRecord{}, fmt.Errorf("invalid change record %s: %w", record.Kinesis.SequenceNumber, err)
+	}
+	return change, nil
+}
@@ -0,0 +1,107 @@
+package weather_storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	"weather_data_generator/weather_generator"
+)
+
+func eventImage() map[string]events.DynamoDBAttributeValue {
+	return map[string]events.DynamoDBAttributeValue{
+		"PK":        events.NewStringAttribute("DeviceId#1001"),
+		"SK":        events.NewStringAttribute("Time#1709294400#TypeTemperature"),
+		"DeviceId":  events.NewNumberAttribute("1001"),
+		"Time":      events.NewNumberAttribute("1709294400"),
+		"EventType": events.NewStringAttribute("Temperature"),
+		"Value":     events.NewNumberAttribute("21.500000"),
+	}
+}
+
+func TestParseStreamImage(t *testing.T) {
+	withUnitAndFault := eventImage()
+	withUnitAndFault["Unit"] = events.NewStringAttribute("°C")
+	withUnitAndFault["Fault"] = events.NewStringAttribute("Spike")
+	withUnitAndFault["ExpiresAt"] = events.NewNumberAttribute("1711886400")
+
+	expected := weather_generator.WeatherEvent{
+		DeviceId:  1001,
+		Time:      time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+		EventType: "Temperature",
+		Value:     21.5,
+	}
+	expectedWithUnitAndFault := expected
+	expectedWithUnitAndFault.Unit = "°C"
+	expectedWithUnitAndFault.Fault = "Spike"
+
+	tests := []struct {
+		image    map[string]events.DynamoDBAttributeValue
+		expected weather_generator.WeatherEvent
+	}{
+		{eventImage(), expected},
+		{withUnitAndFault, expectedWithUnitAndFault},
+	}
+	for _, test := range tests {
+		weatherEvent, err := ParseStreamImage(test.image)
+		if err != nil || weatherEvent != test.expected {
+			t.Errorf("ParseStreamImage(%v) = %v, %v, expected %v", test.image, weatherEvent, err, test.expected)
+		}
+	}
+}
+
+func TestParseStreamImageRefusesOtherItems(t *testing.T) {
+	missingValue := eventImage()
+	delete(missingValue, "Value")
+	stringDevice := eventImage()
+	stringDevice["DeviceId"] = events.NewStringAttribute("station")
+	fractionalTime := eventImage()
+	fractionalTime["Time"] = events.NewNumberAttribute("1709294400.5")
+	session := map[string]events.DynamoDBAttributeValue{
+		"PK":           events.NewStringAttribute("WS_SESSIONS"),
+		"SK":           events.NewStringAttribute("abc="),
+		"ConnectionId": events.NewStringAttribute("abc="),
+	}
+
+	for name, image := range map[string]map[string]events.DynamoDBAttributeValue{
+		"missing value":   missingValue,
+		"string device":   stringDevice,
+		"fractional time": fractionalTime,
+		"not an event":    session,
+	} {
+		if weatherEvent, err := ParseStreamImage(image); err == nil {
+			t.Errorf("%s: parsed %v, expected an error", name, weatherEvent)
+		}
+	}
+}
+
+func TestChangeRecord(t *testing.T) {
+	// as written by DynamoDB to its Kinesis data stream destination, for an expired item
+	data := `{"awsRegion": "eu-west-1", "eventID": "4b7c6e1a-7e5f-4a3c-9f64-0c4bde8a9e21", "eventName": "REMOVE",
+		"userIdentity": {"type": "Service", "principalId": "dynamodb.amazonaws.com"}, "recordFormat": "application/json",
+		"tableName": "weather", "eventSource": "aws:dynamodb",
+		"dynamodb": {"ApproximateCreationDateTime": 1711886400123, "ApproximateCreationDateTimePrecision": "MILLISECOND",
+			"Keys": {"PK": {"S": "DeviceId#1001"}, "SK": {"S": "Time#1709294400#TypeTemperature"}},
+			"OldImage": {"PK": {"S": "DeviceId#1001"}, "SK": {"S": "Time#1709294400#TypeTemperature"}, "DeviceId": {"N": "1001"},
+				"Time": {"N": "1709294400"}, "EventType": {"S": "Temperature"}, "Value": {"N": "21.500000"}, "Unit": {"S": "°C"}},
+			"SizeBytes": 142}}`
+	record := events.KinesisEventRecord{Kinesis: events.KinesisRecord{SequenceNumber: "4960", Data: []byte(data)}}
+
+	change, err := ChangeRecord(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change.EventName != "REMOVE" || change.EventID != "4b7c6e1a-7e5f-4a3c-9f64-0c4bde8a9e21" ||
+		change.UserIdentity == nil || change.UserIdentity.PrincipalID != "dynamodb.amazonaws.com" {
+		t.Errorf("unexpected change record %+v", change)
+	}
+	weatherEvent, err := ParseStreamImage(change.Change.OldImage)
+	if err != nil || weatherEvent.DeviceId != 1001 || weatherEvent.Value != 21.5 || weatherEvent.Unit != "°C" {
+		t.Errorf("old image parsed as %v, %v", weatherEvent, err)
+	}
+
+	if _, err := ChangeRecord(events.KinesisEventRecord{Kinesis: events.KinesisRecord{Data: []byte("not json")}}); err == nil {
+		t.Error("no error reading an invalid record")
+	}
+}
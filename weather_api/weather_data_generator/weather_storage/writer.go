@@ -0,0 +1,132 @@
+// Batched writes of the weather events to DynamoDB, shared by the data generator and the MQTT bridge.
+package weather_storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"weather_data_generator/weather_generator"
+)
+
+// max number of items of one DynamoDB BatchWriteItem
+const BatchSize = 25
+
+// attempts of writing the items left unprocessed by a throttled batch, with exponential backoff
+const maxWriteAttempts = 5
+const writeRetryBackoff = 50 * time.Millisecond
+
+// Writer inserts weather events in the DynamoDB table
+type Writer struct {
+	client *dynamodb.Client
+	table  string
+	// how long events are kept in DynamoDB before expiring (and being archived), 0 to keep them forever
+	retention time.Duration
+}
+
+func NewWriter(client *dynamodb.Client, table string, retention time.Duration) Writer {
+	return Writer{client: client, table: table, retention: retention}
+}
+
+// AddAll inserts the given weather events into DynamoDB, as concurrent batches of BatchSize events.
+// A batch may not contain twice the same key (i.e. same device, time and event type).
+func (w Writer) AddAll(ctx context.Context, weatherEvents []weather_generator.WeatherEvent) error {
+	var waiter = sync.WaitGroup{}
+	errs := make([]error, (len(weatherEvents)+BatchSize-1)/BatchSize)
+	for i := 0; i < len(weatherEvents); i += BatchSize {
+		fromIdx := i
+		toIdx := min(i+BatchSize, len(weatherEvents))
+		waiter.Add(1)
+		go func() {
+			defer waiter.Done()
+			errs[fromIdx/BatchSize] = w.Add(ctx, weatherEvents[fromIdx:toIdx])
+		}()
+	}
+	waiter.Wait()
+	return errors.Join(errs...)
+}
+
+// Add inserts the given weather events into DynamoDB as one single batch
+func (w Writer) Add(ctx context.Context, weatherEvents []weather_generator.WeatherEvent) error {
+	log.Println("inserting batch")
+	if len(weatherEvents) == 0 || len(weatherEvents) > BatchSize {
+		return fmt.Errorf("refusing to insert a batch of size %d", len(weatherEvents))
+	}
+
+	putRequests := make([]types.WriteRequest, 0, len(weatherEvents))
+	for _, weatherEvent := range weatherEvents {
+		item := map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{
+				Value: fmt.Sprintf("DeviceId#%d", weatherEvent.DeviceId),
+			},
+			"SK": &types.AttributeValueMemberS{
+				Value: fmt.Sprintf("Time#%d#Type%s", weatherEvent.Time.Unix(), weatherEvent.EventType),
+			},
+			"DeviceId": &types.AttributeValueMemberN{
+				Value: fmt.Sprintf("%d", weatherEvent.DeviceId),
+			},
+			"EventType": &types.AttributeValueMemberS{
+				Value: weatherEvent.EventType,
+			},
+			"Value": &types.AttributeValueMemberN{
+				Value: fmt.Sprintf("%f", weatherEvent.Value),
+			},
+			"Time": &types.AttributeValueMemberN{
+				Value: fmt.Sprintf("%d", weatherEvent.Time.Unix()),
+			},
+		}
+		if weatherEvent.Unit != "" {
+			item["Unit"] = &types.AttributeValueMemberS{
+				Value: weatherEvent.Unit,
+			}
+		}
+		if weatherEvent.Fault != "" {
+			item["Fault"] = &types.AttributeValueMemberS{
+				Value: weatherEvent.Fault,
+			}
+		}
+		if w.retention > 0 {
+			// TTL attribute of the table
+			item["ExpiresAt"] = &types.AttributeValueMemberN{
+				Value: fmt.Sprintf("%d", weatherEvent.Time.Add(w.retention).Unix()),
+			}
+		}
+		putRequests = append(putRequests, types.WriteRequest{
+			PutRequest: &types.PutRequest{Item: item},
+		})
+	}
+
+	return w.batchWrite(ctx, map[string][]types.WriteRequest{w.table: putRequests})
+}
+
+// batchWrite writes those items, retrying the ones DynamoDB leaves unprocessed when throttling
+func (w Writer) batchWrite(ctx context.Context, requestItems map[string][]types.WriteRequest) error {
+	for attempt := 0; len(requestItems) > 0; attempt++ {
+		if attempt == maxWriteAttempts {
+			return fmt.Errorf("%d events still unprocessed after %d attempts", len(requestItems[w.table]), attempt)
+		}
+		if attempt > 0 {
+			log.Printf("retrying %d unprocessed events", len(requestItems[w.table]))
+			select {
+			case <-time.After(writeRetryBackoff << attempt):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		output, err := w.client.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{
+			RequestItems: requestItems,
+		})
+		if err != nil {
+			return fmt.Errorf("error while inserting events in DyanmoDB %w", err)
+		}
+		requestItems = output.UnprocessedItems
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+// Per-device credentials, authenticating the readings submitted by real stations to the ingestion API.
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const DEVICE_CREDENTIALS_PK string = "DEVICE_CREDENTIALS"
+
+// DeviceCredentials is what is stored about the secret of a device: only its SHA-256 hash
+type DeviceCredentials struct {
+	DeviceId   int64
+	SecretHash string
+	IssuedAt   time.Time
+}
+
+// IssuedCredentials is returned once, when the secret is generated
+type IssuedCredentials struct {
+	DeviceId int64
+	Secret   string
+	IssuedAt time.Time
+}
+
+// issueCredentials handles POST /devices/{device_id}/credentials, generating a new secret
+// for that device, which replaces any previous one
+func issueCredentials(ctx context.Context, deviceId int64) events.APIGatewayProxyResponse {
+	getResult, err := dynamoClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: dynamoTable,
+		Key:       deviceKey(deviceId),
+	})
+	if err != nil {
+		log.Println(err)
+		return serverSideError()
+	}
+	if getResult.Item == nil {
+		return clientError(404, errDeviceNotFound.Error())
+	}
+
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(secretBytes); err != nil {
+		log.Println(err)
+		return serverSideError()
+	}
+	issued := IssuedCredentials{
+		DeviceId: deviceId,
+		Secret:   hex.EncodeToString(secretBytes),
+		IssuedAt: time.Now().UTC().Truncate(time.Second),
+	}
+
+	secretHash := sha256.Sum256([]byte(issued.Secret))
+	item, err := attributevalue.MarshalMap(DeviceCredentials{
+		DeviceId:   deviceId,
+		SecretHash: hex.EncodeToString(secretHash[:]),
+		IssuedAt:   issued.IssuedAt,
+	})
+	if err != nil {
+		log.Println(err)
+		return serverSideError()
+	}
+	for k, v := range credentialsKey(deviceId) {
+		item[k] = v
+	}
+
+	if _, err := dynamoClient.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: dynamoTable,
+		Item:      item,
+	}); err != nil {
+		log.Println(fmt.Errorf("error while writing credentials in DynamoDB: %w", err))
+		return serverSideError()
+	}
+	log.Printf("issued new credentials for device %d", deviceId)
+	return jsonResponse(201, issued)
+}
+
+// revokeCredentials removes the secret of that device, if any
+func revokeCredentials(ctx context.Context, deviceId int64) error {
+	if _, err := dynamoClient.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: dynamoTable,
+		Key:       credentialsKey(deviceId),
+	}); err != nil {
+		return fmt.Errorf("error while removing credentials of device %d from DynamoDB: %w", deviceId, err)
+	}
+	return nil
+}
+
+func credentialsKey(deviceId int64) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{
+			Value: DEVICE_CREDENTIALS_PK,
+		},
+		"SK": &types.AttributeValueMemberS{
+			Value: fmt.Sprintf("DeviceId#%d", deviceId),
+		},
+	}
+}
@@ -0,0 +1,310 @@
+// Geospatial lookup of the devices, within a radius or a bounding box, along with their latest readings.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// name of the DynamoDB GSI indexing the devices by GeoCell and Geohash
+const geoIndex = "GeoIndex"
+
+const earthRadiusKm = 6371.0
+const defaultRadiusKm = 25.0
+const maxRadiusKm = 500.0
+
+// above that, the bounding box is considered too large to be queried
+const maxGeoCells = 128
+
+// only readings more recent than that are considered as latest readings
+const latestReadingsWindow = 15 * time.Minute
+
+type BoundingBox struct {
+	MinLatitude  float64
+	MinLongitude float64
+	MaxLatitude  float64
+	MaxLongitude float64
+}
+
+type WeatherEvent struct {
+	DeviceId  int64
+	Time      time.Time
+	EventType string
+	Value     float64
+	Unit      string
+	Fault     string `json:",omitempty"`
+}
+
+// LocatedDevice is a device found by a geospatial lookup
+type LocatedDevice struct {
+	Device Device
+	// distance from the searched location, only set when searching within a radius
+	DistanceKm float64 `json:",omitempty"`
+	// latest reading of each sensor of the device
+	LatestReadings []WeatherEvent
+}
+
+type LocatedDevices struct {
+	Devices []LocatedDevice
+}
+
+// nearDevices handles GET /devices/near?lat=47.37&lon=8.54&radius_km=10
+func nearDevices(ctx context.Context, params map[string]string) events.APIGatewayProxyResponse {
+	latitude, err1 := parseCoordinate(params, "lat", 90)
+	longitude, err2 := parseCoordinate(params, "lon", 180)
+	if err := firstError(err1, err2); err != nil {
+		return clientError(400, err.Error())
+	}
+
+	radiusKm := defaultRadiusKm
+	if radiusStr, ok := params["radius_km"]; ok {
+		var err error
+		radiusKm, err = strconv.ParseFloat(radiusStr, 64)
+		if err != nil || radiusKm <= 0 || radiusKm > maxRadiusKm {
+			return clientError(400, fmt.Sprintf("invalid radius_km param: should be within ]0, %g]", maxRadiusKm))
+		}
+	}
+
+	candidates, err := queryDevicesWithin(ctx, boundingBoxAround(latitude, longitude, radiusKm))
+	if err != nil {
+		return geoQueryError(err)
+	}
+
+	found := []LocatedDevice{}
+	for _, device := range candidates {
+		distance := distanceKm(latitude, longitude, device.Location.Latitude, device.Location.Longitude)
+		if distance <= radiusKm {
+			found = append(found, LocatedDevice{Device: device, DistanceKm: distance})
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].DistanceKm < found[j].DistanceKm
+	})
+
+	return locatedDevicesResponse(ctx, found)
+}
+
+// devicesWithin handles GET /devices/within?min_lat=47&min_lon=8&max_lat=48&max_lon=9
+func devicesWithin(ctx context.Context, params map[string]string) events.APIGatewayProxyResponse {
+	minLatitude, err1 := parseCoordinate(params, "min_lat", 90)
+	minLongitude, err2 := parseCoordinate(params, "min_lon", 180)
+	maxLatitude, err3 := parseCoordinate(params, "max_lat", 90)
+	maxLongitude, err4 := parseCoordinate(params, "max_lon", 180)
+	if err := firstError(err1, err2, err3, err4); err != nil {
+		return clientError(400, err.Error())
+	}
+	if minLatitude > maxLatitude || minLongitude > maxLongitude {
+		return clientError(400, "invalid bounding box: min_lat and min_lon should be lower than max_lat and max_lon")
+	}
+	box := BoundingBox{
+		MinLatitude:  minLatitude,
+		MinLongitude: minLongitude,
+		MaxLatitude:  maxLatitude,
+		MaxLongitude: maxLongitude,
+	}
+
+	candidates, err := queryDevicesWithin(ctx, box)
+	if err != nil {
+		return geoQueryError(err)
+	}
+
+	found := []LocatedDevice{}
+	for _, device := range candidates {
+		if box.contains(device.Location) {
+			found = append(found, LocatedDevice{Device: device})
+		}
+	}
+
+	return locatedDevicesResponse(ctx, found)
+}
+
+func locatedDevicesResponse(ctx context.Context, found []LocatedDevice) events.APIGatewayProxyResponse {
+	if err := addLatestReadings(ctx, found); err != nil {
+		log.Println(err)
+		return serverSideError()
+	}
+	log.Printf("returning %d located devices", len(found))
+	return jsonResponse(200, LocatedDevices{Devices: found})
+}
+
+var errBoxTooLarge = fmt.Errorf("bounding box too large: it should span at most %d geohash cells", maxGeoCells)
+
+func geoQueryError(err error) events.APIGatewayProxyResponse {
+	if err == errBoxTooLarge {
+		return clientError(400, err.Error())
+	}
+	log.Println(err)
+	return serverSideError()
+}
+
+// queryDevicesWithin returns all the devices of the geohash cells overlapping that box.
+// Some of them may be outside of the box itself.
+func queryDevicesWithin(ctx context.Context, box BoundingBox) ([]Device, error) {
+	cells := geohashCovering(box, geoCellPrecision)
+	if len(cells) > maxGeoCells {
+		return nil, errBoxTooLarge
+	}
+	log.Printf("looking for devices within %v, in geohash cells %v", box, cells)
+
+	devices := []Device{}
+	for _, cell := range cells {
+		expr, err := expression.NewBuilder().
+			WithKeyCondition(
+				expression.Key("GeoCell").Equal(expression.Value(cell)),
+			).
+			Build()
+		if err != nil {
+			return nil, fmt.Errorf("error while building DynamoDB query: %w", err)
+		}
+
+		paginator := dynamodb.NewQueryPaginator(dynamoClient, &dynamodb.QueryInput{
+			TableName:                 dynamoTable,
+			IndexName:                 aws.String(geoIndex),
+			KeyConditionExpression:    expr.KeyCondition(),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+		})
+		for paginator.HasMorePages() {
+			queryResult, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("error while querying DynamodDB: %w", err)
+			}
+			for _, rawDevice := range queryResult.Items {
+				device := Device{}
+				if err := attributevalue.UnmarshalMap(rawDevice, &device); err != nil {
+					log.Printf("failed to parse device %v, skipping %v", rawDevice, err)
+					continue
+				}
+				devices = append(devices, device)
+			}
+		}
+	}
+	return devices, nil
+}
+
+// addLatestReadings fetches, concurrently, the latest reading of each sensor of those devices
+func addLatestReadings(ctx context.Context, devices []LocatedDevice) error {
+	var waiter sync.WaitGroup
+	errs := make([]error, len(devices))
+	for i := range devices {
+		waiter.Add(1)
+		go func() {
+			defer waiter.Done()
+			devices[i].LatestReadings, errs[i] = queryLatestReadings(ctx, devices[i].Device.DeviceId)
+		}()
+	}
+	waiter.Wait()
+	return firstError(errs...)
+}
+
+// queryLatestReadings returns the most recent reading of each event type of that device
+func queryLatestReadings(ctx context.Context, deviceId int64) ([]WeatherEvent, error) {
+	expr, err := expression.NewBuilder().
+		WithKeyCondition(
+			expression.KeyAnd(
+				expression.Key("PK").Equal(expression.Value(fmt.Sprintf("DeviceId#%d", deviceId))),
+				expression.Key("SK").GreaterThanEqual(
+					expression.Value(fmt.Sprintf("Time#%d", time.Now().Add(-latestReadingsWindow).Unix())),
+				),
+			),
+		).
+		Build()
+	if err != nil {
+		return nil, fmt.Errorf("error while building DynamoDB query: %w", err)
+	}
+
+	queryResult, err := dynamoClient.Query(ctx, &dynamodb.QueryInput{
+		TableName:                 dynamoTable,
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ScanIndexForward:          aws.Bool(false),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error while querying DynamodDB: %w", err)
+	}
+
+	// most recent first, so the first event of each type is the latest one
+	latest := []WeatherEvent{}
+	seenTypes := map[string]bool{}
+	for _, rawEvent := range queryResult.Items {
+		event := WeatherEvent{}
+		if err := attributevalue.UnmarshalMap(rawEvent, &event); err != nil {
+			log.Printf("failed to parse event %v, skipping %v", rawEvent, err)
+			continue
+		}
+		if !seenTypes[event.EventType] {
+			seenTypes[event.EventType] = true
+			latest = append(latest, event)
+		}
+	}
+	return latest, nil
+}
+
+func parseCoordinate(params map[string]string, name string, maxAbs float64) (float64, error) {
+	value, err := strconv.ParseFloat(params[name], 64)
+	if err != nil || math.Abs(value) > maxAbs {
+		return 0, fmt.Errorf("missing or invalid %s param: should be within [-%g, %g]", name, maxAbs, maxAbs)
+	}
+	return value, nil
+}
+
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b BoundingBox) contains(location Location) bool {
+	return location.Latitude >= b.MinLatitude && location.Latitude <= b.MaxLatitude &&
+		location.Longitude >= b.MinLongitude && location.Longitude <= b.MaxLongitude
+}
+
+// boundingBoxAround returns a box containing the circle of that radius around that location
+// (clamped to the poles and the antimeridian). The longitudes of the box are those of the points of
+// the circle tangent to meridians, or all of them when the circle contains a pole.
+func boundingBoxAround(latitude, longitude, radiusKm float64) BoundingBox {
+	angularRadius := radiusKm / earthRadiusKm
+	latDelta := angularRadius * 180 / math.Pi
+	if latitude+latDelta >= 90 || latitude-latDelta <= -90 {
+		return BoundingBox{
+			MinLatitude:  max(latitude-latDelta, -90),
+			MinLongitude: -180,
+			MaxLatitude:  min(latitude+latDelta, 90),
+			MaxLongitude: 180,
+		}
+	}
+
+	lonDelta := math.Asin(math.Sin(angularRadius)/math.Cos(latitude*math.Pi/180)) * 180 / math.Pi
+	return BoundingBox{
+		MinLatitude:  latitude - latDelta,
+		MinLongitude: max(longitude-lonDelta, -180),
+		MaxLatitude:  latitude + latDelta,
+		MaxLongitude: min(longitude+lonDelta, 180),
+	}
+}
+
+// distanceKm is the great-circle distance between two locations, according to the haversine formula
+func distanceKm(lat1, lon1, lat2, lon2 float64) float64 {
+	toRad := math.Pi / 180
+	dLat := (lat2 - lat1) * toRad
+	dLon := (lon2 - lon1) * toRad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*toRad)*math.Cos(lat2*toRad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestDistanceKm(t *testing.T) {
+	tests := []struct {
+		name     string
+		from     Location
+		to       Location
+		expected float64
+	}{
+		{"same location", Location{Latitude: 47.3769, Longitude: 8.5417}, Location{Latitude: 47.3769, Longitude: 8.5417}, 0},
+		{"one degree of latitude", Location{Latitude: 10, Longitude: 20}, Location{Latitude: 11, Longitude: 20}, 111.19},
+		{"one degree of longitude at the equator", Location{Latitude: 0, Longitude: 20}, Location{Latitude: 0, Longitude: 21}, 111.19},
+		{"across the antimeridian", Location{Latitude: 0, Longitude: 179.5}, Location{Latitude: 0, Longitude: -179.5}, 111.19},
+		{"Zurich to Geneva", Location{Latitude: 47.3769, Longitude: 8.5417}, Location{Latitude: 46.2044, Longitude: 6.1432}, 224.0},
+		{"Paris to London", Location{Latitude: 48.8566, Longitude: 2.3522}, Location{Latitude: 51.5074, Longitude: -0.1278}, 343.5},
+		{"antipodes", Location{Latitude: 0, Longitude: 0}, Location{Latitude: 0, Longitude: 180}, math.Pi * earthRadiusKm},
+		{"poles", Location{Latitude: 90, Longitude: 0}, Location{Latitude: -90, Longitude: 0}, math.Pi * earthRadiusKm},
+	}
+	for _, test := range tests {
+		distance := distanceKm(test.from.Latitude, test.from.Longitude, test.to.Latitude, test.to.Longitude)
+		if math.Abs(distance-test.expected) > 0.5 {
+			t.Errorf("%s: %.2f km, expected %.2f", test.name, distance, test.expected)
+		}
+		if reverse := distanceKm(test.to.Latitude, test.to.Longitude, test.from.Latitude, test.from.Longitude); math.Abs(reverse-distance) > 1e-9 {
+			t.Errorf("%s: %.2f km back, %.2f km forth", test.name, reverse, distance)
+		}
+	}
+}
+
+func TestBoundingBoxAround(t *testing.T) {
+	tests := []struct {
+		name      string
+		latitude  float64
+		longitude float64
+		radiusKm  float64
+		expected  BoundingBox
+	}{
+		{"equator", 0, 20, 111.195, BoundingBox{-1, 19, 1, 21}},
+		{"60° north", 60, 20, 111.195, BoundingBox{59, 17.99970, 61, 22.00030}},
+		{"clamped to the north pole", 89.5, 20, 111.195, BoundingBox{88.5, -180, 90, 180}},
+		{"clamped to the south pole", -89.9, 20, 50, BoundingBox{-90, -180, -89.45034, 180}},
+		{"clamped to the antimeridian", 0, 179.5, 111.195, BoundingBox{-1, 178.5, 1, 180}},
+	}
+	for _, test := range tests {
+		box := boundingBoxAround(test.latitude, test.longitude, test.radiusKm)
+		if math.Abs(box.MinLatitude-test.expected.MinLatitude) > 1e-3 || math.Abs(box.MinLongitude-test.expected.MinLongitude) > 1e-3 ||
+			math.Abs(box.MaxLatitude-test.expected.MaxLatitude) > 1e-3 || math.Abs(box.MaxLongitude-test.expected.MaxLongitude) > 1e-3 {
+			t.Errorf("%s: box %+v, expected %+v", test.name, box, test.expected)
+		}
+	}
+}
+
+// TestBoundingBoxAroundContainsCircle checks that the points at the radius, in any direction, are in the box
+func TestBoundingBoxAroundContainsCircle(t *testing.T) {
+	centers := []Location{
+		{Latitude: 47.3769, Longitude: 8.5417},
+		{Latitude: -33.8688, Longitude: 151.2093},
+		{Latitude: 0, Longitude: 0},
+		{Latitude: 70, Longitude: -150},
+		{Latitude: 87, Longitude: 20},
+		{Latitude: -89.9, Longitude: -100},
+	}
+	for _, center := range centers {
+		for _, radiusKm := range []float64{1, 25, 500} {
+			box := boundingBoxAround(center.Latitude, center.Longitude, radiusKm)
+			for bearing := 0.0; bearing < 360; bearing += 5 {
+				point := destination(center, bearing, radiusKm)
+				// up to rounding errors
+				if !expanded(box, 1e-9).contains(point) {
+					t.Errorf("%v at %.0f km bearing %.0f° is outside of the box %+v around %v", point, radiusKm, bearing, box, center)
+				}
+			}
+		}
+	}
+}
+
+func TestNearDevicesFiltersByRadius(t *testing.T) {
+	withFakeDynamo(t,
+		Device{DeviceId: 1001, Name: "Zurich", Location: Location{Latitude: 47.3769, Longitude: 8.5417}},
+		Device{DeviceId: 1002, Name: "Winterthur", Location: Location{Latitude: 47.4988, Longitude: 8.7237}},
+		Device{DeviceId: 1003, Name: "Geneva", Location: Location{Latitude: 46.2044, Longitude: 6.1432}},
+		Device{DeviceId: 1004, Name: "Uster", Location: Location{Latitude: 47.3471, Longitude: 8.7209}},
+		// in the same geohash cell as Zurich, but beyond any tested radius
+		Device{DeviceId: 1005, Name: "Lucerne", Location: Location{Latitude: 47.0502, Longitude: 8.3093}},
+	)
+
+	tests := []struct {
+		radiusKm string
+		// closest first
+		expected []int64
+	}{
+		// Winterthur (19 km) is within the bounding box, but not within the radius
+		{"15", []int64{1001, 1004}},
+		// default radius of 25 km
+		{"", []int64{1001, 1004, 1002}},
+		{"250", []int64{1001, 1004, 1002, 1005, 1003}},
+		{"0.5", []int64{1001}},
+	}
+	for _, test := range tests {
+		params := map[string]string{"lat": "47.3769", "lon": "8.5417"}
+		if test.radiusKm != "" {
+			params["radius_km"] = test.radiusKm
+		}
+		response, err := handler(context.Background(), events.APIGatewayProxyRequest{Resource: "/devices/near", HTTPMethod: "GET", QueryStringParameters: params})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.StatusCode != 200 {
+			t.Fatalf("radius %s: status %d: %s", test.radiusKm, response.StatusCode, response.Body)
+		}
+		located := LocatedDevices{}
+		if err := json.Unmarshal([]byte(response.Body), &located); err != nil {
+			t.Fatal(err)
+		}
+		ids := []int64{}
+		for _, device := range located.Devices {
+			ids = append(ids, device.Device.DeviceId)
+		}
+		if !slices.Equal(ids, test.expected) {
+			t.Errorf("radius %s: devices %v, expected %v", test.radiusKm, ids, test.expected)
+		}
+	}
+}
+
+// destination is the location at that distance from the origin, following that initial bearing, with
+// its longitude within [-180, 180[
+func destination(origin Location, bearingDegrees, distance float64) Location {
+	toRad := math.Pi / 180
+	angularDistance := distance / earthRadiusKm
+	lat1, lon1, bearing := origin.Latitude*toRad, origin.Longitude*toRad, bearingDegrees*toRad
+	lat2 := math.Asin(math.Sin(lat1)*math.Cos(angularDistance) + math.Cos(lat1)*math.Sin(angularDistance)*math.Cos(bearing))
+	lon2 := lon1 + math.Atan2(math.Sin(bearing)*math.Sin(angularDistance)*math.Cos(lat1), math.Cos(angularDistance)-math.Sin(lat1)*math.Sin(lat2))
+	return Location{Latitude: lat2 / toRad, Longitude: math.Mod(lon2/toRad+540, 360) - 180}
+}
+
+func expanded(box BoundingBox, margin float64) BoundingBox {
+	return BoundingBox{box.MinLatitude - margin, box.MinLongitude - margin, box.MaxLatitude + margin, box.MaxLongitude + margin}
+}
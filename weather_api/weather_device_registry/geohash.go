@@ -0,0 +1,80 @@
+// Minimal geohash encoding, used to index devices by location.
+package main
+
+import (
+	"math"
+	"strings"
+)
+
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// precision of the geohash partitioning the geo index: cells of about 156km x 156km
+const geoCellPrecision = 3
+
+// precision of the geohash sorting the devices within a cell: a few meters
+const geohashPrecision = 9
+
+// geohashEncode returns the geohash of that location, with that many characters
+func geohashEncode(latitude, longitude float64, precision int) string {
+	latRange := [2]float64{-90, 90}
+	lonRange := [2]float64{-180, 180}
+
+	var hash strings.Builder
+	bits, char := 0, 0
+	isLonBit := true
+	for hash.Len() < precision {
+		var value float64
+		var valueRange *[2]float64
+		if isLonBit {
+			value, valueRange = longitude, &lonRange
+		} else {
+			value, valueRange = latitude, &latRange
+		}
+
+		mid := (valueRange[0] + valueRange[1]) / 2
+		char <<= 1
+		if value >= mid {
+			char |= 1
+			valueRange[0] = mid
+		} else {
+			valueRange[1] = mid
+		}
+		isLonBit = !isLonBit
+
+		if bits++; bits == 5 {
+			hash.WriteByte(geohashAlphabet[char])
+			bits, char = 0, 0
+		}
+	}
+	return hash.String()
+}
+
+// geohashCellSize returns the height and width in degrees of the geohash cells of that precision
+func geohashCellSize(precision int) (float64, float64) {
+	lonBits := (5*precision + 1) / 2
+	latBits := 5 * precision / 2
+	return 180 / math.Pow(2, float64(latBits)), 360 / math.Pow(2, float64(lonBits))
+}
+
+// geohashCovering returns the geohash cells of that precision overlapping the bounding box
+func geohashCovering(box BoundingBox, precision int) []string {
+	cellHeight, cellWidth := geohashCellSize(precision)
+	cells := []string{}
+	seen := map[string]bool{}
+	for lat := box.MinLatitude; ; lat = min(lat+cellHeight, box.MaxLatitude) {
+		for lon := box.MinLongitude; ; lon = min(lon+cellWidth, box.MaxLongitude) {
+			cell := geohashEncode(lat, lon, precision)
+			if !seen[cell] {
+				seen[cell] = true
+				cells = append(cells, cell)
+			}
+			if lon == box.MaxLongitude {
+				break
+			}
+		}
+		if lat == box.MaxLatitude {
+			break
+		}
+	}
+	return cells
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestGeohashEncode(t *testing.T) {
+	tests := []struct {
+		latitude  float64
+		longitude float64
+		precision int
+		expected  string
+	}{
+		{57.64911, 10.40744, 11, "u4pruydqqvj"},
+		{57.64911, 10.40744, 3, "u4p"},
+		{42.6, -5.6, 5, "ezs42"},
+		{-25.382708, -49.265506, 8, "6gkzwgjz"},
+		{0, 0, 5, "s0000"},
+		{-90, -180, 5, "00000"},
+		{90, 180, 5, "zzzzz"},
+		// cell edges belong to the cell above and to the right
+		{0, 45, 1, "t"},
+		{45, 0, 1, "u"},
+		{44.999999, 44.999999, 1, "s"},
+	}
+	for _, test := range tests {
+		if hash := geohashEncode(test.latitude, test.longitude, test.precision); hash != test.expected {
+			t.Errorf("(%v, %v) precision %d: geohash %s, expected %s", test.latitude, test.longitude, test.precision, hash, test.expected)
+		}
+	}
+}
+
+func TestGeohashCellSize(t *testing.T) {
+	tests := []struct {
+		precision int
+		height    float64
+		width     float64
+	}{
+		{1, 45, 45},
+		{2, 5.625, 11.25},
+		{3, 1.40625, 1.40625},
+		{5, 0.0439453125, 0.0439453125},
+		{9, 180.0 / (1 << 22), 360.0 / (1 << 23)},
+	}
+	for _, test := range tests {
+		height, width := geohashCellSize(test.precision)
+		if height != test.height || width != test.width {
+			t.Errorf("precision %d: cells of %v x %v, expected %v x %v", test.precision, height, width, test.height, test.width)
+		}
+
+		// the cells of that size tile the geohashes of that precision
+		origin := geohashEncode(0.1*height, 0.1*width, test.precision)
+		if above := geohashEncode(1.1*height, 0.1*width, test.precision); above == origin {
+			t.Errorf("precision %d: %s is also the cell above", test.precision, above)
+		}
+		if right := geohashEncode(0.1*height, 1.1*width, test.precision); right == origin {
+			t.Errorf("precision %d: %s is also the cell on the right", test.precision, right)
+		}
+		if inside := geohashEncode(0.9*height, 0.9*width, test.precision); inside != origin {
+			t.Errorf("precision %d: %s is another cell than %s", test.precision, inside, origin)
+		}
+	}
+}
+
+func TestGeohashCovering(t *testing.T) {
+	tests := []struct {
+		name      string
+		box       BoundingBox
+		precision int
+		expected  []string
+	}{
+		{"within one cell", BoundingBox{10, 10, 20, 20}, 1, []string{"s"}},
+		{"one whole cell", BoundingBox{0, 0, 44.9, 44.9}, 1, []string{"s"}},
+		{"up to the edges of the next cells", BoundingBox{0, 0, 45, 45}, 1, []string{"s", "t", "u", "v"}},
+		{"from the edges of the previous cells", BoundingBox{-0.1, -0.1, 0, 0}, 1, []string{"7", "k", "e", "s"}},
+		{"point", BoundingBox{47.37, 8.54, 47.37, 8.54}, 3, []string{"u0q"}},
+	}
+	for _, test := range tests {
+		if cells := geohashCovering(test.box, test.precision); !slices.Equal(cells, test.expected) {
+			t.Errorf("%s: cells %v, expected %v", test.name, cells, test.expected)
+		}
+	}
+
+	if cells := geohashCovering(BoundingBox{-90, -180, 90, 180}, 1); len(cells) != 32 {
+		t.Errorf("%d cells covering the world, expected all 32", len(cells))
+	}
+}
+
+// TestGeohashCoveringContainsBoxPoints checks that any point of the box, including its edges,
+// is in one of the covering cells
+func TestGeohashCoveringContainsBoxPoints(t *testing.T) {
+	boxes := []BoundingBox{
+		{47.2, 8.4, 47.6, 8.8},
+		// edges on cell boundaries at precision 3
+		{45, 0, 45 + 3*1.40625, 2 * 1.40625},
+		// edges just before cell boundaries
+		{44.999, -0.001, 47.812, 2.812},
+		{-33.9, 151.1, -33.7, 151.3},
+		{89.5, 179.5, 90, 180},
+		{-90, -180, -88.5, -178.5},
+	}
+	for _, box := range boxes {
+		cells := geohashCovering(box, geoCellPrecision)
+		const steps = 40
+		for i := 0; i <= steps; i++ {
+			for j := 0; j <= steps; j++ {
+				latitude := box.MinLatitude + (box.MaxLatitude-box.MinLatitude)*float64(i)/steps
+				longitude := box.MinLongitude + (box.MaxLongitude-box.MinLongitude)*float64(j)/steps
+				if cell := geohashEncode(latitude, longitude, geoCellPrecision); !slices.Contains(cells, cell) {
+					t.Fatalf("box %v: cell %s of (%v, %v) missing from the covering %v", box, cell, latitude, longitude, cells)
+				}
+			}
+		}
+
+		// and that no cell is covered twice
+		seen := map[string]bool{}
+		for _, cell := range cells {
+			if seen[cell] {
+				t.Errorf("box %v: cell %s covered twice in %s", box, cell, fmt.Sprint(cells))
+			}
+			seen[cell] = true
+		}
+	}
+}
@@ -0,0 +1,402 @@
+// Lambda serving the REST requests of the device registry, i.e. the list of weather
+// stations, their location, installed sensors and status.
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"weather_data_generator/weather_generator"
+)
+
+var dynamoClient *dynamodb.Client
+var dynamoTable *string
+
+const DEVICES_PK string = "DEVICES"
+
+const defaultPageSize = 50
+const maxPageSize = 100
+
+func init() {
+	dynamoTable = aws.String(os.Getenv("DYNAMO_TABLE"))
+
+	awsCfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		log.Fatal(err)
+	}
+	dynamoClient = dynamodb.NewFromConfig(awsCfg)
+}
+
+type Device struct {
+	DeviceId int64
+	Name     string
+	Location Location
+	// event types reported by this device
+	Sensors []string
+	// one of deviceStatuses
+	Status      string
+	InstalledAt time.Time
+	// whether the readings of this device are generated by weather_data_generator,
+	// as opposed to submitted by a real station through the ingestion API
+	Simulated bool
+}
+
+type Location struct {
+	Latitude  float64
+	Longitude float64
+	// meters above sea level
+	Altitude float64
+}
+
+// DevicePage is one page of the device listing. NextToken is empty on the last page.
+type DevicePage struct {
+	Devices   []Device
+	NextToken string `json:",omitempty"`
+}
+
+var deviceStatuses = []string{"active", "inactive", "maintenance"}
+
+var errDeviceNotFound = errors.New("device not found")
+var errDeviceExists = errors.New("device already exists")
+
+// handler routes the requests of the /devices, /devices/{device_id}, /devices/{device_id}/credentials
+// and geospatial lookup resources
+func handler(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	switch request.Resource {
+	case "/devices/near":
+		return nearDevices(ctx, request.QueryStringParameters), nil
+	case "/devices/within":
+		return devicesWithin(ctx, request.QueryStringParameters), nil
+	}
+
+	deviceIdStr, hasDeviceId := request.PathParameters["device_id"]
+	if !hasDeviceId {
+		switch request.HTTPMethod {
+		case "GET":
+			return listDevices(ctx, request.QueryStringParameters), nil
+		case "POST":
+			return createDevice(ctx, request.Body), nil
+		}
+		return clientError(405, "method not allowed"), nil
+	}
+
+	deviceId, err := strconv.ParseInt(deviceIdStr, 10, 64)
+	if err != nil {
+		return clientError(400, "invalid device_id"), nil
+	}
+	if request.Resource == "/devices/{device_id}/credentials" {
+		if request.HTTPMethod != "POST" {
+			return clientError(405, "method not allowed"), nil
+		}
+		return issueCredentials(ctx, deviceId), nil
+	}
+	switch request.HTTPMethod {
+	case "GET":
+		return getDevice(ctx, deviceId), nil
+	case "PUT":
+		return updateDevice(ctx, deviceId, request.Body), nil
+	case "DELETE":
+		return deleteDevice(ctx, deviceId), nil
+	}
+	return clientError(405, "method not allowed"), nil
+}
+
+func listDevices(ctx context.Context, params map[string]string) events.APIGatewayProxyResponse {
+	pageSize := defaultPageSize
+	if limitStr, ok := params["limit"]; ok {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return clientError(400, fmt.Sprintf("invalid limit param: should be within [1, %d]", maxPageSize))
+		}
+		pageSize = limit
+	}
+
+	startAfter, err := base64.URLEncoding.DecodeString(params["next_token"])
+	if err != nil {
+		return clientError(400, "invalid next_token param")
+	}
+
+	page, err := queryDevices(ctx, int32(pageSize), string(startAfter))
+	if err != nil {
+		log.Println(err)
+		return serverSideError()
+	}
+	log.Printf("returning %d devices", len(page.Devices))
+	return jsonResponse(200, page)
+}
+
+func getDevice(ctx context.Context, deviceId int64) events.APIGatewayProxyResponse {
+	device, err := fetchDevice(ctx, deviceId)
+	if err != nil {
+		return storageError(err)
+	}
+	return jsonResponse(200, device)
+}
+
+func createDevice(ctx context.Context, body string) events.APIGatewayProxyResponse {
+	device, err := parseDevice(body)
+	if err != nil {
+		return clientError(400, err.Error())
+	}
+	if device.DeviceId == 0 {
+		return clientError(400, "invalid device: missing DeviceId")
+	}
+	if device.InstalledAt.IsZero() {
+		device.InstalledAt = time.Now().UTC().Truncate(time.Second)
+	}
+
+	if err := putDevice(ctx, device, "attribute_not_exists(PK)", errDeviceExists); err != nil {
+		return storageError(err)
+	}
+	log.Printf("registered device %d", device.DeviceId)
+	return jsonResponse(201, device)
+}
+
+func updateDevice(ctx context.Context, deviceId int64, body string) events.APIGatewayProxyResponse {
+	device, err := parseDevice(body)
+	if err != nil {
+		return clientError(400, err.Error())
+	}
+	if device.DeviceId != 0 && device.DeviceId != deviceId {
+		return clientError(400, "DeviceId of the body does not match the one of the path")
+	}
+	device.DeviceId = deviceId
+	// a replacement omitting InstalledAt keeps the stored one, rather than resetting it
+	if device.InstalledAt.IsZero() {
+		stored, err := fetchDevice(ctx, deviceId)
+		if err != nil {
+			return storageError(err)
+		}
+		device.InstalledAt = stored.InstalledAt
+	}
+
+	if err := putDevice(ctx, device, "attribute_exists(PK)", errDeviceNotFound); err != nil {
+		return storageError(err)
+	}
+	log.Printf("updated device %d", device.DeviceId)
+	return jsonResponse(200, device)
+}
+
+func deleteDevice(ctx context.Context, deviceId int64) events.APIGatewayProxyResponse {
+	_, err := dynamoClient.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:           dynamoTable,
+		Key:                 deviceKey(deviceId),
+		ConditionExpression: aws.String("attribute_exists(PK)"),
+	})
+	if err := conditionalError(err, errDeviceNotFound); err != nil {
+		return storageError(err)
+	}
+	if err := revokeCredentials(ctx, deviceId); err != nil {
+		log.Println(err)
+		return serverSideError()
+	}
+	log.Printf("removed device %d", deviceId)
+	return events.APIGatewayProxyResponse{
+		StatusCode: 204,
+	}
+}
+
+// parseDevice parses and validates the JSON description of a device
+func parseDevice(body string) (Device, error) {
+	device := Device{}
+	if err := json.Unmarshal([]byte(body), &device); err != nil {
+		return device, fmt.Errorf("invalid device: %w", err)
+	}
+	if device.DeviceId < 0 {
+		return device, errors.New("invalid device: DeviceId should be positive")
+	}
+	if device.Location.Latitude < -90 || device.Location.Latitude > 90 {
+		return device, errors.New("invalid device: Latitude should be within [-90, 90]")
+	}
+	if device.Location.Longitude < -180 || device.Location.Longitude > 180 {
+		return device, errors.New("invalid device: Longitude should be within [-180, 180]")
+	}
+	for _, sensor := range device.Sensors {
+		if !weather_generator.IsEventType(sensor) {
+			return device, fmt.Errorf("invalid device: unknown sensor %q", sensor)
+		}
+	}
+	if device.Status == "" {
+		device.Status = "active"
+	} else if !slices.Contains(deviceStatuses, device.Status) {
+		return device, fmt.Errorf("invalid device: Status should be one of %v", deviceStatuses)
+	}
+	return device, nil
+}
+
+// fetchDevice reads that device, or returns errDeviceNotFound
+func fetchDevice(ctx context.Context, deviceId int64) (Device, error) {
+	getResult, err := dynamoClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: dynamoTable,
+		Key:       deviceKey(deviceId),
+	})
+	if err != nil {
+		return Device{}, fmt.Errorf("error while reading device from DynamodDB: %w", err)
+	}
+	if getResult.Item == nil {
+		return Device{}, errDeviceNotFound
+	}
+
+	device := Device{}
+	if err := attributevalue.UnmarshalMap(getResult.Item, &device); err != nil {
+		return Device{}, fmt.Errorf("failed to parse device %d: %w", deviceId, err)
+	}
+	return device, nil
+}
+
+// queryDevices fetches one page of devices, starting after the one of sort key startAfter, if any.
+// The next token of the page encodes the sort key of its last device.
+func queryDevices(ctx context.Context, pageSize int32, startAfter string) (DevicePage, error) {
+	expr, err := expression.NewBuilder().
+		WithKeyCondition(
+			expression.Key("PK").Equal(expression.Value(DEVICES_PK)),
+		).
+		Build()
+	if err != nil {
+		return DevicePage{}, fmt.Errorf("error while building DynamoDB query: %w", err)
+	}
+
+	query := dynamodb.QueryInput{
+		TableName:                 dynamoTable,
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		Limit:                     aws.Int32(pageSize),
+	}
+	if startAfter != "" {
+		query.ExclusiveStartKey = map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: DEVICES_PK},
+			"SK": &types.AttributeValueMemberS{Value: startAfter},
+		}
+	}
+
+	queryResult, err := dynamoClient.Query(ctx, &query)
+	if err != nil {
+		return DevicePage{}, fmt.Errorf("error while querying DynamodDB: %w", err)
+	}
+
+	page := DevicePage{
+		Devices: make([]Device, 0, len(queryResult.Items)),
+	}
+	for _, rawDevice := range queryResult.Items {
+		device := Device{}
+		if err := attributevalue.UnmarshalMap(rawDevice, &device); err != nil {
+			log.Printf("failed to parse device %v, skipping %v", rawDevice, err)
+			continue
+		}
+		page.Devices = append(page.Devices, device)
+	}
+	if lastSortKey, ok := queryResult.LastEvaluatedKey["SK"].(*types.AttributeValueMemberS); ok {
+		page.NextToken = base64.URLEncoding.EncodeToString([]byte(lastSortKey.Value))
+	}
+	return page, nil
+}
+
+// putDevice writes the device in DynamoDB, provided the condition holds. conditionErr
+// is returned if it does not.
+func putDevice(ctx context.Context, device Device, condition string, conditionErr error) error {
+	item, err := attributevalue.MarshalMap(device)
+	if err != nil {
+		return fmt.Errorf("failed to marshal device %d: %w", device.DeviceId, err)
+	}
+	for k, v := range deviceKey(device.DeviceId) {
+		item[k] = v
+	}
+	// attributes of the geo index
+	item["GeoCell"] = &types.AttributeValueMemberS{
+		Value: geohashEncode(device.Location.Latitude, device.Location.Longitude, geoCellPrecision),
+	}
+	item["Geohash"] = &types.AttributeValueMemberS{
+		Value: geohashEncode(device.Location.Latitude, device.Location.Longitude, geohashPrecision),
+	}
+
+	_, err = dynamoClient.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           dynamoTable,
+		Item:                item,
+		ConditionExpression: aws.String(condition),
+	})
+	return conditionalError(err, conditionErr)
+}
+
+// conditionalError translates a failed DynamoDB condition into conditionErr
+func conditionalError(err error, conditionErr error) error {
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return conditionErr
+	}
+	if err != nil {
+		return fmt.Errorf("error while writing device in DynamoDB: %w", err)
+	}
+	return nil
+}
+
+func deviceKey(deviceId int64) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{
+			Value: DEVICES_PK,
+		},
+		"SK": &types.AttributeValueMemberS{
+			Value: fmt.Sprintf("DeviceId#%d", deviceId),
+		},
+	}
+}
+
+func storageError(err error) events.APIGatewayProxyResponse {
+	switch {
+	case errors.Is(err, errDeviceNotFound):
+		return clientError(404, err.Error())
+	case errors.Is(err, errDeviceExists):
+		return clientError(409, err.Error())
+	}
+	log.Println(err)
+	return serverSideError()
+}
+
+func jsonResponse(statusCode int, payload any) events.APIGatewayProxyResponse {
+	jsonBytes, err := json.Marshal(payload)
+	if err != nil {
+		log.Println(err)
+		return serverSideError()
+	}
+	return events.APIGatewayProxyResponse{
+		Body:       string(jsonBytes),
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}
+}
+
+func clientError(statusCode int, msg string) events.APIGatewayProxyResponse {
+	log.Println(msg)
+	return events.APIGatewayProxyResponse{
+		Body:       msg,
+		StatusCode: statusCode,
+	}
+}
+
+func serverSideError() events.APIGatewayProxyResponse {
+	return events.APIGatewayProxyResponse{
+		Body:       "failed to access the device registry",
+		StatusCode: 500,
+	}
+}
+
+func main() {
+	lambda.Start(handler)
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// attributeValue is a DynamoDB attribute value in its JSON form, e.g. {"S": "DEVICES"}
+type attributeValue map[string]any
+
+// fakeDynamo is a local stand-in of DynamoDB holding the devices, in their JSON form, supporting their
+// reads, conditional writes, paginated queries and queries of the geo index. Devices have no readings.
+type fakeDynamo struct {
+	mutex sync.Mutex
+	// items of the DEVICES partition, per sort key
+	devices map[string]map[string]attributeValue
+	// Limit of each query
+	queryLimits []int
+}
+
+func (f *fakeDynamo) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+
+	input := struct {
+		Key                       map[string]attributeValue
+		Item                      map[string]attributeValue
+		ConditionExpression       string
+		ExclusiveStartKey         map[string]attributeValue
+		Limit                     int
+		IndexName                 string
+		ExpressionAttributeValues map[string]attributeValue
+	}{}
+	json.NewDecoder(r.Body).Decode(&input)
+
+	switch target := r.Header.Get("X-Amz-Target"); {
+	case target == "DynamoDB_20120810.GetItem":
+		item, ok := f.devices[sortKey(input.Key)]
+		if !ok {
+			fmt.Fprint(w, `{}`)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"Item": item})
+	case target == "DynamoDB_20120810.PutItem":
+		_, exists := f.devices[sortKey(input.Item)]
+		if (input.ConditionExpression == "attribute_exists(PK)") != exists {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"__type": "com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException", "message": "failed"}`)
+			return
+		}
+		f.devices[sortKey(input.Item)] = input.Item
+		fmt.Fprint(w, `{}`)
+	case target == "DynamoDB_20120810.Query" && input.IndexName == geoIndex:
+		// the key condition GeoCell = :0
+		items := []map[string]attributeValue{}
+		for _, device := range f.devices {
+			if device["GeoCell"]["S"] == input.ExpressionAttributeValues[":0"]["S"] {
+				items = append(items, device)
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"Items": items, "Count": len(items)})
+	case target == "DynamoDB_20120810.Query" && input.ExpressionAttributeValues[":0"]["S"] != DEVICES_PK:
+		// latest readings of a device
+		fmt.Fprint(w, `{"Items": [], "Count": 0}`)
+	case target == "DynamoDB_20120810.Query":
+		f.queryLimits = append(f.queryLimits, input.Limit)
+		sortKeys := make([]string, 0, len(f.devices))
+		for sk := range f.devices {
+			if input.ExclusiveStartKey == nil || sk > sortKey(input.ExclusiveStartKey) {
+				sortKeys = append(sortKeys, sk)
+			}
+		}
+		sort.Strings(sortKeys)
+
+		output := map[string]any{}
+		// like DynamoDB, the last evaluated key is returned as soon as the limit is reached,
+		// even without any item left
+		if len(sortKeys) >= input.Limit {
+			sortKeys = sortKeys[:input.Limit]
+			output["LastEvaluatedKey"] = map[string]attributeValue{"PK": {"S": DEVICES_PK}, "SK": {"S": sortKeys[len(sortKeys)-1]}}
+		}
+		items := []map[string]attributeValue{}
+		for _, sk := range sortKeys {
+			items = append(items, f.devices[sk])
+		}
+		output["Items"], output["Count"] = items, len(items)
+		json.NewEncoder(w).Encode(output)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"__type": "com.amazon.coral.validate#ValidationException", "message": "unsupported %s"}`, target)
+	}
+}
+
+func sortKey(key map[string]attributeValue) string {
+	return fmt.Sprint(key["SK"]["S"])
+}
+
+// withFakeDynamo points the lambda to a local stand-in of DynamoDB, containing those devices
+func withFakeDynamo(t *testing.T, devices ...Device) *fakeDynamo {
+	fake := &fakeDynamo{devices: map[string]map[string]attributeValue{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	dynamoClient = dynamodb.New(dynamodb.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "test", SecretAccessKey: "test"}, nil
+		}),
+		RetryMaxAttempts: 1,
+	})
+	dynamoTable = aws.String("weather")
+
+	for _, device := range devices {
+		if err := putDevice(context.Background(), device, "attribute_not_exists(PK)", errDeviceExists); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return fake
+}
+
+func TestParseDevice(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected Device
+		valid    bool
+	}{
+		{
+			"full device",
+			`{"DeviceId": 1001, "Name": "Zurich", "Location": {"Latitude": 47.37, "Longitude": 8.54, "Altitude": 408}, "Sensors": ["Temperature", "PM25"], "Status": "maintenance", "InstalledAt": "2024-03-01T12:00:00Z", "Simulated": true}`,
+			Device{DeviceId: 1001, Name: "Zurich", Location: Location{47.37, 8.54, 408}, Sensors: []string{"Temperature", "PM25"}, Status: "maintenance", InstalledAt: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), Simulated: true},
+			true,
+		},
+		{"default status", `{"DeviceId": 1001}`, Device{DeviceId: 1001, Status: "active"}, true},
+		{"without device id", `{"Name": "Zurich"}`, Device{Name: "Zurich", Status: "active"}, true},
+		{"boundaries", `{"Location": {"Latitude": -90, "Longitude": 180}}`, Device{Location: Location{Latitude: -90, Longitude: 180}, Status: "active"}, true},
+		{"invalid json", `{"DeviceId": `, Device{}, false},
+		{"wrong type", `{"DeviceId": "1001"}`, Device{}, false},
+		{"negative device id", `{"DeviceId": -1}`, Device{}, false},
+		{"latitude", `{"Location": {"Latitude": 90.5}}`, Device{}, false},
+		{"longitude", `{"Location": {"Longitude": -180.5}}`, Device{}, false},
+		{"unknown sensor", `{"Sensors": ["Temperature", "Snow"]}`, Device{}, false},
+		{"unknown status", `{"Status": "broken"}`, Device{}, false},
+		{"invalid installation time", `{"InstalledAt": "yesterday"}`, Device{}, false},
+	}
+	for _, test := range tests {
+		device, err := parseDevice(test.body)
+		if (err == nil) != test.valid {
+			t.Errorf("%s: error %v, expected valid %v", test.name, err, test.valid)
+			continue
+		}
+		if test.valid && fmt.Sprint(device) != fmt.Sprint(test.expected) {
+			t.Errorf("%s: device %+v, expected %+v", test.name, device, test.expected)
+		}
+	}
+}
+
+func TestListDevices(t *testing.T) {
+	devices := []Device{}
+	for _, deviceId := range []int64{1001, 1002, 1003, 1004, 1005} {
+		devices = append(devices, Device{DeviceId: deviceId, Status: "active"})
+	}
+
+	tests := []struct {
+		name  string
+		limit string
+		// device ids of each page
+		expected [][]int64
+	}{
+		{"default page size", "", [][]int64{{1001, 1002, 1003, 1004, 1005}}},
+		{"partial last page", "2", [][]int64{{1001, 1002}, {1003, 1004}, {1005}}},
+		{"full last page", "5", [][]int64{{1001, 1002, 1003, 1004, 1005}, {}}},
+		{"one per page", "1", [][]int64{{1001}, {1002}, {1003}, {1004}, {1005}, {}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := withFakeDynamo(t, devices...)
+			params := map[string]string{}
+			if test.limit != "" {
+				params["limit"] = test.limit
+			}
+
+			pages := [][]int64{}
+			for len(pages) <= len(test.expected) {
+				response := listDevices(context.Background(), params)
+				if response.StatusCode != 200 {
+					t.Fatalf("status %d: %s", response.StatusCode, response.Body)
+				}
+				page := DevicePage{}
+				if err := json.Unmarshal([]byte(response.Body), &page); err != nil {
+					t.Fatal(err)
+				}
+				ids := []int64{}
+				for _, device := range page.Devices {
+					ids = append(ids, device.DeviceId)
+				}
+				pages = append(pages, ids)
+				if page.NextToken == "" {
+					break
+				}
+				params["next_token"] = page.NextToken
+			}
+
+			if fmt.Sprint(pages) != fmt.Sprint(test.expected) {
+				t.Errorf("pages %v, expected %v", pages, test.expected)
+			}
+			expectedLimit := defaultPageSize
+			if test.limit != "" {
+				fmt.Sscan(test.limit, &expectedLimit)
+			}
+			if fake.queryLimits[0] != expectedLimit {
+				t.Errorf("queried %d devices, expected %d", fake.queryLimits[0], expectedLimit)
+			}
+		})
+	}
+}
+
+func TestListDevicesNextToken(t *testing.T) {
+	withFakeDynamo(t, Device{DeviceId: 1001}, Device{DeviceId: 1002})
+
+	response := listDevices(context.Background(), map[string]string{"limit": "1"})
+	page := DevicePage{}
+	if err := json.Unmarshal([]byte(response.Body), &page); err != nil {
+		t.Fatal(err)
+	}
+	// the token is the sort key of the last device of the page
+	if token, _ := base64.URLEncoding.DecodeString(page.NextToken); string(token) != "DeviceId#1001" {
+		t.Errorf("next token %q decoding to %q, expected the sort key of device 1001", page.NextToken, token)
+	}
+
+	invalidParams := []map[string]string{
+		{"limit": "0"},
+		{"limit": fmt.Sprint(maxPageSize + 1)},
+		{"limit": "ten"},
+		{"next_token": "not base64!"},
+	}
+	for _, params := range invalidParams {
+		if response := listDevices(context.Background(), params); response.StatusCode != 400 {
+			t.Errorf("%v: status %d, expected 400", params, response.StatusCode)
+		}
+	}
+}
+
+func TestUpdateDeviceKeepsInstalledAt(t *testing.T) {
+	installedAt := time.Date(2023, 6, 1, 8, 0, 0, 0, time.UTC)
+	fake := withFakeDynamo(t, Device{DeviceId: 1001, Status: "active", InstalledAt: installedAt})
+
+	request := events.APIGatewayProxyRequest{
+		Resource:       "/devices/{device_id}",
+		HTTPMethod:     "PUT",
+		PathParameters: map[string]string{"device_id": "1001"},
+		Body:           `{"Name": "Zurich", "Status": "maintenance"}`,
+	}
+	response, err := handler(context.Background(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != 200 {
+		t.Fatalf("status %d: %s", response.StatusCode, response.Body)
+	}
+	stored, err := fetchDevice(context.Background(), 1001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stored.InstalledAt.Equal(installedAt) || stored.Status != "maintenance" || !strings.Contains(response.Body, `"InstalledAt":"2023-06-01T08:00:00Z"`) {
+		t.Errorf("stored %+v and responded %s, expected the new status and the previous installation time", stored, response.Body)
+	}
+
+	// an explicit installation time replaces the stored one
+	request.Body = `{"Name": "Zurich", "InstalledAt": "2024-03-01T12:00:00Z"}`
+	if response, _ := handler(context.Background(), request); response.StatusCode != 200 {
+		t.Fatalf("status %d: %s", response.StatusCode, response.Body)
+	}
+	if stored, _ := fetchDevice(context.Background(), 1001); !stored.InstalledAt.Equal(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("stored %+v, expected the new installation time", stored)
+	}
+
+	tests := []struct {
+		deviceId string
+		body     string
+		status   int
+	}{
+		{"1002", `{"Name": "Geneva"}`, 404},
+		{"1002", `{"Name": "Geneva", "InstalledAt": "2024-03-01T12:00:00Z"}`, 404},
+		{"1001", `{"DeviceId": 1002}`, 400},
+		{"1001", `{"Status": "broken"}`, 400},
+	}
+	for _, test := range tests {
+		request.PathParameters["device_id"], request.Body = test.deviceId, test.body
+		if response, _ := handler(context.Background(), request); response.StatusCode != test.status {
+			t.Errorf("PUT %s %s: status %d, expected %d", test.deviceId, test.body, response.StatusCode, test.status)
+		}
+	}
+	if _, found := fake.devices["DeviceId#1002"]; found || len(fake.devices) != 1 {
+		t.Errorf("%d devices stored, expected device 1001 only", len(fake.devices))
+	}
+}
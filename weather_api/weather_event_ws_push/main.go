@@ -1,13 +1,16 @@
 // Lambda listening to new weather events from DynamoDB stream and forwarding
 // them in JSON format to all currently connected websocket clients.
+// The format of those messages is described in ../asyncapi.yaml
 package main
 
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"sync"
 	"time"
 
@@ -21,6 +24,8 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/service/apigatewaymanagementapi"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"weather_data_generator/weather_generator"
 )
 
 var dynamodbClient *dynamodb.Client
@@ -63,18 +68,8 @@ func handler(ctx context.Context, event events.DynamoDBEvent) {
 	if len(connectionIds) > 0 {
 		weatherEvents := [][]byte{}
 		for _, record := range event.Records {
-			cleanEvent := map[string]any{}
-			for k, v := range record.Change.NewImage {
-				if k != "PK" && k != "SK" {
-					if v.DataType() == events.DataTypeString {
-						cleanEvent[k] = v.String()
-					} else if v.DataType() == events.DataTypeNumber {
-						cleanEvent[k] = v.Number()
-					}
-				}
-			}
-			if eventBytes, err := json.Marshal(cleanEvent); err != nil {
-				log.Println("failed to process DynamoDB event", err)
+			if eventBytes, err := weatherEventPayload(record); err != nil {
+				log.Printf("not forwarding %s record: %v", record.EventName, err)
 			} else {
 				weatherEvents = append(weatherEvents, eventBytes)
 			}
@@ -87,6 +82,50 @@ func handler(ctx context.Context, event events.DynamoDBEvent) {
 	}
 }
 
+// weatherEventPayload builds the message sent to the websocket clients from the new image of that record,
+// i.e. a WeatherEvent of ../asyncapi.yaml: its string and number attributes, without the keys of the item
+func weatherEventPayload(record events.DynamoDBEventRecord) ([]byte, error) {
+	cleanEvent := map[string]any{}
+	for k, v := range record.Change.NewImage {
+		if k != "PK" && k != "SK" {
+			if v.DataType() == events.DataTypeString {
+				cleanEvent[k] = v.String()
+			} else if v.DataType() == events.DataTypeNumber {
+				cleanEvent[k] = v.Number()
+			}
+		}
+	}
+	if err := validateWeatherEvent(cleanEvent); err != nil {
+		return nil, err
+	}
+	eventBytes, err := json.Marshal(cleanEvent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize event: %w", err)
+	}
+	return eventBytes, nil
+}
+
+// validateWeatherEvent checks that the payload about to be sent is a complete weather event of a known type
+func validateWeatherEvent(weatherEvent map[string]any) error {
+	eventType, ok := weatherEvent["EventType"].(string)
+	if !ok {
+		return errors.New("missing EventType")
+	}
+	if !weather_generator.IsEventType(eventType) {
+		return fmt.Errorf("unknown event type %q", eventType)
+	}
+	for _, numericField := range []string{"DeviceId", "Time", "Value"} {
+		number, ok := weatherEvent[numericField].(string)
+		if !ok {
+			return fmt.Errorf("missing or non numeric %s", numericField)
+		}
+		if _, err := strconv.ParseFloat(number, 64); err != nil {
+			return fmt.Errorf("invalid %s %q", numericField, number)
+		}
+	}
+	return nil
+}
+
 // queryActiveSessionIds retrieves the list of connection id of currently connected ws clients
 func queryActiveSessionIds(ctx context.Context) ([]string, error) {
 	expr, err := expression.NewBuilder().
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"gopkg.in/yaml.v3"
+)
+
+// weatherEventSchema reads the schema of the WeatherEvent messages from the AsyncAPI document
+func weatherEventSchema(t *testing.T) map[string]any {
+	spec, err := os.ReadFile("../asyncapi.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	document := map[string]any{}
+	if err := yaml.Unmarshal(spec, &document); err != nil {
+		t.Fatal(err)
+	}
+	components, _ := document["components"].(map[string]any)
+	schemas, _ := components["schemas"].(map[string]any)
+	schema, ok := schemas["WeatherEvent"].(map[string]any)
+	if !ok {
+		t.Fatal("WeatherEvent schema not found in asyncapi.yaml")
+	}
+	return schema
+}
+
+// validatePayload checks that JSON payload against that object schema, whose properties are strings.
+// Every property of the payload should be declared, as the schema documents the whole message.
+func validatePayload(schema map[string]any, payload []byte) error {
+	message := map[string]any{}
+	if err := json.Unmarshal(payload, &message); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+	required, _ := schema["required"].([]any)
+	for _, name := range required {
+		if _, ok := message[name.(string)]; !ok {
+			return fmt.Errorf("missing required %s", name)
+		}
+	}
+	properties, _ := schema["properties"].(map[string]any)
+	for name, value := range message {
+		property, ok := properties[name].(map[string]any)
+		if !ok {
+			return fmt.Errorf("undeclared property %s", name)
+		}
+		if property["type"] != "string" {
+			return fmt.Errorf("unexpected type %v of %s in the schema", property["type"], name)
+		}
+		str, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s is %v, expected a string", name, value)
+		}
+		if pattern, ok := property["pattern"].(string); ok && !regexp.MustCompile(pattern).MatchString(str) {
+			return fmt.Errorf("%s is %q, expected to match %s", name, str, pattern)
+		}
+		if enum, ok := property["enum"].([]any); ok && !slices.Contains(enum, any(str)) {
+			return fmt.Errorf("%s is %q, expected one of %v", name, str, enum)
+		}
+	}
+	return nil
+}
+
+func insertRecord(image map[string]events.DynamoDBAttributeValue) events.DynamoDBEventRecord {
+	return events.DynamoDBEventRecord{
+		EventName: string(events.DynamoDBOperationTypeInsert),
+		Change:    events.DynamoDBStreamRecord{NewImage: image},
+	}
+}
+
+func temperatureImage() map[string]events.DynamoDBAttributeValue {
+	return map[string]events.DynamoDBAttributeValue{
+		"PK":        events.NewStringAttribute("DeviceId#1001"),
+		"SK":        events.NewStringAttribute("Time#1709294400#TypeTemperature"),
+		"DeviceId":  events.NewNumberAttribute("1001"),
+		"Time":      events.NewNumberAttribute("1709294400"),
+		"EventType": events.NewStringAttribute("Temperature"),
+		"Value":     events.NewNumberAttribute("21.500000"),
+		"Unit":      events.NewStringAttribute("°C"),
+	}
+}
+
+func TestWeatherEventPayloadConformsToAsyncApiSpec(t *testing.T) {
+	schema := weatherEventSchema(t)
+
+	withFault := temperatureImage()
+	withFault["Value"] = events.NewNumberAttribute("-1.5e3")
+	withFault["Fault"] = events.NewStringAttribute("OutOfRange")
+	expiring := temperatureImage()
+	expiring["ExpiresAt"] = events.NewNumberAttribute("1711886400")
+	withoutUnit := temperatureImage()
+	delete(withoutUnit, "Unit")
+	// attributes of other types are not forwarded
+	withList := temperatureImage()
+	withList["Tags"] = events.NewStringSetAttribute([]string{"calibrated"})
+
+	tests := map[string]map[string]events.DynamoDBAttributeValue{
+		"event":         temperatureImage(),
+		"faulty event":  withFault,
+		"expiring":      expiring,
+		"without unit":  withoutUnit,
+		"set attribute": withList,
+	}
+	for name, image := range tests {
+		t.Run(name, func(t *testing.T) {
+			payload, err := weatherEventPayload(insertRecord(image))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := validatePayload(schema, payload); err != nil {
+				t.Errorf("payload %s does not conform to the spec: %v", payload, err)
+			}
+		})
+	}
+}
+
+func TestWeatherEventPayloadRefusesIncompleteEvents(t *testing.T) {
+	unknownType := temperatureImage()
+	unknownType["EventType"] = events.NewStringAttribute("Snow")
+	missingValue := temperatureImage()
+	delete(missingValue, "Value")
+	stringDevice := temperatureImage()
+	stringDevice["DeviceId"] = events.NewStringAttribute("station")
+	notAnEvent := map[string]events.DynamoDBAttributeValue{
+		"PK":           events.NewStringAttribute("WS_SESSIONS"),
+		"SK":           events.NewStringAttribute("abc="),
+		"ConnectionId": events.NewStringAttribute("abc="),
+	}
+
+	for name, image := range map[string]map[string]events.DynamoDBAttributeValue{
+		"unknown type":  unknownType,
+		"missing value": missingValue,
+		"string device": stringDevice,
+		"not an event":  notAnEvent,
+	} {
+		if payload, err := weatherEventPayload(insertRecord(image)); err == nil {
+			t.Errorf("%s: payload %s, expected an error", name, payload)
+		}
+	}
+}
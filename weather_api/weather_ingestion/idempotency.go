@@ -0,0 +1,116 @@
+// Idempotency keys, allowing stations to safely retry a submission whose response they did not get.
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const IDEMPOTENCY_KEYS_PK string = "IDEMPOTENCY_KEYS"
+
+// how long a submission can be retried with the same idempotency key
+const idempotencyWindow = 24 * time.Hour
+
+// IdempotentResponse is the response of a previous submission with the same idempotency key
+type IdempotentResponse struct {
+	// SHA-256 of the body of the request
+	RequestHash string
+	StatusCode  int
+	Body        string
+	// TTL attribute of the table
+	ExpiresAt int64
+}
+
+func findIdempotentResponse(ctx context.Context, deviceId int64, idempotencyKey string) (IdempotentResponse, bool, error) {
+	getResult, err := dynamoClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: dynamoTable,
+		Key:       idempotencyRecordKey(deviceId, idempotencyKey),
+	})
+	if err != nil {
+		return IdempotentResponse{}, false, fmt.Errorf("error while reading idempotency key from DynamodDB: %w", err)
+	}
+	if getResult.Item == nil {
+		return IdempotentResponse{}, false, nil
+	}
+
+	previous := IdempotentResponse{}
+	if err := attributevalue.UnmarshalMap(getResult.Item, &previous); err != nil {
+		return IdempotentResponse{}, false, fmt.Errorf("failed to parse idempotency record: %w", err)
+	}
+	// TTL removal is lazy
+	if previous.ExpiresAt < time.Now().Unix() {
+		return IdempotentResponse{}, false, nil
+	}
+	return previous, true, nil
+}
+
+// replay returns the previous response, provided the request is the same as the previous one
+func (r IdempotentResponse) replay(requestBody string, requestId string) events.APIGatewayProxyResponse {
+	if r.RequestHash != requestHash(requestBody) {
+		return idempotencyKeyReusedProblem(requestId)
+	}
+	return events.APIGatewayProxyResponse{
+		Body:       r.Body,
+		StatusCode: r.StatusCode,
+		Headers: map[string]string{
+			"Content-Type":        "application/json",
+			"Idempotent-Replayed": "true",
+		},
+	}
+}
+
+func storeIdempotentResponse(ctx context.Context, deviceId int64, idempotencyKey string, requestBody string, response events.APIGatewayProxyResponse) error {
+	item, err := attributevalue.MarshalMap(IdempotentResponse{
+		RequestHash: requestHash(requestBody),
+		StatusCode:  response.StatusCode,
+		Body:        response.Body,
+		ExpiresAt:   time.Now().Add(idempotencyWindow).Unix(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal idempotency record: %w", err)
+	}
+	for k, v := range idempotencyRecordKey(deviceId, idempotencyKey) {
+		item[k] = v
+	}
+
+	_, err = dynamoClient.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           dynamoTable,
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(PK)"),
+	})
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		// a concurrent retry of the same request got there first
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error while writing idempotency key in DynamoDB: %w", err)
+	}
+	return nil
+}
+
+func idempotencyRecordKey(deviceId int64, idempotencyKey string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{
+			Value: IDEMPOTENCY_KEYS_PK,
+		},
+		"SK": &types.AttributeValueMemberS{
+			Value: fmt.Sprintf("DeviceId#%d#Key#%s", deviceId, idempotencyKey),
+		},
+	}
+}
+
+func requestHash(requestBody string) string {
+	hash := sha256.Sum256([]byte(requestBody))
+	return hex.EncodeToString(hash[:])
+}
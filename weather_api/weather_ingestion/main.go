@@ -0,0 +1,282 @@
+// Lambda serving the POST requests through which real weather stations submit their readings,
+// authenticated by the per-device credentials issued by the device registry.
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"weather_data_generator/weather_generator"
+	"weather_data_generator/weather_storage"
+)
+
+var dynamoClient *dynamodb.Client
+var dynamoTable *string
+var writer weather_storage.Writer
+
+const DEVICE_CREDENTIALS_PK string = "DEVICE_CREDENTIALS"
+
+const maxBatchSize = 500
+
+// readings older than that are refused, as they would not be visible in DynamoDB for long
+const maxReadingAge = 7 * 24 * time.Hour
+
+// tolerated clock difference between the stations and the API
+const maxClockSkew = 5 * time.Minute
+
+func init() {
+	dynamoTable = aws.String(os.Getenv("DYNAMO_TABLE"))
+
+	// how long events are kept in DynamoDB before expiring (and being archived), 0 to keep them forever
+	retention, err := weather_storage.ParseRetention(os.Getenv("RETENTION_DAYS"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	awsCfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		log.Fatal(err)
+	}
+	dynamoClient = dynamodb.NewFromConfig(awsCfg)
+	writer = weather_storage.NewWriter(dynamoClient, *dynamoTable, retention)
+}
+
+// Reading is one weather event, as submitted by a station. DeviceId may be omitted, and defaults to
+// the authenticated device. Unit may be omitted, and defaults to the metric unit of the event type.
+type Reading struct {
+	DeviceId  int64
+	Time      time.Time
+	EventType string
+	Value     *float64
+	Unit      string
+}
+
+// ReadingBatch is the body of POST /weather/batch
+type ReadingBatch struct {
+	Readings []Reading
+}
+
+// IngestionResult is the body of the successful responses
+type IngestionResult struct {
+	Accepted int
+	// readings of the request having the same time and event type as another one of the request
+	Duplicates int
+}
+
+// DeviceCredentials is managed by weather_device_registry
+type DeviceCredentials struct {
+	DeviceId   int64
+	SecretHash string
+}
+
+var errUnauthorized = errors.New("missing or invalid device credentials")
+
+// handler serves POST /weather (one reading) and POST /weather/batch (several readings)
+func handler(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	requestId := request.RequestContext.RequestID
+	deviceId, err := authenticate(ctx, request.Headers)
+	if errors.Is(err, errUnauthorized) {
+		return unauthorizedProblem(requestId), nil
+	} else if err != nil {
+		log.Println(err)
+		return serverSideProblem(requestId), nil
+	}
+
+	idempotencyKey := header(request.Headers, "Idempotency-Key")
+	if idempotencyKey != "" {
+		previous, found, err := findIdempotentResponse(ctx, deviceId, idempotencyKey)
+		if err != nil {
+			log.Println(err)
+			return serverSideProblem(requestId), nil
+		}
+		if found {
+			return previous.replay(request.Body, requestId), nil
+		}
+	}
+
+	var readings []Reading
+	if request.Resource == "/weather/batch" {
+		batch := ReadingBatch{}
+		if err := decodeStrictly(request.Body, &batch); err != nil {
+			return invalidReadingProblem(fmt.Sprintf("invalid reading batch: %v", err), requestId), nil
+		}
+		readings = batch.Readings
+	} else {
+		reading := Reading{}
+		if err := decodeStrictly(request.Body, &reading); err != nil {
+			return invalidReadingProblem(fmt.Sprintf("invalid reading: %v", err), requestId), nil
+		}
+		readings = []Reading{reading}
+	}
+	if len(readings) == 0 || len(readings) > maxBatchSize {
+		return invalidReadingProblem(fmt.Sprintf("invalid reading batch: should contain between 1 and %d readings", maxBatchSize), requestId), nil
+	}
+
+	weatherEvents, duplicates, err := toWeatherEvents(deviceId, readings, time.Now())
+	if err != nil {
+		return invalidReadingProblem(err.Error(), requestId), nil
+	}
+
+	if err := writer.AddAll(ctx, weatherEvents); err != nil {
+		log.Println(err)
+		return serverSideProblem(requestId), nil
+	}
+	log.Printf("ingested %d events of device %d", len(weatherEvents), deviceId)
+
+	response := jsonResponse(202, IngestionResult{Accepted: len(weatherEvents), Duplicates: duplicates}, requestId)
+	if idempotencyKey != "" {
+		if err := storeIdempotentResponse(ctx, deviceId, idempotencyKey, request.Body, response); err != nil {
+			// the events are stored anyway, and storing them again would be harmless
+			log.Println("failed to store idempotency key", err)
+		}
+	}
+	return response, nil
+}
+
+// authenticate checks the X-Device-Id and X-Device-Secret headers against the credentials
+// issued by the device registry, and returns the authenticated device id
+func authenticate(ctx context.Context, headers map[string]string) (int64, error) {
+	deviceId, err := strconv.ParseInt(header(headers, "X-Device-Id"), 10, 64)
+	secret := header(headers, "X-Device-Secret")
+	if err != nil || secret == "" {
+		return 0, errUnauthorized
+	}
+
+	getResult, err := dynamoClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: dynamoTable,
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: DEVICE_CREDENTIALS_PK},
+			"SK": &types.AttributeValueMemberS{Value: fmt.Sprintf("DeviceId#%d", deviceId)},
+		},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error while reading credentials from DynamodDB: %w", err)
+	}
+	if getResult.Item == nil {
+		log.Printf("no credentials issued for device %d", deviceId)
+		return 0, errUnauthorized
+	}
+	credentials := DeviceCredentials{}
+	if err := attributevalue.UnmarshalMap(getResult.Item, &credentials); err != nil {
+		return 0, fmt.Errorf("failed to parse credentials of device %d: %w", deviceId, err)
+	}
+
+	secretHash := sha256.Sum256([]byte(secret))
+	if subtle.ConstantTimeCompare([]byte(hex.EncodeToString(secretHash[:])), []byte(credentials.SecretHash)) != 1 {
+		log.Printf("invalid secret for device %d", deviceId)
+		return 0, errUnauthorized
+	}
+	return deviceId, nil
+}
+
+// toWeatherEvents validates those readings of that device. Readings with the same time and event type as
+// a previous one of the batch are dropped, since they would overwrite each other, and counted as duplicates.
+func toWeatherEvents(deviceId int64, readings []Reading, now time.Time) ([]weather_generator.WeatherEvent, int, error) {
+	type eventKey struct {
+		unixTime  int64
+		eventType string
+	}
+	seen := map[eventKey]bool{}
+	duplicates := 0
+
+	weatherEvents := make([]weather_generator.WeatherEvent, 0, len(readings))
+	for i, reading := range readings {
+		if err := validateReading(deviceId, reading, now); err != nil {
+			return nil, 0, fmt.Errorf("invalid reading %d: %w", i, err)
+		}
+		key := eventKey{reading.Time.Unix(), reading.EventType}
+		if seen[key] {
+			duplicates++
+			continue
+		}
+		seen[key] = true
+
+		weatherEvents = append(weatherEvents, weather_generator.WeatherEvent{
+			DeviceId:  deviceId,
+			Time:      reading.Time,
+			EventType: reading.EventType,
+			Value:     *reading.Value,
+			Unit:      weather_generator.UnitOf(reading.EventType),
+		})
+	}
+	return weatherEvents, duplicates, nil
+}
+
+func validateReading(deviceId int64, reading Reading, now time.Time) error {
+	if reading.DeviceId != 0 && reading.DeviceId != deviceId {
+		return fmt.Errorf("reading of device %d, instead of the authenticated device %d", reading.DeviceId, deviceId)
+	}
+	eventType, ok := weather_generator.LookupEventType(reading.EventType)
+	if !ok {
+		return fmt.Errorf("unknown EventType %q", reading.EventType)
+	}
+	if reading.Unit != "" && reading.Unit != eventType.Unit {
+		return fmt.Errorf("invalid Unit: %s should be expressed in %s", reading.EventType, eventType.Unit)
+	}
+	if reading.Value == nil {
+		return errors.New("missing Value")
+	}
+	if math.IsNaN(*reading.Value) || math.IsInf(*reading.Value, 0) {
+		return errors.New("invalid Value: should be a finite number")
+	}
+	if reading.Time.IsZero() {
+		return errors.New("missing Time")
+	}
+	if reading.Time.Before(now.Add(-maxReadingAge)) || reading.Time.After(now.Add(maxClockSkew)) {
+		return fmt.Errorf("invalid Time: should be within the last %v", maxReadingAge)
+	}
+	return nil
+}
+
+// decodeStrictly parses that JSON body, refusing unknown fields
+func decodeStrictly(body string, payload any) error {
+	decoder := json.NewDecoder(strings.NewReader(body))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(payload)
+}
+
+// header looks up an HTTP header, whose case may have been changed along the way
+func header(headers map[string]string, name string) string {
+	for k, v := range headers {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return ""
+}
+
+func jsonResponse(statusCode int, payload any, requestId string) events.APIGatewayProxyResponse {
+	jsonBytes, err := json.Marshal(payload)
+	if err != nil {
+		log.Println(err)
+		return serverSideProblem(requestId)
+	}
+	return events.APIGatewayProxyResponse{
+		Body:       string(jsonBytes),
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}
+}
+
+func main() {
+	lambda.Start(handler)
+}
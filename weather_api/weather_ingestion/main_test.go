@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"weather_data_generator/weather_problem"
+	"weather_data_generator/weather_storage"
+)
+
+// attributeValue is a DynamoDB attribute value in its JSON form, e.g. {"S": "DEVICE_CREDENTIALS"}
+type attributeValue map[string]any
+
+// fakeDynamo is a local stand-in of DynamoDB holding its items in their JSON form, supporting the reads of the
+// credentials and idempotency records, the conditional writes of the latter, and the batch writes of the events
+type fakeDynamo struct {
+	mutex sync.Mutex
+	// items, per "PK SK"
+	items map[string]map[string]attributeValue
+	// number of weather events written by BatchWriteItem
+	eventsWritten int
+}
+
+func (f *fakeDynamo) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+
+	input := struct {
+		Key          map[string]attributeValue
+		Item         map[string]attributeValue
+		RequestItems map[string][]struct {
+			PutRequest struct{ Item map[string]attributeValue }
+		}
+	}{}
+	json.NewDecoder(r.Body).Decode(&input)
+
+	switch r.Header.Get("X-Amz-Target") {
+	case "DynamoDB_20120810.GetItem":
+		item, ok := f.items[itemKey(input.Key)]
+		if !ok {
+			fmt.Fprint(w, `{}`)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"Item": item})
+	case "DynamoDB_20120810.PutItem":
+		// the condition attribute_not_exists(PK) of the idempotency records
+		if _, ok := f.items[itemKey(input.Item)]; ok {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"__type": "com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException", "message": "failed"}`)
+			return
+		}
+		f.items[itemKey(input.Item)] = input.Item
+		fmt.Fprint(w, `{}`)
+	case "DynamoDB_20120810.BatchWriteItem":
+		for _, requests := range input.RequestItems {
+			for _, request := range requests {
+				f.items[itemKey(request.PutRequest.Item)] = request.PutRequest.Item
+				f.eventsWritten++
+			}
+		}
+		fmt.Fprint(w, `{"UnprocessedItems": {}}`)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"__type": "com.amazon.coral.validate#ValidationException", "message": "unsupported %s"}`, r.Header.Get("X-Amz-Target"))
+	}
+}
+
+func itemKey(item map[string]attributeValue) string {
+	return fmt.Sprintf("%s %s", item["PK"]["S"], item["SK"]["S"])
+}
+
+// withFakeDynamo points the lambda to a local stand-in of DynamoDB, in which device 1001 has the secret "secret"
+func withFakeDynamo(t *testing.T) *fakeDynamo {
+	secretHash := sha256.Sum256([]byte("secret"))
+	fake := &fakeDynamo{items: map[string]map[string]attributeValue{
+		DEVICE_CREDENTIALS_PK + " DeviceId#1001": {
+			"PK":         {"S": DEVICE_CREDENTIALS_PK},
+			"SK":         {"S": "DeviceId#1001"},
+			"DeviceId":   {"N": "1001"},
+			"SecretHash": {"S": hex.EncodeToString(secretHash[:])},
+		},
+	}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	dynamoClient = dynamodb.New(dynamodb.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "test", SecretAccessKey: "test"}, nil
+		}),
+		RetryMaxAttempts: 1,
+	})
+	dynamoTable = aws.String("weather")
+	writer = weather_storage.NewWriter(dynamoClient, *dynamoTable, 0)
+	return fake
+}
+
+func ingestionRequest(resource string, body string, extraHeaders map[string]string) events.APIGatewayProxyRequest {
+	headers := map[string]string{"x-device-id": "1001", "x-device-secret": "secret"}
+	for k, v := range extraHeaders {
+		headers[k] = v
+	}
+	return events.APIGatewayProxyRequest{
+		Resource:       resource,
+		HTTPMethod:     "POST",
+		Headers:        headers,
+		Body:           body,
+		RequestContext: events.APIGatewayProxyRequestContext{RequestID: "request-1"},
+	}
+}
+
+// assertProblem checks that the response is a problem document of that status and type
+func assertProblem(t *testing.T, response events.APIGatewayProxyResponse, status int, problemType string) {
+	t.Helper()
+	if response.StatusCode != status {
+		t.Errorf("status %d, expected %d: %s", response.StatusCode, status, response.Body)
+	}
+	if contentType := response.Headers["Content-Type"]; contentType != weather_problem.ContentType {
+		t.Errorf("Content-Type %q, expected %q", contentType, weather_problem.ContentType)
+	}
+	problem := weather_problem.Problem{}
+	if err := json.Unmarshal([]byte(response.Body), &problem); err != nil {
+		t.Fatalf("invalid problem %s: %v", response.Body, err)
+	}
+	if problem.Type != problemType || problem.Status != status || problem.RequestId != "request-1" {
+		t.Errorf("problem %+v, expected type %s, status %d and the request id", problem, problemType, status)
+	}
+}
+
+func TestValidateReading(t *testing.T) {
+	now := time.Date(2024, 3, 8, 12, 0, 0, 0, time.UTC)
+	value := func(v float64) *float64 { return &v }
+
+	tests := []struct {
+		name    string
+		reading Reading
+		valid   bool
+	}{
+		{"valid", Reading{Time: now.Add(-time.Hour), EventType: "Temperature", Value: value(21.5)}, true},
+		{"same device id", Reading{DeviceId: 1001, Time: now, EventType: "Temperature", Value: value(21.5)}, true},
+		{"metric unit", Reading{Time: now, EventType: "Temperature", Value: value(21.5), Unit: "°C"}, true},
+		{"zero value", Reading{Time: now, EventType: "Precipitation", Value: value(0)}, true},
+		{"oldest accepted", Reading{Time: now.Add(-maxReadingAge), EventType: "Temperature", Value: value(21.5)}, true},
+		{"within clock skew", Reading{Time: now.Add(maxClockSkew), EventType: "Temperature", Value: value(21.5)}, true},
+		{"other device id", Reading{DeviceId: 1002, Time: now, EventType: "Temperature", Value: value(21.5)}, false},
+		{"unknown event type", Reading{Time: now, EventType: "Snow", Value: value(2)}, false},
+		{"event type case", Reading{Time: now, EventType: "temperature", Value: value(21.5)}, false},
+		{"missing event type", Reading{Time: now, Value: value(21.5)}, false},
+		{"other unit", Reading{Time: now, EventType: "Temperature", Value: value(70.7), Unit: "°F"}, false},
+		{"missing value", Reading{Time: now, EventType: "Temperature"}, false},
+		{"NaN value", Reading{Time: now, EventType: "Temperature", Value: value(math.NaN())}, false},
+		{"infinite value", Reading{Time: now, EventType: "Temperature", Value: value(math.Inf(1))}, false},
+		{"missing time", Reading{EventType: "Temperature", Value: value(21.5)}, false},
+		{"too old", Reading{Time: now.Add(-maxReadingAge - time.Second), EventType: "Temperature", Value: value(21.5)}, false},
+		{"in the future", Reading{Time: now.Add(maxClockSkew + time.Second), EventType: "Temperature", Value: value(21.5)}, false},
+	}
+	for _, test := range tests {
+		if err := validateReading(1001, test.reading, now); (err == nil) != test.valid {
+			t.Errorf("%s: error %v, expected valid %v", test.name, err, test.valid)
+		}
+	}
+}
+
+func TestToWeatherEventsDropsDuplicates(t *testing.T) {
+	now := time.Date(2024, 3, 8, 12, 0, 0, 0, time.UTC)
+	value := func(v float64) *float64 { return &v }
+	readings := []Reading{
+		{Time: now, EventType: "Temperature", Value: value(21.5)},
+		{Time: now, EventType: "Humidity", Value: value(64)},
+		// same second as the first one
+		{Time: now.Add(300 * time.Millisecond), EventType: "Temperature", Value: value(22)},
+		{Time: now.Add(time.Second), EventType: "Temperature", Value: value(22)},
+		{DeviceId: 1001, Time: now, EventType: "Humidity", Value: value(65)},
+	}
+
+	weatherEvents, duplicates, err := toWeatherEvents(1001, readings, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if duplicates != 2 {
+		t.Errorf("%d duplicates, expected 2", duplicates)
+	}
+	if len(weatherEvents) != 3 {
+		t.Fatalf("events %+v, expected 3", weatherEvents)
+	}
+	// the first reading of each key is kept
+	if first := weatherEvents[0]; first.DeviceId != 1001 || first.Value != 21.5 || first.Unit != "°C" {
+		t.Errorf("first event %+v, expected 21.5 °C of device 1001", first)
+	}
+	if second := weatherEvents[1]; second.Value != 64 || second.Unit != "%" {
+		t.Errorf("second event %+v, expected 64 %%", second)
+	}
+}
+
+func TestToWeatherEventsRefusesInvalidBatch(t *testing.T) {
+	now := time.Date(2024, 3, 8, 12, 0, 0, 0, time.UTC)
+	value := 21.5
+	readings := []Reading{
+		{Time: now, EventType: "Temperature", Value: &value},
+		{Time: now, EventType: "Temperature"},
+	}
+
+	weatherEvents, _, err := toWeatherEvents(1001, readings, now)
+	if err == nil || !strings.Contains(err.Error(), "invalid reading 1: missing Value") {
+		t.Errorf("events %+v and error %v, expected reading 1 to be refused", weatherEvents, err)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	fake := withFakeDynamo(t)
+	readingTime := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	reading := fmt.Sprintf(`{"Time": %q, "EventType": "Temperature", "Value": 21.5}`, readingTime)
+	batch := fmt.Sprintf(`{"Readings": [%s, {"Time": %q, "EventType": "Humidity", "Value": 64}, %s]}`, reading, readingTime, reading)
+
+	response, err := handler(context.Background(), ingestionRequest("/weather", reading, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != 202 || response.Body != `{"Accepted":1,"Duplicates":0}` {
+		t.Errorf("response %d %s, expected one accepted reading", response.StatusCode, response.Body)
+	}
+
+	response, _ = handler(context.Background(), ingestionRequest("/weather/batch", batch, nil))
+	if response.StatusCode != 202 || response.Body != `{"Accepted":2,"Duplicates":1}` {
+		t.Errorf("response %d %s, expected two accepted readings and one duplicate", response.StatusCode, response.Body)
+	}
+	if fake.eventsWritten != 3 {
+		t.Errorf("%d events written, expected 3", fake.eventsWritten)
+	}
+	if _, ok := fake.items["DeviceId#1001 Time#"+fmt.Sprint(mustParseTime(t, readingTime).Unix())+"#TypeHumidity"]; !ok {
+		t.Errorf("humidity event not stored in %v", fake.items)
+	}
+}
+
+func TestHandlerProblems(t *testing.T) {
+	fake := withFakeDynamo(t)
+	readingTime := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	reading := fmt.Sprintf(`{"Time": %q, "EventType": "Temperature", "Value": 21.5}`, readingTime)
+
+	tests := []struct {
+		name        string
+		request     events.APIGatewayProxyRequest
+		status      int
+		problemType string
+	}{
+		{"wrong secret", ingestionRequest("/weather", reading, map[string]string{"x-device-secret": "guess"}), 401, unauthorizedProblemType},
+		{"unknown device", ingestionRequest("/weather", reading, map[string]string{"x-device-id": "1002"}), 401, unauthorizedProblemType},
+		{"invalid json", ingestionRequest("/weather", `{"Time": `, nil), 400, invalidReadingProblemType},
+		{"unknown field", ingestionRequest("/weather", `{"Temperature": 21.5}`, nil), 400, invalidReadingProblemType},
+		{"invalid reading", ingestionRequest("/weather", fmt.Sprintf(`{"Time": %q, "EventType": "Snow", "Value": 2}`, readingTime), nil), 400, invalidReadingProblemType},
+		{"empty batch", ingestionRequest("/weather/batch", `{"Readings": []}`, nil), 400, invalidReadingProblemType},
+		{"batch with an invalid reading", ingestionRequest("/weather/batch", fmt.Sprintf(`{"Readings": [%s, {"Time": %q, "EventType": "Temperature"}]}`, reading, readingTime), nil), 400, invalidReadingProblemType},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response, err := handler(context.Background(), test.request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertProblem(t, response, test.status, test.problemType)
+		})
+	}
+	if fake.eventsWritten != 0 {
+		t.Errorf("%d events written, expected none", fake.eventsWritten)
+	}
+}
+
+func TestHandlerReplaysIdempotentRequests(t *testing.T) {
+	fake := withFakeDynamo(t)
+	readingTime := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	batch := fmt.Sprintf(`{"Readings": [{"Time": %q, "EventType": "Temperature", "Value": 21.5}, {"Time": %q, "EventType": "Humidity", "Value": 64}]}`, readingTime, readingTime)
+	key := map[string]string{"idempotency-key": "attempt-1"}
+
+	first, err := handler(context.Background(), ingestionRequest("/weather/batch", batch, key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.StatusCode != 202 || first.Headers["Idempotent-Replayed"] != "" {
+		t.Fatalf("response %d %v %s, expected the readings to be accepted", first.StatusCode, first.Headers, first.Body)
+	}
+
+	// a retry of the same request under the same key is not ingested again
+	retry, _ := handler(context.Background(), ingestionRequest("/weather/batch", batch, key))
+	if retry.StatusCode != first.StatusCode || retry.Body != first.Body || retry.Headers["Idempotent-Replayed"] != "true" {
+		t.Errorf("response %d %v %s, expected the replay of %d %s", retry.StatusCode, retry.Headers, retry.Body, first.StatusCode, first.Body)
+	}
+	if fake.eventsWritten != 2 {
+		t.Errorf("%d events written, expected 2", fake.eventsWritten)
+	}
+
+	// the same key for a different request is refused
+	otherBatch := strings.Replace(batch, "21.5", "22", 1)
+	response, _ := handler(context.Background(), ingestionRequest("/weather/batch", otherBatch, key))
+	assertProblem(t, response, 422, idempotencyKeyReusedProblemType)
+	if fake.eventsWritten != 2 {
+		t.Errorf("%d events written, expected 2", fake.eventsWritten)
+	}
+
+	// the keys are per device
+	if _, ok := fake.items[IDEMPOTENCY_KEYS_PK+" DeviceId#1001#Key#attempt-1"]; !ok {
+		t.Errorf("idempotency record not stored in %v", fake.items)
+	}
+
+	// another key is another request
+	response, _ = handler(context.Background(), ingestionRequest("/weather/batch", otherBatch, map[string]string{"idempotency-key": "attempt-2"}))
+	if response.StatusCode != 202 || fake.eventsWritten != 4 {
+		t.Errorf("response %d %s and %d events written, expected the readings to be accepted again", response.StatusCode, response.Body, fake.eventsWritten)
+	}
+}
+
+func TestReplayIgnoresExpiredRecords(t *testing.T) {
+	fake := withFakeDynamo(t)
+	fake.items[IDEMPOTENCY_KEYS_PK+" DeviceId#1001#Key#attempt-1"] = map[string]attributeValue{
+		"PK":          {"S": IDEMPOTENCY_KEYS_PK},
+		"SK":          {"S": "DeviceId#1001#Key#attempt-1"},
+		"RequestHash": {"S": requestHash("other")},
+		"StatusCode":  {"N": "202"},
+		"Body":        {"S": `{"Accepted":1,"Duplicates":0}`},
+		"ExpiresAt":   {"N": fmt.Sprint(time.Now().Add(-time.Minute).Unix())},
+	}
+
+	_, found, err := findIdempotentResponse(context.Background(), 1001, "attempt-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found {
+		t.Error("expired idempotency record found")
+	}
+}
+
+func mustParseTime(t *testing.T, value string) time.Time {
+	t.Helper()
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
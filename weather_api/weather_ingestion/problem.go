@@ -0,0 +1,56 @@
+// Error responses, as RFC 7807 problem documents, like those of GET /weather.
+package main
+
+import (
+	"github.com/aws/aws-lambda-go/events"
+
+	"weather_data_generator/weather_problem"
+)
+
+// problem types, relative to the API URL
+const (
+	invalidReadingProblemType       = "/problems/invalid-reading"
+	unauthorizedProblemType         = "/problems/unauthorized"
+	idempotencyKeyReusedProblemType = "/problems/idempotency-key-reused"
+	storageFailureProblemType       = "/problems/storage-failure"
+)
+
+func invalidReadingProblem(detail string, requestId string) events.APIGatewayProxyResponse {
+	return weather_problem.Response(weather_problem.Problem{
+		Type:      invalidReadingProblemType,
+		Title:     "Invalid reading",
+		Status:    400,
+		Detail:    detail,
+		RequestId: requestId,
+	})
+}
+
+func unauthorizedProblem(requestId string) events.APIGatewayProxyResponse {
+	return weather_problem.Response(weather_problem.Problem{
+		Type:      unauthorizedProblemType,
+		Title:     "Unauthorized",
+		Status:    401,
+		Detail:    errUnauthorized.Error(),
+		RequestId: requestId,
+	})
+}
+
+func idempotencyKeyReusedProblem(requestId string) events.APIGatewayProxyResponse {
+	return weather_problem.Response(weather_problem.Problem{
+		Type:      idempotencyKeyReusedProblemType,
+		Title:     "Idempotency-Key reused",
+		Status:    422,
+		Detail:    "Idempotency-Key already used for a different request",
+		RequestId: requestId,
+	})
+}
+
+func serverSideProblem(requestId string) events.APIGatewayProxyResponse {
+	return weather_problem.Response(weather_problem.Problem{
+		Type:      storageFailureProblemType,
+		Title:     "Failed to store readings",
+		Status:    500,
+		Detail:    "failed to store the readings",
+		RequestId: requestId,
+	})
+}
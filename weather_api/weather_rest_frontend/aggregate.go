@@ -0,0 +1,234 @@
+// Aggregation of the weather events per time bucket, read from the hourly/daily rollups
+// maintained by weather_rollups when possible, and computed from the raw events otherwise.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"weather_data_generator/weather_generator"
+)
+
+const minBucket = time.Minute
+const maxBucket = 31 * 24 * time.Hour
+
+// granularities of the rollups maintained by weather_rollups, per bucket size
+var rollupGranularities = map[time.Duration]string{
+	time.Hour:      "Hour",
+	24 * time.Hour: "Day",
+}
+
+// Aggregate summarizes the events of one type within one time bucket
+type Aggregate struct {
+	DeviceId  int64
+	EventType string
+	// start of the bucket
+	Start time.Time
+	Count int64
+	Min   float64
+	Max   float64
+	Avg   float64
+	// value of the most recent event of the bucket
+	Last float64
+	Unit string
+}
+
+// Rollup is an hourly or daily aggregate, as stored by weather_rollups
+type Rollup struct {
+	DeviceId    int64
+	EventType   string
+	Granularity string
+	Start       int64
+	Count       int64
+	Sum         float64
+	Min         float64
+	Max         float64
+	Last        float64
+	LastTime    int64
+}
+
+// parseBucket parses the optional bucket param, as a Go duration (e.g. 15m, 1h) or a number of days (e.g. 1d).
+// It returns 0 when no aggregation is requested.
+func parseBucket(params map[string]string) (time.Duration, *ParamError) {
+	bucketStr, ok := params["bucket"]
+	if !ok || bucketStr == "" {
+		return 0, nil
+	}
+
+	bucket, err := parseDuration(bucketStr)
+	if err != nil || bucket < minBucket || bucket > maxBucket {
+		return 0, &ParamError{Param: "bucket", Detail: fmt.Sprintf("should be a duration between %v and %v, e.g. 15m, 1h or 1d", minBucket, maxBucket)}
+	}
+	return bucket, nil
+}
+
+// queryAggregates returns the aggregates of the requested events, per bucket and event type
+func queryAggregates(inputParams InputParams) ([]Aggregate, error) {
+	aggregates := []Aggregate{}
+	if granularity, ok := alignedGranularity(inputParams); ok {
+		rollups, err := queryRollups(inputParams, granularity)
+		if err != nil {
+			return nil, err
+		}
+		for _, rollup := range rollups {
+			aggregates = append(aggregates, rollup.toAggregate())
+		}
+	} else {
+		weatherEvents, err := queryDb(InputParams{
+			DeviceId:   inputParams.DeviceId,
+			FromTime:   inputParams.FromTime,
+			ToTime:     inputParams.ToTime,
+			EventTypes: inputParams.EventTypes,
+			UnitSystem: defaultUnitSystem,
+		})
+		if err != nil {
+			return nil, err
+		}
+		aggregates = aggregateEvents(weatherEvents, inputParams.Bucket)
+	}
+
+	for i := range aggregates {
+		aggregates[i] = convertAggregateUnits(aggregates[i], inputParams.UnitSystem)
+	}
+	return aggregates, nil
+}
+
+// alignedGranularity tells whether the requested buckets match a rollup granularity,
+// i.e. whether the rollups cover exactly the requested period.
+func alignedGranularity(inputParams InputParams) (string, bool) {
+	granularity, ok := rollupGranularities[inputParams.Bucket]
+	if !ok {
+		return "", false
+	}
+	from, to := inputParams.FromTime.UTC(), inputParams.ToTime.UTC()
+	return granularity, from.Truncate(inputParams.Bucket).Equal(from) && to.Truncate(inputParams.Bucket).Equal(to)
+}
+
+// queryRollups returns the rollups of the buckets starting within [from, to[
+func queryRollups(inputParams InputParams, granularity string) ([]Rollup, error) {
+	builder := expression.NewBuilder().
+		WithKeyCondition(
+			expression.KeyAnd(
+				expression.Key("PK").Equal(expression.Value(fmt.Sprintf("Rollup#DeviceId#%d", inputParams.DeviceId))),
+				expression.Key("SK").Between(
+					expression.Value(fmt.Sprintf("%s#%d", granularity, inputParams.FromTime.Unix())),
+					expression.Value(fmt.Sprintf("%s#%d", granularity, inputParams.ToTime.Unix()-1)),
+				),
+			),
+		)
+	if len(inputParams.EventTypes) > 0 {
+		builder = builder.WithFilter(eventTypeFilter(inputParams.EventTypes))
+	}
+	expr, err := builder.Build()
+	if err != nil {
+		return nil, fmt.Errorf("error while building DynamoDB query: %w", err)
+	}
+
+	rollups := []Rollup{}
+	paginator := dynamodb.NewQueryPaginator(dynamoClient, &dynamodb.QueryInput{
+		TableName:                 dynamoTable,
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+	for paginator.HasMorePages() {
+		queryResult, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, fmt.Errorf("error while querying DynamodDB: %w", err)
+		}
+		for _, rawRollup := range queryResult.Items {
+			rollup := Rollup{}
+			if err := attributevalue.UnmarshalMap(rawRollup, &rollup); err != nil {
+				log.Printf("failed to parse rollup %v, skipping %v", rawRollup, err)
+				continue
+			}
+			rollups = append(rollups, rollup)
+		}
+	}
+	log.Printf("read %d %s rollups", len(rollups), granularity)
+	return rollups, nil
+}
+
+func (r Rollup) toAggregate() Aggregate {
+	return Aggregate{
+		DeviceId:  r.DeviceId,
+		EventType: r.EventType,
+		Start:     time.Unix(r.Start, 0).UTC(),
+		Count:     r.Count,
+		Min:       r.Min,
+		Max:       r.Max,
+		Avg:       r.Sum / float64(max(r.Count, 1)),
+		Last:      r.Last,
+		Unit:      weather_generator.UnitOf(r.EventType),
+	}
+}
+
+// aggregateEvents computes the aggregates of those events, sorted by bucket and event type
+func aggregateEvents(weatherEvents []WeatherEvent, bucket time.Duration) []Aggregate {
+	type aggregateKey struct {
+		start     time.Time
+		eventType string
+	}
+	byKey := map[aggregateKey]*Aggregate{}
+	lastTimes := map[aggregateKey]time.Time{}
+
+	for _, event := range weatherEvents {
+		key := aggregateKey{start: event.Time.UTC().Truncate(bucket), eventType: event.EventType}
+		aggregate, ok := byKey[key]
+		if !ok {
+			aggregate = &Aggregate{
+				DeviceId:  event.DeviceId,
+				EventType: event.EventType,
+				Start:     key.start,
+				Min:       event.Value,
+				Max:       event.Value,
+				Unit:      event.Unit,
+			}
+			byKey[key] = aggregate
+		}
+		aggregate.Count++
+		aggregate.Avg += event.Value
+		aggregate.Min = min(aggregate.Min, event.Value)
+		aggregate.Max = max(aggregate.Max, event.Value)
+		if !event.Time.Before(lastTimes[key]) {
+			aggregate.Last = event.Value
+			lastTimes[key] = event.Time
+		}
+	}
+
+	aggregates := make([]Aggregate, 0, len(byKey))
+	for _, aggregate := range byKey {
+		aggregate.Avg /= float64(aggregate.Count)
+		aggregates = append(aggregates, *aggregate)
+	}
+	sort.Slice(aggregates, func(i, j int) bool {
+		if !aggregates[i].Start.Equal(aggregates[j].Start) {
+			return aggregates[i].Start.Before(aggregates[j].Start)
+		}
+		return aggregates[i].EventType < aggregates[j].EventType
+	})
+	return aggregates
+}
+
+// convertAggregateUnits expresses the values of that aggregate in the given unit system
+func convertAggregateUnits(aggregate Aggregate, unitSystem string) Aggregate {
+	if aggregate.Unit == "" {
+		aggregate.Unit = weather_generator.UnitOf(aggregate.EventType)
+	}
+	if conversion, ok := conversions[unitSystem][aggregate.EventType]; ok && aggregate.Unit == weather_generator.UnitOf(aggregate.EventType) {
+		aggregate.Min = conversion.convert(aggregate.Min)
+		aggregate.Max = conversion.convert(aggregate.Max)
+		aggregate.Avg = conversion.convert(aggregate.Avg)
+		aggregate.Last = conversion.convert(aggregate.Last)
+		aggregate.Unit = conversion.unit
+	}
+	return aggregate
+}
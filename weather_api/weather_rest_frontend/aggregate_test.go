@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAggregateEvents(t *testing.T) {
+	start := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	event := func(minutes int, eventType string, value float64) WeatherEvent {
+		return WeatherEvent{DeviceId: 1001, Time: start.Add(time.Duration(minutes) * time.Minute), EventType: eventType, Value: value, Unit: "°C"}
+	}
+	weatherEvents := []WeatherEvent{
+		// out of time order, Last being the value of the most recent one
+		event(7, "Temperature", 12),
+		event(2, "Temperature", 15),
+		event(14, "Temperature", 9),
+		event(3, "Humidity", 60),
+		event(16, "Temperature", 11),
+		event(29, "Temperature", 13),
+	}
+
+	aggregates := aggregateEvents(weatherEvents, 15*time.Minute)
+	expected := []Aggregate{
+		{DeviceId: 1001, EventType: "Humidity", Start: start, Count: 1, Min: 60, Max: 60, Avg: 60, Last: 60, Unit: "°C"},
+		{DeviceId: 1001, EventType: "Temperature", Start: start, Count: 3, Min: 9, Max: 15, Avg: 12, Last: 9, Unit: "°C"},
+		{DeviceId: 1001, EventType: "Temperature", Start: start.Add(15 * time.Minute), Count: 2, Min: 11, Max: 13, Avg: 12, Last: 13, Unit: "°C"},
+	}
+	if len(aggregates) != len(expected) {
+		t.Fatalf("aggregates %+v, expected %+v", aggregates, expected)
+	}
+	for i := range expected {
+		if aggregates[i] != expected[i] {
+			t.Errorf("aggregate %d is %+v, expected %+v", i, aggregates[i], expected[i])
+		}
+	}
+
+	if aggregates := aggregateEvents(nil, time.Hour); aggregates == nil || len(aggregates) != 0 {
+		t.Errorf("aggregates %v of no events, expected an empty list", aggregates)
+	}
+}
+
+func TestAlignedGranularity(t *testing.T) {
+	hour := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	paris := time.FixedZone("CET", 3600)
+
+	tests := []struct {
+		name        string
+		from, to    time.Time
+		bucket      time.Duration
+		granularity string
+		aligned     bool
+	}{
+		{"whole hours", hour, hour.Add(3 * time.Hour), time.Hour, "Hour", true},
+		{"whole days", day, day.Add(7 * 24 * time.Hour), 24 * time.Hour, "Day", true},
+		{"whole hours in another time zone", hour.In(paris), hour.Add(time.Hour).In(paris), time.Hour, "Hour", true},
+		{"days starting at midnight in another time zone", day.Add(-time.Hour).In(paris), day.Add(23 * time.Hour).In(paris), 24 * time.Hour, "", false},
+		{"unaligned start", hour.Add(time.Minute), hour.Add(2 * time.Hour), time.Hour, "", false},
+		{"unaligned end", hour, hour.Add(90 * time.Minute), time.Hour, "", false},
+		{"no rollup of that size", hour, hour.Add(time.Hour), 15 * time.Minute, "", false},
+		{"no rollup of several hours", day, day.Add(24 * time.Hour), 6 * time.Hour, "", false},
+	}
+	for _, test := range tests {
+		granularity, aligned := alignedGranularity(InputParams{FromTime: test.from, ToTime: test.to, Bucket: test.bucket})
+		if aligned != test.aligned || (aligned && granularity != test.granularity) {
+			t.Errorf("%s: %q %v, expected %q %v", test.name, granularity, aligned, test.granularity, test.aligned)
+		}
+	}
+}
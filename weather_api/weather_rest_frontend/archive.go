@@ -0,0 +1,131 @@
+// Reading of the weather events expired from DynamoDB, from the archive files written by weather_archiver.
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// root of the archive files in the bucket, as written by weather_archiver
+const archivePrefix = "weather-events"
+
+// archiveHorizon is the time before which events may have been moved from DynamoDB to the archive.
+// It returns false if events never expire.
+func archiveHorizon(now time.Time) (time.Time, bool) {
+	if retention == 0 || archiveBucket == "" {
+		return time.Time{}, false
+	}
+	return now.Add(-retention), true
+}
+
+// queryArchive reads the archived events of the requested device and types within [from, to], oldest first
+func queryArchive(ctx context.Context, inputParams InputParams, from, to time.Time) ([]WeatherEvent, error) {
+	archivedEvents := []WeatherEvent{}
+	for day := from.UTC().Truncate(24 * time.Hour); !day.After(to); day = day.Add(24 * time.Hour) {
+		prefix := fmt.Sprintf("%s/device_id=%d/date=%s/", archivePrefix, inputParams.DeviceId, day.Format(time.DateOnly))
+
+		paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+			Bucket: aws.String(archiveBucket),
+			Prefix: aws.String(prefix),
+		})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("error while listing s3://%s/%s: %w", archiveBucket, prefix, err)
+			}
+			for _, object := range page.Contents {
+				fileEvents, err := readArchiveFile(ctx, *object.Key)
+				if err != nil {
+					return nil, err
+				}
+				for _, event := range fileEvents {
+					if event.Time.Before(from) || event.Time.After(to) {
+						continue
+					}
+					if len(inputParams.EventTypes) > 0 && !slices.Contains(inputParams.EventTypes, event.EventType) {
+						continue
+					}
+					event.Archived = true
+					archivedEvents = append(archivedEvents, event)
+				}
+			}
+		}
+	}
+	log.Printf("read %d archived events between %v and %v", len(archivedEvents), from, to)
+	return archivedEvents, nil
+}
+
+// readArchiveFile reads all the events of one NDJSON archive file, gzipped or not
+func readArchiveFile(ctx context.Context, key string) ([]WeatherEvent, error) {
+	object, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(archiveBucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error while reading s3://%s/%s: %w", archiveBucket, key, err)
+	}
+	defer object.Body.Close()
+
+	// the HTTP transport may or may not have decompressed it already, depending on the Content-Encoding
+	var content io.Reader = bufio.NewReader(object.Body)
+	if magic, _ := content.(*bufio.Reader).Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		unzipper, err := gzip.NewReader(content)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress s3://%s/%s: %w", archiveBucket, key, err)
+		}
+		defer unzipper.Close()
+		content = unzipper
+	}
+
+	fileEvents := []WeatherEvent{}
+	decoder := json.NewDecoder(content)
+	for decoder.More() {
+		event := WeatherEvent{}
+		if err := decoder.Decode(&event); err != nil {
+			return nil, fmt.Errorf("failed to parse s3://%s/%s: %w", archiveBucket, key, err)
+		}
+		fileEvents = append(fileEvents, event)
+	}
+	return fileEvents, nil
+}
+
+// mergeEvents combines the events from DynamoDB and from the archive in time order. Events present in both
+// (i.e. archived but not yet removed from DynamoDB), or in several archive files (the Kinesis data stream read by the
+// archiver may repeat a record), are only returned once.
+func mergeEvents(dbEvents, archivedEvents []WeatherEvent) []WeatherEvent {
+	type eventKey struct {
+		unixTime  int64
+		eventType string
+	}
+	seen := make(map[eventKey]bool, len(dbEvents))
+	for _, event := range dbEvents {
+		seen[eventKey{event.Time.Unix(), event.EventType}] = true
+	}
+
+	merged := slices.Clone(dbEvents)
+	for _, event := range archivedEvents {
+		key := eventKey{event.Time.Unix(), event.EventType}
+		if !seen[key] {
+			seen[key] = true
+			merged = append(merged, event)
+		}
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		if !merged[i].Time.Equal(merged[j].Time) {
+			return merged[i].Time.Before(merged[j].Time)
+		}
+		return merged[i].EventType < merged[j].EventType
+	})
+	return merged
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMergeEventsReturnsEachEventOnce(t *testing.T) {
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	event := func(minutes int, eventType string, archived bool) WeatherEvent {
+		return WeatherEvent{DeviceId: 1001, Time: start.Add(time.Duration(minutes) * time.Minute), EventType: eventType, Archived: archived}
+	}
+	dbEvents := []WeatherEvent{event(2, "Temperature", false), event(3, "Temperature", false)}
+	archivedEvents := []WeatherEvent{
+		event(1, "Temperature", true),
+		// archived but not removed from DynamoDB yet
+		event(2, "Temperature", true),
+		// in two archive files
+		event(1, "Humidity", true),
+		event(1, "Humidity", true),
+	}
+
+	merged := mergeEvents(dbEvents, archivedEvents)
+	expected := []WeatherEvent{event(1, "Humidity", true), event(1, "Temperature", true), event(2, "Temperature", false), event(3, "Temperature", false)}
+	if len(merged) != len(expected) {
+		t.Fatalf("merged %v, expected %v", merged, expected)
+	}
+	for i := range expected {
+		if merged[i] != expected[i] {
+			t.Errorf("event %d is %+v, expected %+v", i, merged[i], expected[i])
+		}
+	}
+}
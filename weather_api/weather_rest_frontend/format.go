@@ -0,0 +1,199 @@
+// Encoding of the responses in the format negotiated with the client: JSON, CSV, NDJSON or Parquet.
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+const defaultFormat = "json"
+
+// content type of each supported format
+var formatContentTypes = map[string]string{
+	"json":    "application/json",
+	"csv":     "text/csv",
+	"ndjson":  "application/x-ndjson",
+	"parquet": "application/vnd.apache.parquet",
+}
+
+// supported formats, by order of preference when the Accept header ranks several of them equally, e.g. with */*
+var formatPreference = []string{defaultFormat, "csv", "ndjson", "parquet"}
+
+// formats whose responses are base64 encoded, as expected by the API Gateway for binary media types
+var binaryFormats = map[string]bool{
+	"parquet": true,
+}
+
+// record is a row of the CSV responses
+type record interface {
+	csvHeader() []string
+	csvRow() []string
+}
+
+// parseFormat selects the format of the response from the optional format param, or else from the Accept header.
+// It returns a ParamError when the requested format is not supported, and false when no format of the Accept header is.
+func parseFormat(params map[string]string, accept string) (string, bool, *ParamError) {
+	if format, ok := params["format"]; ok && format != "" {
+		if _, supported := formatContentTypes[format]; !supported {
+			return "", true, &ParamError{Param: "format", Detail: "should be one of json, csv, ndjson or parquet"}
+		}
+		return format, true, nil
+	}
+	if accept == "" {
+		return defaultFormat, true, nil
+	}
+
+	format, ok := negotiateFormat(accept)
+	return format, ok, nil
+}
+
+// negotiateFormat returns the supported format of highest quality among the media ranges of that Accept header
+// e.g. "text/csv;q=0.9, application/x-ndjson" selects ndjson. The quality of a format is the one of the most specific
+// range matching it, such that "application/json;q=0, */*" excludes JSON. Equal qualities are ordered by the position
+// of their range in the header, then by formatPreference.
+func negotiateFormat(accept string) (string, bool) {
+	type match struct {
+		specificity int
+		quality     float64
+		position    int
+	}
+	matches := map[string]match{}
+	for position, part := range strings.Split(accept, ",") {
+		mediaType, paramStr, _ := strings.Cut(strings.TrimSpace(part), ";")
+		quality := 1.0
+		for _, param := range strings.Split(paramStr, ";") {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(strings.TrimSpace(name), "q") {
+				if parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+					quality = parsed
+				}
+			}
+		}
+		for _, format := range formatPreference {
+			specificity := rangeSpecificity(strings.ToLower(strings.TrimSpace(mediaType)), formatContentTypes[format])
+			if current, found := matches[format]; specificity >= 0 && (!found || specificity > current.specificity) {
+				matches[format] = match{specificity: specificity, quality: quality, position: position}
+			}
+		}
+	}
+
+	best, found := "", false
+	for _, format := range formatPreference {
+		candidate, matched := matches[format]
+		if !matched || candidate.quality <= 0 {
+			continue
+		}
+		if selected := matches[best]; !found || candidate.quality > selected.quality ||
+			(candidate.quality == selected.quality && candidate.position < selected.position) {
+			best, found = format, true
+		}
+	}
+	return best, found
+}
+
+// rangeSpecificity returns how specifically that media range matches that content type: 2 for the content type itself,
+// 1 for its type wildcard (e.g. text/*), 0 for */*, and -1 when it does not match
+func rangeSpecificity(mediaRange string, contentType string) int {
+	switch {
+	case mediaRange == contentType:
+		return 2
+	case mediaRange == "*/*":
+		return 0
+	case strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(contentType, strings.TrimSuffix(mediaRange, "*")):
+		return 1
+	}
+	return -1
+}
+
+// encodeRecords encodes those events or aggregates in that format.
+// It returns the response body, and whether it is base64 encoded.
+func encodeRecords[R record](records []R, format string) (string, bool, error) {
+	var buffer bytes.Buffer
+	switch format {
+	case "csv":
+		writer := csv.NewWriter(&buffer)
+		var zero R
+		writer.Write(zero.csvHeader())
+		for _, record := range records {
+			writer.Write(record.csvRow())
+		}
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			return "", false, fmt.Errorf("failed to write CSV: %w", err)
+		}
+
+	case "ndjson":
+		encoder := json.NewEncoder(&buffer)
+		for _, record := range records {
+			if err := encoder.Encode(record); err != nil {
+				return "", false, fmt.Errorf("failed to write NDJSON: %w", err)
+			}
+		}
+
+	case "parquet":
+		if err := parquet.Write(&buffer, records); err != nil {
+			return "", false, fmt.Errorf("failed to write Parquet: %w", err)
+		}
+		return base64.StdEncoding.EncodeToString(buffer.Bytes()), true, nil
+
+	default:
+		jsonBytes, err := json.Marshal(records)
+		if err != nil {
+			return "", false, err
+		}
+		buffer.Write(jsonBytes)
+	}
+	return buffer.String(), false, nil
+}
+
+func (e WeatherEvent) csvHeader() []string {
+	return []string{"DeviceId", "Time", "EventType", "Value", "Unit", "Fault", "Archived"}
+}
+
+func (e WeatherEvent) csvRow() []string {
+	return []string{
+		strconv.FormatInt(e.DeviceId, 10),
+		e.Time.Format(time.RFC3339),
+		e.EventType,
+		strconv.FormatFloat(e.Value, 'f', -1, 64),
+		e.Unit,
+		e.Fault,
+		strconv.FormatBool(e.Archived),
+	}
+}
+
+func (a Aggregate) csvHeader() []string {
+	return []string{"DeviceId", "EventType", "Start", "Count", "Min", "Max", "Avg", "Last", "Unit"}
+}
+
+func (a Aggregate) csvRow() []string {
+	return []string{
+		strconv.FormatInt(a.DeviceId, 10),
+		a.EventType,
+		a.Start.Format(time.RFC3339),
+		strconv.FormatInt(a.Count, 10),
+		strconv.FormatFloat(a.Min, 'f', -1, 64),
+		strconv.FormatFloat(a.Max, 'f', -1, 64),
+		strconv.FormatFloat(a.Avg, 'f', -1, 64),
+		strconv.FormatFloat(a.Last, 'f', -1, 64),
+		a.Unit,
+	}
+}
+
+// headerValue returns the value of that request header, whose case depends on the client
+func headerValue(headers map[string]string, name string) string {
+	for key, value := range headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
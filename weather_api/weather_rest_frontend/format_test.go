@@ -0,0 +1,83 @@
+package main
+
+import "testing"
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		accept   string
+		expected string
+		valid    bool
+	}{
+		{"application/json", "json", true},
+		{"text/csv", "csv", true},
+		{"application/x-ndjson", "ndjson", true},
+		{"application/vnd.apache.parquet", "parquet", true},
+		{"Text/CSV; charset=utf-8", "csv", true},
+		// quality ordering, then header ordering
+		{"text/csv;q=0.9, application/x-ndjson", "ndjson", true},
+		{"text/csv;q=0.5, application/x-ndjson;q=0.8, application/json;q=0.2", "ndjson", true},
+		{"text/csv, application/x-ndjson", "csv", true},
+		{"application/x-ndjson;q=0.5, text/csv;q=0.5", "ndjson", true},
+		{"text/csv; Q=0.1, application/json", "json", true},
+		{"text/csv;q=invalid, application/json;q=0.9", "csv", true},
+		// q=0 excludes the media type
+		{"text/csv;q=0", "", false},
+		{"text/csv;q=0.0, application/json;q=0.1", "json", true},
+		{"application/json;q=0, */*", "csv", true},
+		{"*/*, application/json;q=0, text/csv;q=0", "ndjson", true},
+		{"text/*;q=0, */*;q=0.1", "json", true},
+		// wildcards, more specific ranges taking precedence
+		{"*/*", "json", true},
+		{"text/*", "csv", true},
+		{"application/*", "json", true},
+		{"*/*;q=0.1, text/csv", "csv", true},
+		{"text/html, */*;q=0.8", "json", true},
+		{"application/*;q=0.5, application/vnd.apache.parquet", "parquet", true},
+		{"application/json;q=0.2, application/*;q=0.9", "ndjson", true},
+		// none is supported
+		{"image/png", "", false},
+		{"text/html, image/*", "", false},
+		{"*/*;q=0", "", false},
+		{"", "", false},
+	}
+	for _, test := range tests {
+		format, ok := negotiateFormat(test.accept)
+		if ok != test.valid || format != test.expected {
+			t.Errorf("%q: format %q (acceptable %v), expected %q (acceptable %v)", test.accept, format, ok, test.expected, test.valid)
+		}
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		format     string
+		accept     string
+		expected   string
+		acceptable bool
+		valid      bool
+	}{
+		{"", "", "json", true, true},
+		{"csv", "", "csv", true, true},
+		// the format param takes precedence over the Accept header
+		{"parquet", "text/csv", "parquet", true, true},
+		{"ndjson", "image/png", "ndjson", true, true},
+		{"", "text/csv", "csv", true, true},
+		{"", "image/png", "", false, true},
+		{"xml", "", "", true, false},
+		{"JSON", "application/json", "", true, false},
+	}
+	for _, test := range tests {
+		params := map[string]string{}
+		if test.format != "" {
+			params["format"] = test.format
+		}
+		format, acceptable, paramErr := parseFormat(params, test.accept)
+		if (paramErr == nil) != test.valid {
+			t.Errorf("%q, %q: error %+v, expected valid %v", test.format, test.accept, paramErr, test.valid)
+			continue
+		}
+		if format != test.expected || acceptable != test.acceptable {
+			t.Errorf("%q, %q: format %q (acceptable %v), expected %q (acceptable %v)", test.format, test.accept, format, acceptable, test.expected, test.acceptable)
+		}
+	}
+}
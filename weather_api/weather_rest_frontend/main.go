@@ -3,12 +3,11 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"errors"
 	"fmt"
 	"log"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-lambda-go/events"
@@ -18,53 +17,103 @@ import (
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"weather_data_generator/weather_generator"
+	"weather_data_generator/weather_storage"
 )
 
 var dynamoClient *dynamodb.Client
 var dynamoTable *string
+var s3Client *s3.Client
+
+// bucket of the events expired from DynamoDB, and how long events stay in DynamoDB before that
+var archiveBucket string
+var retention time.Duration
 
-const iso8601Tormat = "2006-01-02T15:04:05-0700"
+// max duration of the queried period
+var maxQueryWindow time.Duration
 
 func init() {
 	dynamoTable = aws.String(os.Getenv("DYNAMO_TABLE"))
+	archiveBucket = os.Getenv("ARCHIVE_BUCKET")
+
+	var err error
+	if retention, err = weather_storage.ParseRetention(os.Getenv("RETENTION_DAYS")); err != nil {
+		log.Fatal(err)
+	}
+	if maxQueryWindow, err = parseMaxQueryWindow(os.Getenv("MAX_QUERY_DAYS")); err != nil {
+		log.Fatal(err)
+	}
+	if weatherParams, err = loadWeatherParams(openApiSpec); err != nil {
+		log.Fatal(err)
+	}
 
 	awsCfg, err := config.LoadDefaultConfig(context.TODO())
 	if err != nil {
 		log.Fatal(err)
 	}
 	dynamoClient = dynamodb.NewFromConfig(awsCfg)
+	s3Client = s3.NewFromConfig(awsCfg)
 }
 
 func handler(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	inputParams, err := parseParams(request.QueryStringParameters)
-	if err != nil {
-		log.Println(err)
-		return events.APIGatewayProxyResponse{
-			Body:       err.Error(),
-			StatusCode: 400,
-		}, nil
+	if request.Resource == "/openapi.json" {
+		return openApiSpecResponse(), nil
 	}
 
-	weatherEvents, err := queryDb(inputParams)
+	requestId := request.RequestContext.RequestID
+	inputParams, err := parseParams(request.QueryStringParameters, time.Now())
 	if err != nil {
-		log.Println(err)
-		return serverSideError(), nil
+		return invalidParamProblem(err, requestId), nil
+	}
+	accept := headerValue(request.Headers, "Accept")
+	format, acceptable, err := parseFormat(request.QueryStringParameters, accept)
+	if err != nil {
+		return invalidParamProblem(err, requestId), nil
+	}
+	if !acceptable {
+		return notAcceptableProblem(accept, requestId), nil
 	}
-	log.Printf("returning %d events", len(weatherEvents))
 
 	var body string
-	if jsonBytes, err := json.Marshal(weatherEvents); err != nil {
-		log.Println(err)
-		return serverSideError(), nil
-	} else if jsonBytes == nil {
-		body = "{}"
+	var isBase64 bool
+	var encodingErr error
+	headers := map[string]string{"Content-Type": formatContentTypes[format]}
+	if inputParams.Bucket > 0 {
+		aggregates, err := queryAggregates(inputParams)
+		if err != nil {
+			log.Println(err)
+			return serverSideProblem(requestId), nil
+		}
+		log.Printf("returning %d aggregates as %s", len(aggregates), format)
+		body, isBase64, encodingErr = encodeRecords(aggregates, format)
 	} else {
-		body = string(jsonBytes)
+		weatherEvents, err := queryDb(inputParams)
+		if err != nil {
+			log.Println(err)
+			return serverSideProblem(requestId), nil
+		}
+		log.Printf("returning %d events as %s", len(weatherEvents), format)
+		body, isBase64, encodingErr = encodeRecords(weatherEvents, format)
+		for _, event := range weatherEvents {
+			if event.Archived {
+				headers["X-Weather-Archived"] = "true"
+				break
+			}
+		}
+	}
+
+	if encodingErr != nil {
+		log.Println(encodingErr)
+		return serverSideProblem(requestId), nil
 	}
 
 	return events.APIGatewayProxyResponse{
-		Body:       body,
-		StatusCode: 200,
+		Body:            body,
+		StatusCode:      200,
+		Headers:         headers,
+		IsBase64Encoded: isBase64,
 	}, nil
 }
 
@@ -72,6 +121,12 @@ type InputParams struct {
 	DeviceId int64
 	FromTime time.Time
 	ToTime   time.Time
+	// only return events of those types, or all of them if empty
+	EventTypes []string
+	// one of unitSystems
+	UnitSystem string
+	// size of the buckets in which events are aggregated, or 0 to return the events themselves
+	Bucket time.Duration
 }
 
 type WeatherEvent struct {
@@ -79,42 +134,65 @@ type WeatherEvent struct {
 	Time      time.Time
 	EventType string
 	Value     float64
+	Unit      string
+	// name of the fault injected by the data generator, if any
+	Fault string `json:",omitempty"`
+	// whether that event was read from the archive, as opposed to DynamoDB
+	Archived bool `json:",omitempty"`
 }
 
-// parseParams parses a URL encoded query string
-// example input: '?device_id=1&from=2024-02-17T20:13:25+0100&to=2024-02-17T20:13:55+0100'
-func parseParams(params map[string]string) (InputParams, error) {
-	deviceIdStr, ok1 := params["device_id"]
-	fromTimeIso, ok2 := params["from"]
-	toTimeIso, ok3 := params["to"]
-	if !(ok1 && ok2 && ok3) {
-		return InputParams{}, errors.New("missing or invalid query params")
+// parseParams validates a URL encoded query string against the OpenAPI spec and parses it,
+// reporting the first invalid parameter, if any
+// example input: '?device_id=1&from=2024-02-17T20:13:25+01:00&to=now&event_type=Temperature,PM25&units=imperial&bucket=1h'
+func parseParams(params map[string]string, now time.Time) (InputParams, *ParamError) {
+	if paramErr := validateParams(params); paramErr != nil {
+		return InputParams{}, paramErr
 	}
 
-	deviceId, err := strconv.Atoi(deviceIdStr)
+	deviceId, err := strconv.Atoi(params["device_id"])
 	if err != nil {
-		return InputParams{}, errors.New("missing or invalid device_id param")
+		return InputParams{}, &ParamError{Param: "device_id", Detail: "missing or not an integer"}
+	}
+
+	fromTime, toTime, paramErr := parseTimeRange(params, now)
+	if paramErr != nil {
+		return InputParams{}, paramErr
 	}
 
-	fromTime, err1 := time.Parse(iso8601Tormat, fromTimeIso)
-	toTime, err2 := time.Parse(iso8601Tormat, toTimeIso)
+	var selectedEventTypes []string
+	if eventTypesStr, ok := params["event_type"]; ok && eventTypesStr != "" {
+		for _, eventType := range strings.Split(eventTypesStr, ",") {
+			if !weather_generator.IsEventType(eventType) {
+				return InputParams{}, &ParamError{Param: "event_type", Detail: fmt.Sprintf("unknown event type %q", eventType)}
+			}
+			selectedEventTypes = append(selectedEventTypes, eventType)
+		}
+	}
+
+	unitSystem, paramErr := parseUnitSystem(params)
+	if paramErr != nil {
+		return InputParams{}, paramErr
+	}
 
-	if err1 != nil || err2 != nil {
-		log.Println(err1, err2)
-		return InputParams{}, errors.New("missing or invalid query params")
+	bucket, paramErr := parseBucket(params)
+	if paramErr != nil {
+		return InputParams{}, paramErr
 	}
 
 	return InputParams{
-		DeviceId: int64(deviceId),
-		FromTime: fromTime,
-		ToTime:   toTime,
+		DeviceId:   int64(deviceId),
+		FromTime:   fromTime,
+		ToTime:     toTime,
+		EventTypes: selectedEventTypes,
+		UnitSystem: unitSystem,
+		Bucket:     bucket,
 	}, nil
 }
 
 func queryDb(inputParams InputParams) ([]WeatherEvent, error) {
 	log.Printf("will use dynamo table %s and query params %v\n", *dynamoTable, inputParams)
 
-	expr, err := expression.NewBuilder().
+	builder := expression.NewBuilder().
 		WithKeyCondition(
 			expression.KeyAnd(
 				expression.Key("PK").Equal(expression.Value(fmt.Sprintf("DeviceId#%d", inputParams.DeviceId))),
@@ -123,42 +201,68 @@ func queryDb(inputParams InputParams) ([]WeatherEvent, error) {
 					expression.Value(fmt.Sprintf("Time#%d", inputParams.ToTime.Unix()+1)),
 				),
 			),
-		).
-		Build()
+		)
+	if len(inputParams.EventTypes) > 0 {
+		builder = builder.WithFilter(eventTypeFilter(inputParams.EventTypes))
+	}
+	expr, err := builder.Build()
 
 	if err != nil {
 		fmt.Println(err)
 		return nil, fmt.Errorf("error while building DynamoDB query: %w", err)
 	}
 
-	queryResult, err := dynamoClient.Query(
-		context.TODO(),
-		&dynamodb.QueryInput{
-			TableName:                 dynamoTable,
-			KeyConditionExpression:    expr.KeyCondition(),
-			ExpressionAttributeNames:  expr.Names(),
-			ExpressionAttributeValues: expr.Values(),
-		},
-	)
-	if err != nil {
-		return nil, fmt.Errorf("error while querying DynamodDB: %w", err)
+	// a query returns at most 1 MB of items, i.e. less than a day of events of a device: all the pages are read,
+	// such that the response is either complete or an error
+	events := []WeatherEvent{}
+	paginator := dynamodb.NewQueryPaginator(dynamoClient, &dynamodb.QueryInput{
+		TableName:                 dynamoTable,
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+	for paginator.HasMorePages() {
+		queryResult, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, fmt.Errorf("error while querying DynamodDB: %w", err)
+		}
+		for _, rawEvent := range queryResult.Items {
+			event := WeatherEvent{}
+			attributevalue.UnmarshalMap(rawEvent, &event)
+			events = append(events, convertUnits(event, inputParams.UnitSystem))
+		}
 	}
 
-	events := make([]WeatherEvent, 0, len(queryResult.Items))
-	for _, rawEvent := range queryResult.Items {
-		event := WeatherEvent{}
-		attributevalue.UnmarshalMap(rawEvent, &event)
-		events = append(events, event)
+	// the part of the range older than the retention period may have moved to the archive
+	if horizon, ok := archiveHorizon(time.Now()); ok && inputParams.FromTime.Before(horizon) {
+		archivedEvents, err := queryArchive(context.TODO(), inputParams, inputParams.FromTime, minTime(inputParams.ToTime, horizon))
+		if err != nil {
+			return nil, err
+		}
+		for i := range archivedEvents {
+			archivedEvents[i] = convertUnits(archivedEvents[i], inputParams.UnitSystem)
+		}
+		events = mergeEvents(events, archivedEvents)
 	}
 
 	return events, nil
 }
 
-func serverSideError() events.APIGatewayProxyResponse {
-	return events.APIGatewayProxyResponse{
-		Body:       "failed to fetch event from db",
-		StatusCode: 500,
+func minTime(t1, t2 time.Time) time.Time {
+	if t1.Before(t2) {
+		return t1
+	}
+	return t2
+}
+
+// eventTypeFilter only keeps the events of one of those types
+func eventTypeFilter(selectedEventTypes []string) expression.ConditionBuilder {
+	values := make([]expression.OperandBuilder, 0, len(selectedEventTypes))
+	for _, eventType := range selectedEventTypes {
+		values = append(values, expression.Value(eventType))
 	}
+	return expression.Name("EventType").In(values[0], values[1:]...)
 }
 
 func main() {
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"weather_data_generator/weather_generator"
+)
+
+// fakeDynamo is a local stand-in of DynamoDB, answering every Query with its items (regardless of the key
+// condition), in pages of pageSize items
+type fakeDynamo struct {
+	mutex    sync.Mutex
+	items    []map[string]any
+	pageSize int
+	fail     bool
+	// fails the queries following the first failAfter ones, if not 0
+	failAfter int
+	queries   int
+}
+
+func (f *fakeDynamo) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	if r.Header.Get("X-Amz-Target") != "DynamoDB_20120810.Query" {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"__type": "com.amazon.coral.validate#ValidationException", "message": "unsupported %s"}`, r.Header.Get("X-Amz-Target"))
+		return
+	}
+	f.queries++
+	if f.fail || (f.failAfter > 0 && f.queries > f.failAfter) {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, `{"__type": "com.amazonaws.dynamodb.v20120810#InternalServerError", "message": "unavailable"}`)
+		return
+	}
+
+	input := struct {
+		ExclusiveStartKey map[string]map[string]string
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	start := 0
+	if input.ExclusiveStartKey != nil {
+		start, _ = strconv.Atoi(input.ExclusiveStartKey["Offset"]["N"])
+	}
+	end := len(f.items)
+	if f.pageSize > 0 {
+		end = min(start+f.pageSize, len(f.items))
+	}
+	output := map[string]any{"Items": f.items[start:end], "Count": end - start}
+	if end < len(f.items) {
+		output["LastEvaluatedKey"] = map[string]any{"Offset": map[string]string{"N": strconv.Itoa(end)}}
+	}
+	json.NewEncoder(w).Encode(output)
+}
+
+func (f *fakeDynamo) queryCount() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.queries
+}
+
+// withFakeDynamo points the frontend to a local stand-in of DynamoDB
+func withFakeDynamo(t *testing.T, fake *fakeDynamo) {
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	dynamoClient = dynamodb.New(dynamodb.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "test", SecretAccessKey: "test"}, nil
+		}),
+		RetryMaxAttempts: 1,
+	})
+	dynamoTable = aws.String("weather")
+}
+
+// eventItem is a weather event, as stored in DynamoDB
+func eventItem(deviceId int64, eventTime time.Time, eventType string, value float64) map[string]any {
+	return map[string]any{
+		"PK":        map[string]string{"S": fmt.Sprintf("DeviceId#%d", deviceId)},
+		"SK":        map[string]string{"S": fmt.Sprintf("Time#%d#Type%s", eventTime.Unix(), eventType)},
+		"DeviceId":  map[string]string{"N": strconv.FormatInt(deviceId, 10)},
+		"Time":      map[string]string{"N": strconv.FormatInt(eventTime.Unix(), 10)},
+		"EventType": map[string]string{"S": eventType},
+		"Value":     map[string]string{"N": strconv.FormatFloat(value, 'f', -1, 64)},
+		"Unit":      map[string]string{"S": weather_generator.UnitOf(eventType)},
+	}
+}
+
+// rollupItem is an aggregate, as stored in DynamoDB by weather_rollups
+func rollupItem(deviceId int64, granularity string, start time.Time, eventType string, count int64, sum, min, max float64) map[string]any {
+	return map[string]any{
+		"PK":          map[string]string{"S": fmt.Sprintf("Rollup#DeviceId#%d", deviceId)},
+		"SK":          map[string]string{"S": fmt.Sprintf("%s#%d#Type%s", granularity, start.Unix(), eventType)},
+		"DeviceId":    map[string]string{"N": strconv.FormatInt(deviceId, 10)},
+		"EventType":   map[string]string{"S": eventType},
+		"Granularity": map[string]string{"S": granularity},
+		"Start":       map[string]string{"N": strconv.FormatInt(start.Unix(), 10)},
+		"Count":       map[string]string{"N": strconv.FormatInt(count, 10)},
+		"Sum":         map[string]string{"N": strconv.FormatFloat(sum, 'f', -1, 64)},
+		"Min":         map[string]string{"N": strconv.FormatFloat(min, 'f', -1, 64)},
+		"Max":         map[string]string{"N": strconv.FormatFloat(max, 'f', -1, 64)},
+		"Last":        map[string]string{"N": strconv.FormatFloat(max, 'f', -1, 64)},
+		"LastTime":    map[string]string{"N": strconv.FormatInt(start.Unix(), 10)},
+	}
+}
+
+func TestQueryDbReadsAllPages(t *testing.T) {
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	items := []map[string]any{}
+	for i := range 5 {
+		items = append(items, eventItem(1001, start.Add(time.Duration(i)*time.Minute), "Temperature", float64(i)))
+	}
+	fake := &fakeDynamo{items: items, pageSize: 2}
+	withFakeDynamo(t, fake)
+
+	events, err := queryDb(InputParams{DeviceId: 1001, FromTime: start, ToTime: start.Add(time.Hour), UnitSystem: defaultUnitSystem})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != len(items) || fake.queryCount() != 3 {
+		t.Fatalf("%d events in %d queries, expected %d events in 3 pages", len(events), fake.queryCount(), len(items))
+	}
+	for i, event := range events {
+		if event.Value != float64(i) {
+			t.Errorf("event %d of value %v, expected %d", i, event.Value, i)
+		}
+	}
+}
+
+func TestQueryDbFailsOnPartialResults(t *testing.T) {
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	items := []map[string]any{}
+	for i := range 5 {
+		items = append(items, eventItem(1001, start.Add(time.Duration(i)*time.Minute), "Temperature", float64(i)))
+	}
+	withFakeDynamo(t, &fakeDynamo{items: items, pageSize: 2, failAfter: 1})
+
+	if events, err := queryDb(InputParams{DeviceId: 1001, FromTime: start, ToTime: start.Add(time.Hour), UnitSystem: defaultUnitSystem}); err == nil {
+		t.Errorf("%d events, expected an error rather than the first page only", len(events))
+	}
+}
@@ -0,0 +1,115 @@
+// OpenAPI specification of the REST API, served at /openapi.json and against which
+// the query parameters are validated before being parsed.
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+//go:embed openapi.json
+var openApiSpec []byte
+
+// parameters of GET /weather, as declared in the spec
+var weatherParams []openApiParameter
+
+// subset of the OpenAPI document needed to validate the query parameters
+type openApiDocument struct {
+	Paths map[string]map[string]struct {
+		Parameters []openApiParameter `json:"parameters"`
+	} `json:"paths"`
+}
+
+type openApiParameter struct {
+	Name     string `json:"name"`
+	In       string `json:"in"`
+	Required bool   `json:"required"`
+	Schema   struct {
+		Type    string   `json:"type"`
+		Enum    []string `json:"enum"`
+		Pattern string   `json:"pattern"`
+	} `json:"schema"`
+	pattern *regexp.Regexp
+}
+
+// loadWeatherParams reads the query parameters of GET /weather from the spec
+func loadWeatherParams(spec []byte) ([]openApiParameter, error) {
+	document := openApiDocument{}
+	if err := json.Unmarshal(spec, &document); err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI spec: %w", err)
+	}
+	operation, ok := document.Paths["/weather"]["get"]
+	if !ok {
+		return nil, fmt.Errorf("invalid OpenAPI spec: GET /weather is not declared")
+	}
+
+	params := []openApiParameter{}
+	for _, param := range operation.Parameters {
+		if param.In != "query" {
+			continue
+		}
+		if param.Schema.Pattern != "" {
+			pattern, err := regexp.Compile(param.Schema.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid OpenAPI spec: pattern of %s: %w", param.Name, err)
+			}
+			param.pattern = pattern
+		}
+		params = append(params, param)
+	}
+	return params, nil
+}
+
+// validateParams checks the query parameters against those declared in the spec,
+// reporting the first missing, unknown or invalid one
+func validateParams(params map[string]string) *ParamError {
+	// sorted, such that the same unknown parameter is reported whatever the order of the map
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !slices.ContainsFunc(weatherParams, func(param openApiParameter) bool { return param.Name == name }) {
+			return &ParamError{Param: name, Detail: "unknown parameter"}
+		}
+	}
+
+	for _, param := range weatherParams {
+		value, ok := params[param.Name]
+		if !ok || value == "" {
+			if param.Required {
+				return &ParamError{Param: param.Name, Detail: "missing"}
+			}
+			continue
+		}
+
+		if param.Schema.Type == "integer" {
+			if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+				return &ParamError{Param: param.Name, Detail: "not an integer"}
+			}
+		}
+		if len(param.Schema.Enum) > 0 && !slices.Contains(param.Schema.Enum, value) {
+			return &ParamError{Param: param.Name, Detail: fmt.Sprintf("should be one of %v", param.Schema.Enum)}
+		}
+		if param.pattern != nil && !param.pattern.MatchString(value) {
+			return &ParamError{Param: param.Name, Detail: fmt.Sprintf("should match %s", param.Schema.Pattern)}
+		}
+	}
+	return nil
+}
+
+func openApiSpecResponse() events.APIGatewayProxyResponse {
+	return events.APIGatewayProxyResponse{
+		Body:       string(openApiSpec),
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// specValidator checks JSON values against the schemas of the OpenAPI document,
+// supporting the subset of JSON schema the document uses
+type specValidator struct {
+	document map[string]any
+}
+
+func newSpecValidator(t *testing.T) specValidator {
+	document := map[string]any{}
+	if err := json.Unmarshal(openApiSpec, &document); err != nil {
+		t.Fatal(err)
+	}
+	return specValidator{document: document}
+}
+
+// lookup follows that path of keys in the document
+func (v specValidator) lookup(keys ...string) (map[string]any, bool) {
+	node := v.document
+	for _, key := range keys {
+		child, ok := node[key].(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		node = child
+	}
+	return node, true
+}
+
+// resolve follows the $ref of that node, if any, e.g. #/components/schemas/WeatherEvent
+func (v specValidator) resolve(node map[string]any) map[string]any {
+	ref, ok := node["$ref"].(string)
+	if !ok {
+		return node
+	}
+	resolved, ok := v.lookup(strings.Split(strings.TrimPrefix(ref, "#/"), "/")...)
+	if !ok {
+		panic(fmt.Sprintf("unresolved $ref %s", ref))
+	}
+	return v.resolve(resolved)
+}
+
+func (v specValidator) validate(schema map[string]any, value any, path string) error {
+	schema = v.resolve(schema)
+
+	if anyOf, ok := schema["anyOf"].([]any); ok {
+		for _, alternative := range anyOf {
+			if v.validate(alternative.(map[string]any), value, path) == nil {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s matches none of the schemas of anyOf", path)
+	}
+
+	if enum, ok := schema["enum"].([]any); ok && !slices.Contains(enum, value) {
+		return fmt.Errorf("%s is %v, expected one of %v", path, value, enum)
+	}
+
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s is %v, expected an object", path, value)
+		}
+		for _, required := range asSlice(schema["required"]) {
+			if _, ok := object[required.(string)]; !ok {
+				return fmt.Errorf("%s misses required %s", path, required)
+			}
+		}
+		properties, _ := schema["properties"].(map[string]any)
+		for name, property := range object {
+			propertySchema, declared := properties[name].(map[string]any)
+			if !declared {
+				if schema["additionalProperties"] == false {
+					return fmt.Errorf("%s has undeclared property %s", path, name)
+				}
+				continue
+			}
+			if err := v.validate(propertySchema, property, path+"."+name); err != nil {
+				return err
+			}
+		}
+	case "array":
+		array, ok := value.([]any)
+		if !ok {
+			return fmt.Errorf("%s is %v, expected an array", path, value)
+		}
+		items, _ := schema["items"].(map[string]any)
+		for i, item := range array {
+			if err := v.validate(items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s is %v, expected a string", path, value)
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339, str); err != nil {
+				return fmt.Errorf("%s is %q, expected a date-time", path, str)
+			}
+		}
+	case "integer":
+		number, ok := value.(json.Number)
+		if _, err := number.Int64(); !ok || err != nil {
+			return fmt.Errorf("%s is %v, expected an integer", path, value)
+		}
+	case "number":
+		if _, ok := value.(json.Number); !ok {
+			return fmt.Errorf("%s is %v, expected a number", path, value)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s is %v, expected a boolean", path, value)
+		}
+	}
+	return nil
+}
+
+func asSlice(value any) []any {
+	slice, _ := value.([]any)
+	return slice
+}
+
+// checkResponse checks that response of that path against those declared in the OpenAPI document:
+// status, headers, content type and, for JSON content, body
+func (v specValidator) checkResponse(path string, response events.APIGatewayProxyResponse) error {
+	responses, ok := v.lookup("paths", path, "get", "responses")
+	if !ok {
+		return fmt.Errorf("GET %s not declared", path)
+	}
+	declared, ok := responses[fmt.Sprint(response.StatusCode)].(map[string]any)
+	if !ok {
+		return fmt.Errorf("status %d of GET %s not declared", response.StatusCode, path)
+	}
+	declared = v.resolve(declared)
+
+	headers, _ := declared["headers"].(map[string]any)
+	for name, value := range response.Headers {
+		if name == "Content-Type" {
+			continue
+		}
+		header, ok := headers[name].(map[string]any)
+		if !ok {
+			return fmt.Errorf("header %s not declared", name)
+		}
+		if err := v.validate(header["schema"].(map[string]any), value, name); err != nil {
+			return err
+		}
+	}
+
+	contentType := response.Headers["Content-Type"]
+	content, _ := declared["content"].(map[string]any)
+	media, ok := content[contentType].(map[string]any)
+	if !ok {
+		return fmt.Errorf("content type %q of status %d not declared", contentType, response.StatusCode)
+	}
+	if contentType != "application/json" && !strings.HasSuffix(contentType, "+json") {
+		return nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader([]byte(response.Body)))
+	decoder.UseNumber()
+	var body any
+	if err := decoder.Decode(&body); err != nil {
+		return fmt.Errorf("invalid JSON body: %w", err)
+	}
+	return v.validate(media["schema"].(map[string]any), body, "body")
+}
+
+func TestHandlerConformsToOpenApiSpec(t *testing.T) {
+	validator := newSpecValidator(t)
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	eventItems := []map[string]any{
+		eventItem(1001, start.Add(time.Minute), "Temperature", 12.5),
+		eventItem(1001, start.Add(time.Minute), "Humidity", 60),
+		eventItem(1001, start.Add(2*time.Minute), "Temperature", 13),
+	}
+	rollups := []map[string]any{
+		rollupItem(1001, "Hour", start, "Temperature", 60, 750, 12, 13),
+		rollupItem(1001, "Hour", start.Add(time.Hour), "Temperature", 60, 780, 12.5, 13.5),
+	}
+	period := map[string]string{"device_id": "1001", "from": "2024-03-01T00:00:00Z", "to": "2024-03-01T02:00:00Z"}
+	with := func(params map[string]string, extra ...string) map[string]string {
+		merged := map[string]string{}
+		for k, v := range params {
+			merged[k] = v
+		}
+		for i := 0; i < len(extra); i += 2 {
+			merged[extra[i]] = extra[i+1]
+		}
+		return merged
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		params   map[string]string
+		accept   string
+		items    []map[string]any
+		fail     bool
+		expected int
+	}{
+		{name: "events", params: period, items: eventItems, expected: 200},
+		{name: "no events", params: period, expected: 200},
+		{name: "imperial events of one type", params: with(period, "event_type", "Temperature", "units", "imperial"), items: eventItems, expected: 200},
+		{name: "aggregated events", params: with(period, "bucket", "15m"), items: eventItems, expected: 200},
+		{name: "rollups", params: with(period, "bucket", "1h"), items: rollups, expected: 200},
+		{name: "csv", params: with(period, "format", "csv"), items: eventItems, expected: 200},
+		{name: "ndjson", params: period, accept: "application/x-ndjson", items: eventItems, expected: 200},
+		{name: "parquet", params: with(period, "format", "parquet"), items: eventItems, expected: 200},
+		{name: "missing param", params: map[string]string{"device_id": "1001"}, expected: 400},
+		{name: "unknown param", params: with(period, "limit", "10"), expected: 400},
+		{name: "invalid bucket", params: with(period, "bucket", "1y"), expected: 400},
+		{name: "not acceptable", params: period, accept: "image/png", expected: 406},
+		{name: "storage failure", params: period, fail: true, expected: 500},
+		{name: "spec", path: "/openapi.json", expected: 200},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			withFakeDynamo(t, &fakeDynamo{items: test.items, fail: test.fail})
+			path := test.path
+			if path == "" {
+				path = "/weather"
+			}
+
+			response, err := handler(context.Background(), events.APIGatewayProxyRequest{
+				Resource:              path,
+				QueryStringParameters: test.params,
+				Headers:               map[string]string{"Accept": test.accept},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.StatusCode != test.expected {
+				t.Fatalf("status %d, expected %d: %s", response.StatusCode, test.expected, response.Body)
+			}
+			if err := validator.checkResponse(path, response); err != nil {
+				t.Errorf("response does not conform to the spec: %v\n%s", err, response.Body)
+			}
+		})
+	}
+}
+
+func TestValidateParamsReportsFirstUnknownParam(t *testing.T) {
+	for range 20 {
+		paramErr := validateParams(map[string]string{"zone": "1", "device_id": "1001", "limit": "10", "after": "x"})
+		if paramErr == nil || paramErr.Param != "after" {
+			t.Fatalf("reported %v, expected the unknown after param", paramErr)
+		}
+	}
+}
+
+// route is one method of one path of the REST API
+type route struct {
+	Method string
+	Path   string
+}
+
+// restApiRoutes lists the routes of the REST API declared by the Api events of the functions of the SAM template
+func restApiRoutes(t *testing.T) []route {
+	template, err := os.Open("../template.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer template.Close()
+
+	property := regexp.MustCompile(`^\s+(RestApiId|Path|Method): (.+?)\s*$`)
+	routes := []route{}
+	current, onRestApi := route{}, false
+	scanner := bufio.NewScanner(template)
+	for scanner.Scan() {
+		match := property.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			continue
+		}
+		switch match[1] {
+		case "RestApiId":
+			current, onRestApi = route{}, match[2] == "!Ref WeatherReadFrontendApi"
+		case "Path":
+			current.Path = match[2]
+		case "Method":
+			current.Method = strings.ToLower(match[2])
+		}
+		if onRestApi && current.Path != "" && current.Method != "" {
+			routes = append(routes, current)
+			current, onRestApi = route{}, false
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return routes
+}
+
+func TestOpenApiSpecDeclaresEveryRoute(t *testing.T) {
+	validator := newSpecValidator(t)
+	routes := restApiRoutes(t)
+	// those of the frontend itself and of the ingestion, at least
+	if !slices.Contains(routes, route{Method: "get", Path: "/weather"}) || !slices.Contains(routes, route{Method: "post", Path: "/weather/batch"}) {
+		t.Fatalf("found only the routes %v in the SAM template", routes)
+	}
+
+	pathParam := regexp.MustCompile(`\{([a-z_]+)\}`)
+	for _, route := range routes {
+		operation, ok := validator.lookup("paths", route.Path, route.Method)
+		if !ok {
+			t.Errorf("%s %s is not declared in the OpenAPI spec", strings.ToUpper(route.Method), route.Path)
+			continue
+		}
+		if _, ok := operation["responses"].(map[string]any); !ok || operation["operationId"] == nil {
+			t.Errorf("%s %s lacks an operationId or responses", strings.ToUpper(route.Method), route.Path)
+		}
+		for _, match := range pathParam.FindAllStringSubmatch(route.Path, -1) {
+			declared := slices.ContainsFunc(asSlice(operation["parameters"]), func(param any) bool {
+				return param.(map[string]any)["name"] == match[1] && param.(map[string]any)["in"] == "path"
+			})
+			if !declared {
+				t.Errorf("%s %s does not declare its path parameter %s", strings.ToUpper(route.Method), route.Path, match[1])
+			}
+		}
+	}
+
+	// and no other route
+	for path, item := range validator.document["paths"].(map[string]any) {
+		for method := range item.(map[string]any) {
+			if !slices.Contains(routes, route{Method: method, Path: path}) {
+				t.Errorf("%s %s is declared in the OpenAPI spec, but not served", strings.ToUpper(method), path)
+			}
+		}
+	}
+}
+
+func TestOpenApiSpecDescribesIngestion(t *testing.T) {
+	validator := newSpecValidator(t)
+	tests := []struct {
+		schema string
+		body   string
+		valid  bool
+	}{
+		{"Reading", `{"Time": "2024-03-01T12:00:00Z", "EventType": "Temperature", "Value": 21.5}`, true},
+		{"Reading", `{"DeviceId": 1001, "Time": "2024-03-01T12:00:00Z", "EventType": "Temperature", "Value": 21.5, "Unit": "°C"}`, true},
+		{"Reading", `{"Time": "2024-03-01T12:00:00Z", "EventType": "Temperature"}`, false},
+		{"Reading", `{"Time": "2024-03-01T12:00:00Z", "EventType": "Snow", "Value": 2}`, false},
+		{"Reading", `{"Time": "2024-03-01T12:00:00Z", "EventType": "Temperature", "Value": 21.5, "Quality": "good"}`, false},
+		{"ReadingBatch", `{"Readings": [{"Time": "2024-03-01T12:00:00Z", "EventType": "Temperature", "Value": 21.5}, {"Time": "2024-03-01T12:00:00Z", "EventType": "Humidity", "Value": 64}]}`, true},
+		{"ReadingBatch", `[{"Time": "2024-03-01T12:00:00Z", "EventType": "Temperature", "Value": 21.5}]`, false},
+		{"IngestionResult", `{"Accepted": 2, "Duplicates": 1}`, true},
+		{"Problem", `{"type": "/problems/idempotency-key-reused", "title": "Idempotency-Key reused", "status": 422, "requestId": "request-1"}`, true},
+		{"Problem", `{"type": "/problems/invalid-reading", "title": "Invalid reading", "status": 400, "detail": "invalid reading 1: missing Value"}`, true},
+		{"Problem", `{"type": "/problems/unauthorized", "title": "Unauthorized", "status": 401}`, true},
+	}
+	for _, test := range tests {
+		decoder := json.NewDecoder(strings.NewReader(test.body))
+		decoder.UseNumber()
+		var body any
+		if err := decoder.Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		schema, _ := validator.lookup("components", "schemas", test.schema)
+		if err := validator.validate(schema, body, "body"); (err == nil) != test.valid {
+			t.Errorf("%s %s: error %v, expected valid %v", test.schema, test.body, err, test.valid)
+		}
+	}
+
+	for _, path := range []string{"/weather", "/weather/batch"} {
+		for _, status := range []string{"202", "400", "401", "422", "500"} {
+			if _, ok := validator.lookup("paths", path, "post", "responses", status); !ok {
+				t.Errorf("status %s of POST %s not declared", status, path)
+			}
+		}
+	}
+}
@@ -0,0 +1,58 @@
+// Error responses, as RFC 7807 problem documents.
+package main
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	"weather_data_generator/weather_problem"
+)
+
+// problem types, relative to the API URL
+const (
+	invalidParamProblemType   = "/problems/invalid-parameter"
+	storageFailureProblemType = "/problems/storage-failure"
+	notAcceptableProblemType  = "/problems/not-acceptable"
+)
+
+// ParamError reports an invalid or missing query parameter
+type ParamError struct {
+	Param  string
+	Detail string
+}
+
+func (e *ParamError) Error() string {
+	return fmt.Sprintf("invalid %s param: %s", e.Param, e.Detail)
+}
+
+func invalidParamProblem(err *ParamError, requestId string) events.APIGatewayProxyResponse {
+	return weather_problem.Response(weather_problem.Problem{
+		Type:      invalidParamProblemType,
+		Title:     "Invalid query parameter",
+		Status:    400,
+		Detail:    err.Error(),
+		Param:     err.Param,
+		RequestId: requestId,
+	})
+}
+
+func notAcceptableProblem(accept string, requestId string) events.APIGatewayProxyResponse {
+	return weather_problem.Response(weather_problem.Problem{
+		Type:      notAcceptableProblemType,
+		Title:     "Not acceptable",
+		Status:    406,
+		Detail:    fmt.Sprintf("none of the media types %q is supported, should be one of application/json, text/csv, application/x-ndjson or application/vnd.apache.parquet", accept),
+		RequestId: requestId,
+	})
+}
+
+func serverSideProblem(requestId string) events.APIGatewayProxyResponse {
+	return weather_problem.Response(weather_problem.Problem{
+		Type:      storageFailureProblemType,
+		Title:     "Failed to fetch events",
+		Status:    500,
+		Detail:    "failed to fetch event from db",
+		RequestId: requestId,
+	})
+}
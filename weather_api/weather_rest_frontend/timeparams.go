@@ -0,0 +1,100 @@
+// Parsing of the from and to query parameters.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const iso8601Tormat = "2006-01-02T15:04:05-0700"
+
+// epoch timestamps above that are in milliseconds (as seconds, it would be in year 5138)
+const maxEpochSeconds = 100_000_000_000
+
+const defaultMaxQueryWindow = 31 * 24 * time.Hour
+
+const timeFormatsHelp = "should be RFC 3339 (e.g. 2024-03-01T12:00:00Z), Unix epoch seconds or milliseconds, or relative to now (e.g. now-1h, now-7d)"
+
+// parseTimeRange parses the from and to params, and checks that they define a valid period
+func parseTimeRange(params map[string]string, now time.Time) (time.Time, time.Time, *ParamError) {
+	fromTime, err := parseTime(params["from"], now)
+	if err != nil {
+		return time.Time{}, time.Time{}, &ParamError{Param: "from", Detail: err.Error()}
+	}
+	toTime, err := parseTime(params["to"], now)
+	if err != nil {
+		return time.Time{}, time.Time{}, &ParamError{Param: "to", Detail: err.Error()}
+	}
+
+	if toTime.Before(fromTime) {
+		return time.Time{}, time.Time{}, &ParamError{Param: "to", Detail: "should not be before from"}
+	}
+	if toTime.Sub(fromTime) > maxQueryWindow {
+		return time.Time{}, time.Time{}, &ParamError{Param: "to", Detail: fmt.Sprintf("the queried period should not exceed %d days", int(maxQueryWindow.Hours()/24))}
+	}
+	return fromTime, toTime, nil
+}
+
+// parseTime parses a timestamp, in any of the formats accepted by the API
+func parseTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, errors.New("missing")
+	}
+
+	if relative, found := strings.CutPrefix(value, "now"); found {
+		if relative == "" {
+			return now, nil
+		}
+		offset, err := parseDuration(relative[1:])
+		// the sign is given by the operator, e.g. now--1h is refused
+		if err != nil || offset < 0 || (relative[0] != '-' && relative[0] != '+') {
+			return time.Time{}, fmt.Errorf("invalid relative time %q: should be now, now-<duration> or now+<duration>, e.g. now-90m", value)
+		}
+		if relative[0] == '-' {
+			offset = -offset
+		}
+		return now.Add(offset), nil
+	}
+
+	if epoch, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if epoch > maxEpochSeconds {
+			return time.UnixMilli(epoch), nil
+		}
+		return time.Unix(epoch, 0), nil
+	}
+
+	// the format historically accepted by the API, whose offset has no colon
+	for _, layout := range []string{time.RFC3339Nano, iso8601Tormat} {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			return parsed, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q: %s", value, timeFormatsHelp)
+}
+
+// parseDuration parses a Go duration (e.g. 15m, 1h30m) or a number of days (e.g. 7d)
+func parseDuration(value string) (time.Duration, error) {
+	if days, found := strings.CutSuffix(value, "d"); found {
+		dayCount, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number of days %q", value)
+		}
+		return time.Duration(dayCount) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// parseMaxQueryWindow parses the max duration of the queried period, as a number of days
+func parseMaxQueryWindow(maxQueryDays string) (time.Duration, error) {
+	if maxQueryDays == "" {
+		return defaultMaxQueryWindow, nil
+	}
+	days, err := strconv.Atoi(maxQueryDays)
+	if err != nil || days <= 0 {
+		return 0, fmt.Errorf("invalid MAX_QUERY_DAYS %q: should be a strictly positive number of days", maxQueryDays)
+	}
+	return time.Duration(days) * 24 * time.Hour, nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value    string
+		expected time.Time
+		valid    bool
+	}{
+		{"2024-03-01T10:30:00Z", time.Date(2024, 3, 1, 10, 30, 0, 0, time.UTC), true},
+		{"2024-03-01T10:30:00+01:00", time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC), true},
+		{"2024-03-01T10:30:00.250-05:00", time.Date(2024, 3, 1, 15, 30, 0, 250_000_000, time.UTC), true},
+		// offsets without a colon, as historically accepted
+		{"2024-03-01T10:30:00+0100", time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC), true},
+		{"2024-03-01T10:30:00-0530", time.Date(2024, 3, 1, 16, 0, 0, 0, time.UTC), true},
+		{"2024-03-01T10:30:00", time.Time{}, false},
+		{"2024-03-01", time.Time{}, false},
+		{"01/03/2024", time.Time{}, false},
+		// epoch seconds, then milliseconds above 100 billion
+		{"1709294400", time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), true},
+		{"1709294400123", time.Date(2024, 3, 1, 12, 0, 0, 123_000_000, time.UTC), true},
+		{"100000000000", time.Unix(100_000_000_000, 0), true},
+		{"100000000001", time.UnixMilli(100_000_000_001), true},
+		{"0", time.Unix(0, 0), true},
+		{"1709294400.5", time.Time{}, false},
+		// relative to now
+		{"now", now, true},
+		{"now-1h", now.Add(-time.Hour), true},
+		{"now+90m", now.Add(90 * time.Minute), true},
+		{"now-1h30m", now.Add(-90 * time.Minute), true},
+		{"now-7d", now.Add(-7 * 24 * time.Hour), true},
+		{"now+0s", now, true},
+		{"now-", time.Time{}, false},
+		{"now1h", time.Time{}, false},
+		{"now*1h", time.Time{}, false},
+		{"now--1h", time.Time{}, false},
+		{"now+-7d", time.Time{}, false},
+		{"now-1.5d", time.Time{}, false},
+		{"now-1w", time.Time{}, false},
+		{"Now-1h", time.Time{}, false},
+		{"", time.Time{}, false},
+	}
+	for _, test := range tests {
+		parsed, err := parseTime(test.value, now)
+		if (err == nil) != test.valid {
+			t.Errorf("%q: error %v, expected valid %v", test.value, err, test.valid)
+			continue
+		}
+		if test.valid && !parsed.Equal(test.expected) {
+			t.Errorf("%q: parsed %v, expected %v", test.value, parsed, test.expected)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected time.Duration
+		valid    bool
+	}{
+		{"15m", 15 * time.Minute, true},
+		{"1h30m", 90 * time.Minute, true},
+		{"1d", 24 * time.Hour, true},
+		{"7d", 7 * 24 * time.Hour, true},
+		{"0d", 0, true},
+		{"d", 0, false},
+		{"1.5d", 0, false},
+		{"1d12h", 0, false},
+		{"7", 0, false},
+		{"", 0, false},
+	}
+	for _, test := range tests {
+		duration, err := parseDuration(test.value)
+		if (err == nil) != test.valid {
+			t.Errorf("%q: error %v, expected valid %v", test.value, err, test.valid)
+			continue
+		}
+		if test.valid && duration != test.expected {
+			t.Errorf("%q: parsed %v, expected %v", test.value, duration, test.expected)
+		}
+	}
+}
+
+func TestParseTimeRange(t *testing.T) {
+	defaultWindow := maxQueryWindow
+	maxQueryWindow = defaultMaxQueryWindow
+	t.Cleanup(func() { maxQueryWindow = defaultWindow })
+
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		from  string
+		to    string
+		param string
+	}{
+		{"now-1h", "now", ""},
+		{"2024-03-01T10:00:00Z", "2024-03-01T10:00:00+00:00", ""},
+		{"now-31d", "now", ""},
+		{"1706702400", "1709380800000", ""},
+		// from after to
+		{"now", "now-1s", "to"},
+		{"2024-03-01T10:00:00-0100", "2024-03-01T10:59:59Z", "to"},
+		// longer than the max window
+		{"now-31d", "now+1s", "to"},
+		{"now-32d", "now", "to"},
+		{"", "now", "from"},
+		{"yesterday", "now", "from"},
+		{"now-1h", "", "to"},
+		{"now-1h", "tomorrow", "to"},
+	}
+	for _, test := range tests {
+		from, to, paramErr := parseTimeRange(map[string]string{"from": test.from, "to": test.to}, now)
+		if test.param == "" {
+			if paramErr != nil {
+				t.Errorf("%s to %s: error %+v", test.from, test.to, paramErr)
+			} else if to.Before(from) {
+				t.Errorf("%s to %s: parsed %v to %v", test.from, test.to, from, to)
+			}
+			continue
+		}
+		if paramErr == nil || paramErr.Param != test.param {
+			t.Errorf("%s to %s: error %+v, expected an error on %s", test.from, test.to, paramErr, test.param)
+		}
+	}
+}
+
+func TestParseMaxQueryWindow(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected time.Duration
+		valid    bool
+	}{
+		{"", defaultMaxQueryWindow, true},
+		{"7", 7 * 24 * time.Hour, true},
+		{"365", 365 * 24 * time.Hour, true},
+		{"0", 0, false},
+		{"-1", 0, false},
+		{"7d", 0, false},
+	}
+	for _, test := range tests {
+		window, err := parseMaxQueryWindow(test.value)
+		if (err == nil) != test.valid {
+			t.Errorf("%q: error %v, expected valid %v", test.value, err, test.valid)
+			continue
+		}
+		if test.valid && window != test.expected {
+			t.Errorf("%q: parsed %v, expected %v", test.value, window, test.expected)
+		}
+	}
+}
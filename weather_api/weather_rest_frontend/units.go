@@ -0,0 +1,58 @@
+// Conversion of the weather event values to the unit system requested by the client.
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"weather_data_generator/weather_generator"
+)
+
+const defaultUnitSystem = "metric"
+
+var unitSystems = []string{"metric", "imperial", "si"}
+
+type unitConversion struct {
+	unit    string
+	convert func(float64) float64
+}
+
+// conversions from the metric units, per unit system and event type. Event types
+// absent from a unit system keep their metric unit.
+var conversions = map[string]map[string]unitConversion{
+	"imperial": {
+		"Pressure":      {unit: "inHg", convert: func(hPa float64) float64 { return hPa * 0.0295299830714 }},
+		"Temperature":   {unit: "°F", convert: func(celsius float64) float64 { return celsius*9/5 + 32 }},
+		"WindSpeed":     {unit: "mph", convert: func(kmh float64) float64 { return kmh / 1.609344 }},
+		"Precipitation": {unit: "in/h", convert: func(mmh float64) float64 { return mmh / 25.4 }},
+	},
+	"si": {
+		"Pressure":    {unit: "Pa", convert: func(hPa float64) float64 { return hPa * 100 }},
+		"Temperature": {unit: "K", convert: func(celsius float64) float64 { return celsius + 273.15 }},
+		"WindSpeed":   {unit: "m/s", convert: func(kmh float64) float64 { return kmh / 3.6 }},
+	},
+}
+
+func parseUnitSystem(params map[string]string) (string, *ParamError) {
+	unitSystem, ok := params["units"]
+	if !ok || unitSystem == "" {
+		return defaultUnitSystem, nil
+	}
+	if !slices.Contains(unitSystems, unitSystem) {
+		return "", &ParamError{Param: "units", Detail: fmt.Sprintf("should be one of %v", unitSystems)}
+	}
+	return unitSystem, nil
+}
+
+// convertUnits expresses the value of that event in the given unit system. Events
+// stored before units were recorded are assumed to be in metric units.
+func convertUnits(event WeatherEvent, unitSystem string) WeatherEvent {
+	if event.Unit == "" {
+		event.Unit = weather_generator.UnitOf(event.EventType)
+	}
+	if conversion, ok := conversions[unitSystem][event.EventType]; ok && event.Unit == weather_generator.UnitOf(event.EventType) {
+		event.Value = conversion.convert(event.Value)
+		event.Unit = conversion.unit
+	}
+	return event
+}
@@ -0,0 +1,202 @@
+// Lambda listening to new weather events from the Kinesis data stream of the table and maintaining hourly and
+// daily rollups (count, sum, min, max, last) of them, per device and event type.
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"weather_data_generator/weather_generator"
+	"weather_data_generator/weather_storage"
+)
+
+var dynamodbClient *dynamodb.Client
+var dynamoTable *string
+
+// rollup granularities, as used in the rollup sort keys
+var granularities = map[string]time.Duration{
+	"Hour": time.Hour,
+	"Day":  24 * time.Hour,
+}
+
+func init() {
+	sdkConfig, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		log.Fatal("Could not connect to AWS API", err)
+	}
+
+	dynamoTable = aws.String(os.Getenv("DYNAMO_TABLE"))
+	dynamodbClient = dynamodb.NewFromConfig(sdkConfig)
+}
+
+// handler adds the new events to their rollups. On failure, that record and the following ones are reported
+// to be retried, the previous ones being already counted.
+func handler(ctx context.Context, event events.KinesisEvent) (events.KinesisEventResponse, error) {
+	timeBoxedCtx, cancel := context.WithTimeout(ctx, 20*time.Second)
+	defer cancel()
+
+	for _, record := range event.Records {
+		change, err := weather_storage.ChangeRecord(record)
+		if err != nil {
+			log.Printf("not aggregating record: %v", err)
+			continue
+		}
+		// modified events would be counted twice, and removed (expired) ones are still part of the rollups
+		if change.EventName != string(events.DynamoDBOperationTypeInsert) {
+			continue
+		}
+		weatherEvent, err := weather_storage.ParseStreamImage(change.Change.NewImage)
+		if err != nil {
+			log.Printf("not aggregating %s record: %v", change.EventName, err)
+			continue
+		}
+
+		// the id of the change, rather than the Kinesis record, such that a record repeated by Kinesis is not counted twice
+		if err := addToRollups(timeBoxedCtx, weatherEvent, change.EventID); err != nil {
+			log.Printf("failed to add event %v to its rollups, retrying from record %s: %v", weatherEvent, record.Kinesis.SequenceNumber, err)
+			return events.KinesisEventResponse{
+				BatchItemFailures: []events.KinesisBatchItemFailure{{ItemIdentifier: record.Kinesis.SequenceNumber}},
+			}, nil
+		}
+	}
+	return events.KinesisEventResponse{}, nil
+}
+
+// addToRollups adds that event to the rollup of its bucket of each granularity, creating them if needed.
+// Min, Max and Last are only overwritten when that event beats them, such that concurrent updates of the same
+// rollup are safe, and that they may be applied again when the record is retried. Count and Sum are then incremented
+// in a single transaction for all granularities, made idempotent by the id of the change record, such that a retried
+// record is not counted twice.
+func addToRollups(ctx context.Context, event weather_generator.WeatherEvent, recordId string) error {
+	totals := make([]types.TransactWriteItem, 0, len(granularities))
+	for granularity, bucketSize := range granularities {
+		bucketStart := event.Time.UTC().Truncate(bucketSize)
+		key := rollupKey(event.DeviceId, granularity, bucketStart, event.EventType)
+		if err := updateExtremes(ctx, key, event); err != nil {
+			return fmt.Errorf("failed to update the %s rollup: %w", granularity, err)
+		}
+
+		update := expression.
+			Set(expression.Name("DeviceId"), expression.Value(event.DeviceId)).
+			Set(expression.Name("EventType"), expression.Value(event.EventType)).
+			Set(expression.Name("Granularity"), expression.Value(granularity)).
+			Set(expression.Name("Start"), expression.Value(bucketStart.Unix())).
+			Add(expression.Name("Count"), expression.Value(1)).
+			Add(expression.Name("Sum"), expression.Value(event.Value))
+		expr, err := expression.NewBuilder().WithUpdate(update).Build()
+		if err != nil {
+			return fmt.Errorf("error while building DynamoDB update: %w", err)
+		}
+		totals = append(totals, types.TransactWriteItem{Update: &types.Update{
+			TableName:                 dynamoTable,
+			Key:                       key,
+			UpdateExpression:          expr.Update(),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+		}})
+	}
+
+	_, err := dynamodbClient.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems:      totals,
+		ClientRequestToken: aws.String(idempotencyToken(recordId)),
+	})
+	if err != nil {
+		return fmt.Errorf("error while updating rollup totals in DynamodDB: %w", err)
+	}
+	return nil
+}
+
+// idempotencyToken derives a client request token, of at most 36 characters, from that change record id.
+// DynamoDB ignores a transaction repeating the token of one applied within the last 10 minutes.
+func idempotencyToken(recordId string) string {
+	hash := sha256.Sum256([]byte(recordId))
+	return hex.EncodeToString(hash[:16])
+}
+
+// updateExtremes overwrites the Min, Max and Last of the rollup of that key when that event beats them
+func updateExtremes(ctx context.Context, key map[string]types.AttributeValue, event weather_generator.WeatherEvent) error {
+	minimum := expression.Name("Min").AttributeNotExists().
+		Or(expression.Name("Min").GreaterThan(expression.Value(event.Value)))
+	maximum := expression.Name("Max").AttributeNotExists().
+		Or(expression.Name("Max").LessThan(expression.Value(event.Value)))
+	last := expression.Name("LastTime").AttributeNotExists().
+		Or(expression.Name("LastTime").LessThanEqual(expression.Value(event.Time.Unix())))
+
+	conditionalUpdates := []struct {
+		update    expression.UpdateBuilder
+		condition expression.ConditionBuilder
+	}{
+		{expression.Set(expression.Name("Min"), expression.Value(event.Value)), minimum},
+		{expression.Set(expression.Name("Max"), expression.Value(event.Value)), maximum},
+		{
+			expression.
+				Set(expression.Name("Last"), expression.Value(event.Value)).
+				Set(expression.Name("LastTime"), expression.Value(event.Time.Unix())),
+			last,
+		},
+	}
+	for _, conditional := range conditionalUpdates {
+		if err := updateRollup(ctx, key, conditional.update, conditional.condition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateRollup applies that update, provided the condition holds
+func updateRollup(ctx context.Context, key map[string]types.AttributeValue, update expression.UpdateBuilder, condition expression.ConditionBuilder) error {
+	expr, err := expression.NewBuilder().WithUpdate(update).WithCondition(condition).Build()
+	if err != nil {
+		return fmt.Errorf("error while building DynamoDB update: %w", err)
+	}
+
+	_, err = dynamodbClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 dynamoTable,
+		Key:                       key,
+		UpdateExpression:          expr.Update(),
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		// the current value already beats this event
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error while updating rollup in DynamodDB: %w", err)
+	}
+	return nil
+}
+
+// rollupKey follows the layout of the weather events, under a distinct partition per device,
+// e.g. PK "Rollup#DeviceId#1001", SK "Hour#1709294400#TypeTemperature"
+func rollupKey(deviceId int64, granularity string, bucketStart time.Time, eventType string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{
+			Value: fmt.Sprintf("Rollup#DeviceId#%d", deviceId),
+		},
+		"SK": &types.AttributeValueMemberS{
+			Value: fmt.Sprintf("%s#%d#Type%s", granularity, bucketStart.Unix(), eventType),
+		},
+	}
+}
+
+func main() {
+	lambda.Start(handler)
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"weather_data_generator/weather_generator"
+)
+
+// attributeValue is a DynamoDB attribute value in its JSON form, e.g. {"N": "12.5"}
+type attributeValue map[string]string
+
+type update struct {
+	Key                       map[string]attributeValue
+	UpdateExpression          string
+	ConditionExpression       string
+	ExpressionAttributeNames  map[string]string
+	ExpressionAttributeValues map[string]attributeValue
+}
+
+// fakeDynamo is a local stand-in of DynamoDB, applying the updates of the rollups to its items. It only supports
+// the expressions built by the rollups, and ignores the transactions repeating a client request token, like DynamoDB.
+type fakeDynamo struct {
+	mutex sync.Mutex
+	// items per key, e.g. "Rollup#DeviceId#1001 Hour#1709294400#TypeTemperature"
+	items  map[string]map[string]attributeValue
+	tokens map[string]bool
+	// number of the next transactions applied, but answered by an error as if the response was lost
+	loseTransactions int
+	// device whose updates fail
+	failDevice int64
+}
+
+var conditionPattern = regexp.MustCompile(`^\(attribute_not_exists \((#\d+)\)\) OR \((#\d+) (<|>|<=) (:\d+)\)$`)
+
+func newFakeDynamo() *fakeDynamo {
+	return &fakeDynamo{items: map[string]map[string]attributeValue{}, tokens: map[string]bool{}}
+}
+
+func (f *fakeDynamo) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+
+	switch r.Header.Get("X-Amz-Target") {
+	case "DynamoDB_20120810.UpdateItem":
+		input := update{}
+		json.NewDecoder(r.Body).Decode(&input)
+		if f.fails(input) {
+			f.error(w, http.StatusInternalServerError, "InternalServerError")
+			return
+		}
+		if !f.holds(input) {
+			f.error(w, http.StatusBadRequest, "ConditionalCheckFailedException")
+			return
+		}
+		f.apply(input)
+	case "DynamoDB_20120810.TransactWriteItems":
+		input := struct {
+			TransactItems      []struct{ Update update }
+			ClientRequestToken string
+		}{}
+		json.NewDecoder(r.Body).Decode(&input)
+		for _, item := range input.TransactItems {
+			if f.fails(item.Update) {
+				f.error(w, http.StatusInternalServerError, "InternalServerError")
+				return
+			}
+		}
+		if !f.tokens[input.ClientRequestToken] {
+			f.tokens[input.ClientRequestToken] = true
+			for _, item := range input.TransactItems {
+				f.apply(item.Update)
+			}
+		}
+		if f.loseTransactions > 0 {
+			f.loseTransactions--
+			f.error(w, http.StatusInternalServerError, "InternalServerError")
+			return
+		}
+	default:
+		f.error(w, http.StatusBadRequest, "ValidationException")
+		return
+	}
+	fmt.Fprint(w, `{}`)
+}
+
+func (f *fakeDynamo) error(w http.ResponseWriter, status int, errorType string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `{"__type": "com.amazonaws.dynamodb.v20120810#%s", "message": "failed"}`, errorType)
+}
+
+func (f *fakeDynamo) fails(input update) bool {
+	return f.failDevice != 0 && input.Key["PK"]["S"] == fmt.Sprintf("Rollup#DeviceId#%d", f.failDevice)
+}
+
+func itemKey(key map[string]attributeValue) string {
+	return key["PK"]["S"] + " " + key["SK"]["S"]
+}
+
+// holds evaluates the condition of that update, of the form (attribute_not_exists (#0)) OR (#0 > :0)
+func (f *fakeDynamo) holds(input update) bool {
+	if input.ConditionExpression == "" {
+		return true
+	}
+	match := conditionPattern.FindStringSubmatch(input.ConditionExpression)
+	if match == nil {
+		panic("unsupported condition " + input.ConditionExpression)
+	}
+	current, ok := f.items[itemKey(input.Key)][input.ExpressionAttributeNames[match[2]]]
+	if !ok {
+		return true
+	}
+	stored, _ := strconv.ParseFloat(current["N"], 64)
+	value, _ := strconv.ParseFloat(input.ExpressionAttributeValues[match[4]]["N"], 64)
+	switch match[3] {
+	case ">":
+		return stored > value
+	case "<":
+		return stored < value
+	default:
+		return stored <= value
+	}
+}
+
+// apply applies the SET and ADD clauses of that update
+func (f *fakeDynamo) apply(input update) {
+	key := itemKey(input.Key)
+	item, ok := f.items[key]
+	if !ok {
+		item = map[string]attributeValue{"PK": input.Key["PK"], "SK": input.Key["SK"]}
+		f.items[key] = item
+	}
+	for _, clause := range strings.Split(strings.TrimSpace(input.UpdateExpression), "\n") {
+		action, assignments, _ := strings.Cut(clause, " ")
+		for _, assignment := range strings.Split(assignments, ", ") {
+			name, value, _ := strings.Cut(strings.Replace(assignment, " = ", " ", 1), " ")
+			attribute := input.ExpressionAttributeNames[name]
+			switch action {
+			case "SET":
+				item[attribute] = input.ExpressionAttributeValues[value]
+			case "ADD":
+				total, _ := strconv.ParseFloat(item[attribute]["N"], 64)
+				increment, _ := strconv.ParseFloat(input.ExpressionAttributeValues[value]["N"], 64)
+				item[attribute] = attributeValue{"N": strconv.FormatFloat(total+increment, 'f', -1, 64)}
+			default:
+				panic("unsupported update " + input.UpdateExpression)
+			}
+		}
+	}
+}
+
+// rollup returns the numeric attributes of the rollup of that key
+func (f *fakeDynamo) rollup(deviceId int64, granularity string, start time.Time, eventType string) map[string]float64 {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	rollup := map[string]float64{}
+	for attribute, value := range f.items[fmt.Sprintf("Rollup#DeviceId#%d %s#%d#Type%s", deviceId, granularity, start.Unix(), eventType)] {
+		if number, ok := value["N"]; ok {
+			rollup[attribute], _ = strconv.ParseFloat(number, 64)
+		}
+	}
+	return rollup
+}
+
+// withFakeDynamo points the rollups to a local stand-in of DynamoDB
+func withFakeDynamo(t *testing.T, fake *fakeDynamo) {
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	dynamodbClient = dynamodb.New(dynamodb.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "test", SecretAccessKey: "test"}, nil
+		}),
+		RetryMaxAttempts: 1,
+	})
+	dynamoTable = aws.String("weather")
+}
+
+func checkRollup(t *testing.T, rollup map[string]float64, expected map[string]float64) {
+	t.Helper()
+	for attribute, value := range expected {
+		if rollup[attribute] != value {
+			t.Errorf("%s is %v, expected %v (rollup %v)", attribute, rollup[attribute], value, rollup)
+		}
+	}
+}
+
+func TestAddToRollups(t *testing.T) {
+	fake := newFakeDynamo()
+	withFakeDynamo(t, fake)
+	hour := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	// out of order, as when the stream shards are read concurrently
+	readings := []struct {
+		minutes int
+		value   float64
+	}{{5, 12}, {1, 15}, {3, 9}, {4, 11}, {65, 20}}
+	for i, reading := range readings {
+		event := weather_generator.WeatherEvent{DeviceId: 1001, Time: hour.Add(time.Duration(reading.minutes) * time.Minute), EventType: "Temperature", Value: reading.value}
+		if err := addToRollups(context.Background(), event, fmt.Sprint("record-", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checkRollup(t, fake.rollup(1001, "Hour", hour, "Temperature"), map[string]float64{
+		"Count": 4, "Sum": 47, "Min": 9, "Max": 15, "Last": 12, "LastTime": float64(hour.Add(5 * time.Minute).Unix()), "Start": float64(hour.Unix()),
+	})
+	checkRollup(t, fake.rollup(1001, "Hour", hour.Add(time.Hour), "Temperature"), map[string]float64{
+		"Count": 1, "Sum": 20, "Min": 20, "Max": 20, "Last": 20,
+	})
+	checkRollup(t, fake.rollup(1001, "Day", hour.Truncate(24*time.Hour), "Temperature"), map[string]float64{
+		"Count": 5, "Sum": 67, "Min": 9, "Max": 20, "Last": 20, "LastTime": float64(hour.Add(65 * time.Minute).Unix()),
+	})
+}
+
+func TestAddToRollupsCountsRetriedRecordOnce(t *testing.T) {
+	fake := newFakeDynamo()
+	withFakeDynamo(t, fake)
+	hour := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	event := weather_generator.WeatherEvent{DeviceId: 1001, Time: hour, EventType: "Temperature", Value: 12}
+
+	// the totals are updated, but the response is lost
+	fake.loseTransactions = 1
+	if err := addToRollups(context.Background(), event, "record-1"); err == nil {
+		t.Fatal("no error, expected the record to be retried")
+	}
+	if err := addToRollups(context.Background(), event, "record-1"); err != nil {
+		t.Fatal(err)
+	}
+	checkRollup(t, fake.rollup(1001, "Hour", hour, "Temperature"), map[string]float64{"Count": 1, "Sum": 12, "Min": 12, "Max": 12, "Last": 12})
+
+	// another record of the same reading is counted
+	if err := addToRollups(context.Background(), event, "record-2"); err != nil {
+		t.Fatal(err)
+	}
+	checkRollup(t, fake.rollup(1001, "Hour", hour, "Temperature"), map[string]float64{"Count": 2, "Sum": 24})
+
+	if idempotencyToken("record-1") == idempotencyToken("record-2") || len(idempotencyToken("record-1")) > 36 {
+		t.Errorf("tokens %s and %s, expected distinct tokens of at most 36 characters", idempotencyToken("record-1"), idempotencyToken("record-2"))
+	}
+}
+
+// change is the record of the Kinesis data stream of the table for that change of a weather event
+func change(sequenceNumber string, eventName string, deviceId int64, eventTime time.Time, value float64) events.KinesisEventRecord {
+	data, _ := json.Marshal(map[string]any{
+		"eventID":   "id-" + sequenceNumber,
+		"eventName": eventName,
+		"dynamodb": map[string]any{
+			"NewImage": map[string]events.DynamoDBAttributeValue{
+				"DeviceId":  events.NewNumberAttribute(strconv.FormatInt(deviceId, 10)),
+				"Time":      events.NewNumberAttribute(strconv.FormatInt(eventTime.Unix(), 10)),
+				"EventType": events.NewStringAttribute("Temperature"),
+				"Value":     events.NewNumberAttribute(strconv.FormatFloat(value, 'f', -1, 64)),
+			},
+		},
+	})
+	return events.KinesisEventRecord{Kinesis: events.KinesisRecord{SequenceNumber: sequenceNumber, Data: data}}
+}
+
+func TestHandlerRetriesFromFailedRecord(t *testing.T) {
+	fake := newFakeDynamo()
+	fake.failDevice = 1002
+	withFakeDynamo(t, fake)
+	hour := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	response, err := handler(context.Background(), events.KinesisEvent{Records: []events.KinesisEventRecord{
+		{Kinesis: events.KinesisRecord{SequenceNumber: "100", Data: []byte("not json")}},
+		change("101", "MODIFY", 1001, hour, 30),
+		change("102", "INSERT", 1001, hour, 10),
+		change("103", "INSERT", 1002, hour, 11),
+		change("104", "INSERT", 1001, hour.Add(time.Minute), 12),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.BatchItemFailures) != 1 || response.BatchItemFailures[0].ItemIdentifier != "103" {
+		t.Errorf("failures %v, expected a retry from record 103", response.BatchItemFailures)
+	}
+	// the records following the failed one are retried with it
+	checkRollup(t, fake.rollup(1001, "Hour", hour, "Temperature"), map[string]float64{"Count": 1, "Sum": 10, "Max": 10})
+}
@@ -0,0 +1,232 @@
+// Mapping of the MQTT messages to weather events, and their buffering until written to DynamoDB.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"weather_data_generator/weather_generator"
+)
+
+type WeatherEvent = weather_generator.WeatherEvent
+
+// eventWriter writes weather events to DynamoDB, as weather_storage.Writer does
+type eventWriter interface {
+	AddAll(ctx context.Context, weatherEvents []WeatherEvent) error
+}
+
+// ReadingPayload is the JSON payload of a reading, e.g. {"Time": "2024-03-01T12:00:00Z", "Value": 21.5, "Unit": "°C"}.
+// Time defaults to the reception time and Unit to the metric unit of the event type. A bare number is also
+// accepted as payload, as a reading received now.
+type ReadingPayload struct {
+	Time  *time.Time
+	Value *float64
+	Unit  string
+}
+
+type pendingReading struct {
+	event   WeatherEvent
+	message mqtt.Message
+}
+
+// readingKey identifies a weather event in DynamoDB: a batch may not contain twice the same key
+type readingKey struct {
+	deviceId  int64
+	unixTime  int64
+	eventType string
+}
+
+// Bridge buffers the readings received from the broker and writes them to DynamoDB in batches,
+// acknowledging the messages once written.
+type Bridge struct {
+	writer        eventWriter
+	batchSize     int
+	maxBuffered   int
+	flushInterval time.Duration
+
+	incoming chan pendingReading
+	stopped  chan struct{}
+
+	// delivery metrics
+	received      atomic.Int64
+	invalid       atomic.Int64
+	dropped       atomic.Int64
+	written       atomic.Int64
+	writeFailures atomic.Int64
+	buffered      atomic.Int64
+	lastWrite     atomic.Int64
+}
+
+func newBridge(writer eventWriter, batchSize, maxBuffered int, flushInterval time.Duration) *Bridge {
+	return &Bridge{
+		writer:        writer,
+		batchSize:     batchSize,
+		maxBuffered:   maxBuffered,
+		flushInterval: flushInterval,
+		incoming:      make(chan pendingReading),
+		stopped:       make(chan struct{}),
+	}
+}
+
+// onMessage is the MQTT message handler: it hands the parsed readings over to the run loop
+func (b *Bridge) onMessage(_ mqtt.Client, message mqtt.Message) {
+	b.received.Add(1)
+	event, err := parseReading(message.Topic(), message.Payload(), time.Now())
+	if err != nil {
+		// acknowledged anyway, since it would be just as invalid when re-delivered
+		log.Printf("skipping invalid reading on %s: %v", message.Topic(), err)
+		b.invalid.Add(1)
+		message.Ack()
+		return
+	}
+
+	select {
+	case b.incoming <- pendingReading{event: event, message: message}:
+	case <-b.stopped:
+		// not acknowledged: re-delivered to the next session
+	}
+}
+
+// run buffers the incoming readings and writes them when the buffer is full or at each flush interval,
+// until the context is cancelled. Readings whose write failed stay buffered, to be retried at the next flush.
+func (b *Bridge) run(ctx context.Context) {
+	buffer := []pendingReading{}
+	index := map[readingKey]int{}
+	ticker := time.NewTicker(b.flushInterval)
+	defer ticker.Stop()
+
+	flush := func(ctx context.Context) {
+		if len(buffer) > 0 && b.write(ctx, buffer) {
+			buffer = buffer[:0]
+			clear(index)
+		}
+		b.buffered.Store(int64(len(buffer)))
+	}
+
+	for {
+		select {
+		case pending := <-b.incoming:
+			key := readingKey{pending.event.DeviceId, pending.event.Time.Unix(), pending.event.EventType}
+			if i, ok := index[key]; ok {
+				// the latest reading supersedes the buffered one
+				buffer[i].message.Ack()
+				buffer[i] = pending
+				continue
+			}
+			if len(buffer) >= b.maxBuffered {
+				// acknowledged anyway, since an unacknowledged message would hold one of the in-flight
+				// slots of the broker until the next session
+				log.Printf("buffer full, dropping reading on %s", pending.message.Topic())
+				b.dropped.Add(1)
+				pending.message.Ack()
+				continue
+			}
+			index[key] = len(buffer)
+			buffer = append(buffer, pending)
+			b.buffered.Store(int64(len(buffer)))
+			if len(buffer) >= b.batchSize {
+				flush(ctx)
+			}
+
+		case <-ticker.C:
+			flush(ctx)
+
+		case <-ctx.Done():
+			close(b.stopped)
+			log.Printf("stopping, writing the %d buffered readings", len(buffer))
+			finalCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			flush(finalCtx)
+			cancel()
+			return
+		}
+	}
+}
+
+// write writes those readings to DynamoDB and acknowledges their messages if successful
+func (b *Bridge) write(ctx context.Context, readings []pendingReading) bool {
+	events := make([]WeatherEvent, 0, len(readings))
+	for _, pending := range readings {
+		events = append(events, pending.event)
+	}
+
+	if err := b.writer.AddAll(ctx, events); err != nil {
+		log.Printf("failed to write %d readings, keeping them buffered: %v", len(events), err)
+		b.writeFailures.Add(1)
+		return false
+	}
+	for _, pending := range readings {
+		pending.message.Ack()
+	}
+	b.written.Add(int64(len(events)))
+	b.lastWrite.Store(time.Now().Unix())
+	log.Printf("wrote %d readings", len(events))
+	return true
+}
+
+// metrics are published through expvar
+func (b *Bridge) metrics() any {
+	metrics := map[string]any{
+		"Received":      b.received.Load(),
+		"Invalid":       b.invalid.Load(),
+		"Dropped":       b.dropped.Load(),
+		"Written":       b.written.Load(),
+		"WriteFailures": b.writeFailures.Load(),
+		"Buffered":      b.buffered.Load(),
+	}
+	if lastWrite := b.lastWrite.Load(); lastWrite > 0 {
+		metrics["LastWrite"] = time.Unix(lastWrite, 0).UTC()
+	}
+	return metrics
+}
+
+// parseReading maps a message published on stations/<device id>/<event type> to a weather event
+func parseReading(topic string, payload []byte, now time.Time) (WeatherEvent, error) {
+	levels := strings.Split(topic, "/")
+	if len(levels) < 2 {
+		return WeatherEvent{}, errors.New("topic should end with /<device id>/<event type>")
+	}
+	deviceId, err := strconv.ParseInt(levels[len(levels)-2], 10, 64)
+	if err != nil || deviceId <= 0 {
+		return WeatherEvent{}, fmt.Errorf("invalid device id %q", levels[len(levels)-2])
+	}
+	eventType, ok := weather_generator.LookupEventType(levels[len(levels)-1])
+	if !ok {
+		return WeatherEvent{}, fmt.Errorf("unknown event type %q", levels[len(levels)-1])
+	}
+
+	reading := ReadingPayload{}
+	if value, err := strconv.ParseFloat(string(bytes.TrimSpace(payload)), 64); err == nil {
+		reading.Value = &value
+	} else if err := json.Unmarshal(payload, &reading); err != nil {
+		return WeatherEvent{}, fmt.Errorf("payload should be a number or a JSON reading: %w", err)
+	}
+	if reading.Value == nil || math.IsNaN(*reading.Value) || math.IsInf(*reading.Value, 0) {
+		return WeatherEvent{}, errors.New("missing or invalid Value")
+	}
+	if reading.Unit != "" && reading.Unit != eventType.Unit {
+		return WeatherEvent{}, fmt.Errorf("invalid Unit: %s should be expressed in %s", eventType.Name, eventType.Unit)
+	}
+	readingTime := now.Truncate(time.Second)
+	if reading.Time != nil {
+		readingTime = *reading.Time
+	}
+
+	return WeatherEvent{
+		DeviceId:  deviceId,
+		Time:      readingTime,
+		EventType: eventType.Name,
+		Value:     *reading.Value,
+		Unit:      eventType.Unit,
+	}, nil
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	broker "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// fakeWriter records the written batches, failing while fail is set
+type fakeWriter struct {
+	mutex   sync.Mutex
+	batches [][]WeatherEvent
+	fail    bool
+	// number of failed writes
+	failures int
+}
+
+func (w *fakeWriter) AddAll(_ context.Context, weatherEvents []WeatherEvent) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.fail {
+		w.failures++
+		return errors.New("throttled")
+	}
+	w.batches = append(w.batches, append([]WeatherEvent{}, weatherEvents...))
+	return nil
+}
+
+func (w *fakeWriter) setFail(fail bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.fail = fail
+}
+
+func (w *fakeWriter) failedWrites() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.failures
+}
+
+func (w *fakeWriter) written() [][]WeatherEvent {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return append([][]WeatherEvent{}, w.batches...)
+}
+
+// ackCounter counts the acknowledgments (PUBACK) of the messages delivered by the broker to the bridge
+type ackCounter struct {
+	broker.HookBase
+	mutex sync.Mutex
+	acks  int
+}
+
+func (h *ackCounter) ID() string {
+	return "ack-counter"
+}
+
+func (h *ackCounter) Provides(b byte) bool {
+	return b == broker.OnQosComplete
+}
+
+func (h *ackCounter) OnQosComplete(cl *broker.Client, pk packets.Packet) {
+	if cl.ID != bridgeClientId {
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.acks++
+}
+
+func (h *ackCounter) count() int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.acks
+}
+
+const bridgeClientId = "weather_mqtt_bridge_test"
+
+// startBroker runs an in-process MQTT broker, returning its URL
+func startBroker(t *testing.T) (string, *ackCounter) {
+	server := broker.New(&broker.Options{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+	acks := &ackCounter{}
+	for _, hook := range []broker.Hook{&auth.AllowHook{}, acks} {
+		if err := server.AddHook(hook, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.AddListener(listeners.NewNet("tcp", listener)); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Serve(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return "tcp://" + listener.Addr().String(), acks
+}
+
+// startBridge connects a bridge to the broker, running it until the returned function is called
+func startBridge(t *testing.T, brokerUrl string, bridge *Bridge) func() {
+	client := mqtt.NewClient(clientOptions(brokerUrl, bridgeClientId, "", "", "stations/+/+", bridge))
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		bridge.run(ctx)
+		close(done)
+	}()
+	stopped := false
+	stop := func() {
+		if !stopped {
+			stopped = true
+			cancel()
+			<-done
+			client.Disconnect(100)
+		}
+	}
+	t.Cleanup(stop)
+	return stop
+}
+
+// publish publishes those payloads (QoS 1) on their topics, from a station
+func publish(t *testing.T, brokerUrl string, messages ...[2]string) {
+	client := mqtt.NewClient(mqtt.NewClientOptions().AddBroker(brokerUrl).SetClientID("station"))
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+	defer client.Disconnect(100)
+	for _, message := range messages {
+		if token := client.Publish(message[0], 1, false, message[1]); token.Wait() && token.Error() != nil {
+			t.Fatal(token.Error())
+		}
+	}
+}
+
+func reading(deviceId int64, eventType string, minute int, value float64) [2]string {
+	readingTime := time.Date(2024, 3, 1, 12, minute, 0, 0, time.UTC)
+	return [2]string{
+		fmt.Sprintf("stations/%d/%s", deviceId, eventType),
+		fmt.Sprintf(`{"Time": %q, "Value": %v}`, readingTime.Format(time.RFC3339), value),
+	}
+}
+
+// eventually waits for that condition to hold
+func eventually(t *testing.T, description string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", description)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestBridgeWritesBatches(t *testing.T) {
+	brokerUrl, acks := startBroker(t)
+	writer := &fakeWriter{}
+	startBridge(t, brokerUrl, newBridge(writer, 3, 100, time.Hour))
+
+	publish(t, brokerUrl,
+		reading(1001, "Temperature", 0, 20),
+		reading(1001, "Humidity", 0, 60),
+		reading(1002, "Temperature", 0, 18),
+		reading(1001, "Temperature", 1, 21),
+		reading(1001, "Humidity", 1, 61),
+		reading(1002, "Temperature", 1, 19),
+	)
+
+	eventually(t, "2 batches", func() bool { return len(writer.written()) == 2 })
+	for i, batch := range writer.written() {
+		if len(batch) != 3 {
+			t.Errorf("batch %d of %d readings, expected 3", i, len(batch))
+		}
+	}
+	eventually(t, "6 acks", func() bool { return acks.count() == 6 })
+}
+
+func TestBridgeFlushesAtInterval(t *testing.T) {
+	brokerUrl, acks := startBroker(t)
+	writer := &fakeWriter{}
+	startBridge(t, brokerUrl, newBridge(writer, 20, 100, 50*time.Millisecond))
+
+	publish(t, brokerUrl, reading(1001, "Temperature", 0, 20))
+
+	eventually(t, "flush", func() bool { return len(writer.written()) == 1 })
+	eventually(t, "1 ack", func() bool { return acks.count() == 1 })
+}
+
+func TestBridgeDeduplicatesSupersededReadings(t *testing.T) {
+	brokerUrl, acks := startBroker(t)
+	writer := &fakeWriter{}
+	startBridge(t, brokerUrl, newBridge(writer, 2, 100, time.Hour))
+
+	publish(t, brokerUrl,
+		reading(1001, "Temperature", 0, 20),
+		reading(1001, "Temperature", 0, 22),
+		reading(1001, "Humidity", 0, 60),
+	)
+
+	eventually(t, "1 batch", func() bool { return len(writer.written()) == 1 })
+	batch := writer.written()[0]
+	if len(batch) != 2 {
+		t.Fatalf("batch of %d readings, expected 2", len(batch))
+	}
+	if batch[0].EventType != "Temperature" || batch[0].Value != 22 {
+		t.Errorf("first reading %+v, expected the superseding Temperature of 22", batch[0])
+	}
+	// the superseded reading is acknowledged as well
+	eventually(t, "3 acks", func() bool { return acks.count() == 3 })
+}
+
+func TestBridgeAcksOnlyWrittenReadings(t *testing.T) {
+	brokerUrl, acks := startBroker(t)
+	writer := &fakeWriter{fail: true}
+	startBridge(t, brokerUrl, newBridge(writer, 2, 100, 50*time.Millisecond))
+
+	publish(t, brokerUrl, reading(1001, "Temperature", 0, 20), reading(1001, "Humidity", 0, 60))
+
+	eventually(t, "failed writes", func() bool { return writer.failedWrites() >= 2 })
+	if acks.count() != 0 {
+		t.Fatalf("%d acks, expected none before the readings are written", acks.count())
+	}
+
+	// the buffered readings are retried at the next flush
+	writer.setFail(false)
+	eventually(t, "1 batch", func() bool { return len(writer.written()) == 1 })
+	eventually(t, "2 acks", func() bool { return acks.count() == 2 })
+}
+
+func TestBridgeRedeliveryAfterFailedWrite(t *testing.T) {
+	brokerUrl, acks := startBroker(t)
+	failingWriter := &fakeWriter{fail: true}
+	stop := startBridge(t, brokerUrl, newBridge(failingWriter, 2, 100, time.Hour))
+
+	publish(t, brokerUrl, reading(1001, "Temperature", 0, 20), reading(1001, "Humidity", 0, 60))
+	eventually(t, "failed write", func() bool { return failingWriter.failedWrites() >= 1 })
+	// stopping with the readings still buffered, since the final write fails as well
+	stop()
+	if acks.count() != 0 {
+		t.Fatalf("%d acks, expected none", acks.count())
+	}
+
+	// the broker re-delivers the unacknowledged messages to the next session
+	writer := &fakeWriter{}
+	startBridge(t, brokerUrl, newBridge(writer, 2, 100, time.Hour))
+	eventually(t, "1 batch", func() bool { return len(writer.written()) == 1 })
+	if batch := writer.written()[0]; len(batch) != 2 {
+		t.Errorf("batch of %d readings, expected the 2 re-delivered ones", len(batch))
+	}
+	eventually(t, "2 acks", func() bool { return acks.count() == 2 })
+}
+
+func TestBridgeAcksDroppedReadings(t *testing.T) {
+	brokerUrl, acks := startBroker(t)
+	writer := &fakeWriter{fail: true}
+	bridge := newBridge(writer, 2, 2, time.Hour)
+	startBridge(t, brokerUrl, bridge)
+
+	publish(t, brokerUrl,
+		reading(1001, "Temperature", 0, 20),
+		reading(1001, "Humidity", 0, 60),
+		reading(1001, "Pressure", 0, 1013),
+	)
+
+	eventually(t, "dropped reading", func() bool { return bridge.dropped.Load() == 1 })
+	// only the dropped reading is acknowledged, the buffered ones waiting to be written
+	eventually(t, "1 ack", func() bool { return acks.count() == 1 })
+}
@@ -0,0 +1,104 @@
+// Long running app subscribing to the readings published by the weather stations on an MQTT broker,
+// and writing them to DynamoDB in batches.
+package main
+
+import (
+	"context"
+	"expvar"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"weather_data_generator/weather_storage"
+)
+
+/*
+	./weather_mqtt_bridge \
+		-broker tcp://localhost:1883 \
+		-topic 'stations/+/+' \
+		-table weather-api-demo-WeatherDynamoTable-XXXXXXXX \
+		-metricsAddr :9090
+*/
+func main() {
+	brokerUrl := flag.String("broker", "tcp://localhost:1883", "URL of the MQTT broker")
+	topic := flag.String("topic", "stations/+/+", "MQTT topic filter of the readings, as stations/<device id>/<event type>")
+	clientId := flag.String("clientId", "weather_mqtt_bridge", "MQTT client id, also identifying the persistent session on the broker")
+	username := flag.String("username", "", "MQTT username, if any")
+	password := flag.String("password", "", "MQTT password, if any")
+	table := flag.String("table", os.Getenv("DYNAMO_TABLE"), "DynamoDB table of the weather events (defaults to the DYNAMO_TABLE env var)")
+	retentionDays := flag.Int("retentionDays", 0, "Number of days before the written events expire, 0 to keep them forever")
+	batchSize := flag.Int("batchSize", 20, "Number of buffered readings triggering a write to DynamoDB, at most -maxInflight")
+	maxInflight := flag.Int("maxInflight", 20, "Max number of unacknowledged QoS 1 messages the broker delivers (max_inflight_messages of mosquitto, 20 by default)")
+	flushInterval := flag.Duration("flushInterval", 5*time.Second, "Max duration readings stay buffered before being written to DynamoDB")
+	maxBuffered := flag.Int("maxBuffered", 10000, "Max number of buffered readings, above which new readings are dropped until DynamoDB accepts writes again")
+	metricsAddr := flag.String("metricsAddr", ":9090", "Address of the HTTP server exposing the delivery metrics on /debug/vars, empty to disable")
+	if flag.Parse(); len(*table) == 0 || *batchSize < 1 || *maxBuffered < *batchSize {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *batchSize > *maxInflight {
+		// the broker stops delivering messages until the buffered ones are acknowledged, i.e. written
+		log.Printf("capping -batchSize %d to the %d in-flight messages of the broker", *batchSize, *maxInflight)
+		*batchSize = *maxInflight
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	sdkConfig, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		log.Fatal("Could not connect to AWS API ", err)
+	}
+	writer := weather_storage.NewWriter(dynamodb.NewFromConfig(sdkConfig), *table, time.Duration(*retentionDays)*24*time.Hour)
+	bridge := newBridge(writer, *batchSize, *maxBuffered, *flushInterval)
+
+	if *metricsAddr != "" {
+		// expvar registers /debug/vars on the default mux
+		expvar.Publish("bridge", expvar.Func(bridge.metrics))
+		go func() {
+			log.Println("exposing metrics on", *metricsAddr)
+			log.Println(http.ListenAndServe(*metricsAddr, nil))
+		}()
+	}
+
+	options := clientOptions(*brokerUrl, *clientId, *username, *password, *topic, bridge)
+	client := mqtt.NewClient(options)
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		log.Fatal("Could not connect to MQTT broker ", token.Error())
+	}
+
+	bridge.run(ctx)
+
+	log.Println("disconnecting from the broker")
+	client.Disconnect(1000)
+}
+
+// clientOptions configures the connection to the broker, subscribing that bridge to the topic once connected.
+// Messages are only acknowledged once written to DynamoDB, such that the broker re-delivers
+// them (within the persistent session) if the bridge stops before that.
+func clientOptions(brokerUrl, clientId, username, password, topic string, bridge *Bridge) *mqtt.ClientOptions {
+	return mqtt.NewClientOptions().
+		AddBroker(brokerUrl).
+		SetClientID(clientId).
+		SetUsername(username).
+		SetPassword(password).
+		SetCleanSession(false).
+		SetAutoAckDisabled(true).
+		SetOnConnectHandler(func(client mqtt.Client) {
+			log.Println("connected to", brokerUrl, ", subscribing to", topic)
+			if token := client.Subscribe(topic, 1, bridge.onMessage); token.Wait() && token.Error() != nil {
+				log.Println("failed to subscribe", token.Error())
+			}
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.Println("connection to the broker lost, reconnecting", err)
+		})
+}
@@ -0,0 +1,364 @@
+// Subcommands of the CLI
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"weather_rest_client/weather_client"
+)
+
+// periodFlags select the queried period, either the last timeDelta minutes or from/to
+type periodFlags struct {
+	timeDelta *int
+	from      *string
+	to        *string
+}
+
+func addPeriodFlags(flags *flag.FlagSet, defaultTimeDelta int) periodFlags {
+	return periodFlags{
+		timeDelta: flags.Int("timeDelta", defaultTimeDelta, "Duration in minutes of the queried period, ending now"),
+		from:      flags.String("from", "", "Start of the queried period, as RFC 3339 (e.g. 2024-03-01T12:00:00Z), instead of -timeDelta"),
+		to:        flags.String("to", "", "End of the queried period, as RFC 3339, now by default"),
+	}
+}
+
+// period returns the start and end of the queried period
+func (f periodFlags) period() (time.Time, time.Time, error) {
+	toTime := time.Now()
+	if len(*f.to) > 0 {
+		parsed, err := time.Parse(time.RFC3339, *f.to)
+		if err != nil {
+			return time.Time{}, time.Time{}, usageErrorf("invalid -to %q, expected RFC 3339", *f.to)
+		}
+		toTime = parsed
+	}
+
+	if len(*f.from) > 0 {
+		fromTime, err := time.Parse(time.RFC3339, *f.from)
+		if err != nil {
+			return time.Time{}, time.Time{}, usageErrorf("invalid -from %q, expected RFC 3339", *f.from)
+		}
+		return fromTime, toTime, nil
+	}
+	if *f.timeDelta <= 0 {
+		return time.Time{}, time.Time{}, usageErrorf("invalid -timeDelta %d, expected a positive number of minutes", *f.timeDelta)
+	}
+	return toTime.Add(-time.Duration(*f.timeDelta) * time.Minute), toTime, nil
+}
+
+func addDeviceFlag(flags *flag.FlagSet) *int {
+	return flags.Int("deviceId", -1, "Id of the device (required)")
+}
+
+func checkDeviceId(deviceId int) error {
+	if deviceId < 0 {
+		return usageErrorf("missing -deviceId")
+	}
+	return nil
+}
+
+func addDeviceIdsFlag(flags *flag.FlagSet) *string {
+	return flags.String("deviceIds", "", "Comma-separated list of device ids or ranges of ids, e.g. 1001,1003-1005 (required)")
+}
+
+// parseDeviceIds parses a comma-separated list of device ids or ranges of ids, such as 1001,1003-1005
+func parseDeviceIds(deviceIds string) ([]int, error) {
+	if len(deviceIds) == 0 {
+		return nil, usageErrorf("missing -deviceIds")
+	}
+	ids := []int{}
+	for _, part := range strings.Split(deviceIds, ",") {
+		firstStr, lastStr, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		first, err1 := strconv.Atoi(firstStr)
+		last, err2 := first, error(nil)
+		if isRange {
+			last, err2 = strconv.Atoi(lastStr)
+		}
+		if err1 != nil || err2 != nil || first < 0 || last < first {
+			return nil, usageErrorf("invalid device id or range %q in -deviceIds", part)
+		}
+		for id := first; id <= last; id++ {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func addEventTypesFlag(flags *flag.FlagSet) *string {
+	return flags.String("eventTypes", "", "Comma-separated list of event types to return, e.g. Temperature,PM25. All of them by default")
+}
+
+func splitEventTypes(eventTypes string) []string {
+	if len(eventTypes) == 0 {
+		return nil
+	}
+	return strings.Split(eventTypes, ",")
+}
+
+func setupQuery(flags *flag.FlagSet) runner {
+	deviceId := addDeviceFlag(flags)
+	period := addPeriodFlags(flags, 10)
+	eventTypes := addEventTypesFlag(flags)
+
+	return func(ctx context.Context, client weather_client.WeatherClient, out *printer) error {
+		if err := checkDeviceId(*deviceId); err != nil {
+			return err
+		}
+		fromTime, toTime, err := period.period()
+		if err != nil {
+			return err
+		}
+
+		events, err := client.QueryEvents(ctx, *deviceId, fromTime, toTime, splitEventTypes(*eventTypes)...)
+		if err != nil {
+			return err
+		}
+		return out.printEvents(events)
+	}
+}
+
+func setupLatest(flags *flag.FlagSet) runner {
+	deviceId := addDeviceFlag(flags)
+	lookback := flags.Duration("lookback", time.Hour, "How far back to look for the latest readings")
+	eventTypes := addEventTypesFlag(flags)
+
+	return func(ctx context.Context, client weather_client.WeatherClient, out *printer) error {
+		if err := checkDeviceId(*deviceId); err != nil {
+			return err
+		}
+
+		events, err := client.QueryLatestEvents(ctx, *deviceId, *lookback, splitEventTypes(*eventTypes)...)
+		if err != nil {
+			return err
+		}
+		return out.printEvents(events)
+	}
+}
+
+func setupAggregate(flags *flag.FlagSet) runner {
+	deviceId := addDeviceFlag(flags)
+	period := addPeriodFlags(flags, 24*60)
+	eventTypes := addEventTypesFlag(flags)
+	bucket := flags.Duration("bucket", time.Hour, "Size of the time buckets, between 1m and 744h (31 days)")
+
+	return func(ctx context.Context, client weather_client.WeatherClient, out *printer) error {
+		if err := checkDeviceId(*deviceId); err != nil {
+			return err
+		}
+		fromTime, toTime, err := period.period()
+		if err != nil {
+			return err
+		}
+
+		aggregates, err := client.QueryAggregates(ctx, *deviceId, fromTime, toTime, *bucket, splitEventTypes(*eventTypes)...)
+		if err != nil {
+			return err
+		}
+		return out.printAggregates(aggregates)
+	}
+}
+
+func setupDevices(flags *flag.FlagSet) runner {
+	near := flags.String("near", "", "lat,lon location around which to look for devices, instead of listing all of them")
+	radiusKm := flags.Float64("radiusKm", 25, "Radius in km of the -near lookup")
+
+	return func(ctx context.Context, client weather_client.WeatherClient, out *printer) error {
+		if len(*near) == 0 {
+			devices, err := client.ListDevices(ctx)
+			if err != nil {
+				return err
+			}
+			return out.printDevices(devices)
+		}
+
+		latStr, lonStr, found := strings.Cut(*near, ",")
+		latitude, err1 := strconv.ParseFloat(strings.TrimSpace(latStr), 64)
+		longitude, err2 := strconv.ParseFloat(strings.TrimSpace(lonStr), 64)
+		if !found || err1 != nil || err2 != nil {
+			return usageErrorf("invalid -near location %q, expected lat,lon", *near)
+		}
+
+		devices, err := client.QueryNearbyDevices(ctx, latitude, longitude, *radiusKm)
+		if err != nil {
+			return err
+		}
+		return out.printNearbyDevices(devices)
+	}
+}
+
+func setupExport(flags *flag.FlagSet) runner {
+	deviceId := addDeviceFlag(flags)
+	period := addPeriodFlags(flags, 60)
+	eventTypes := addEventTypesFlag(flags)
+	format := flags.String("format", "csv", "Format of the files: json, csv, ndjson or parquet")
+	file := flags.String("file", "", "File to write, the standard output by default")
+	deviceIds := flags.String("deviceIds", "", "Comma-separated list of device ids or ranges of ids to export into -dir, e.g. 1001-1050, instead of -deviceId")
+	dir := flags.String("dir", "", "Directory of a bulk export, partitioned by device and day, resumed if interrupted")
+	chunk := flags.Duration("chunk", 24*time.Hour, "Period of the events of each file of a bulk export, dividing a day, e.g. 1h, 6h or 24h")
+	concurrency := flags.Int("concurrency", 8, "Number of concurrent requests of a bulk export")
+
+	return func(ctx context.Context, client weather_client.WeatherClient, out *printer) error {
+		fromTime, toTime, err := period.period()
+		if err != nil {
+			return err
+		}
+		if len(*dir) > 0 {
+			ids, err := parseDeviceIds(*deviceIds)
+			if len(*deviceIds) == 0 && *deviceId >= 0 {
+				ids, err = []int{*deviceId}, nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := checkChunk(*chunk); err != nil {
+				return err
+			}
+			manifest, err := loadOrCreateManifest(*dir, exportManifest{
+				DeviceIds:  ids,
+				From:       fromTime,
+				To:         toTime,
+				EventTypes: splitEventTypes(*eventTypes),
+				Format:     *format,
+				Chunk:      chunk.String(),
+			}, len(*period.from) > 0)
+			if err != nil {
+				return err
+			}
+			return bulkExport(ctx, client, *dir, manifest, *chunk, *concurrency, out.logger)
+		}
+
+		if len(*deviceIds) > 0 {
+			return usageErrorf("-deviceIds requires -dir")
+		}
+		if err := checkDeviceId(*deviceId); err != nil {
+			return err
+		}
+		if len(*file) == 0 {
+			_, err := client.DownloadEvents(ctx, *deviceId, fromTime, toTime, *format, out.out, splitEventTypes(*eventTypes)...)
+			return err
+		}
+
+		output, err := os.Create(*file)
+		if err != nil {
+			return err
+		}
+		defer output.Close()
+		written, err := client.DownloadEvents(ctx, *deviceId, fromTime, toTime, *format, output, splitEventTypes(*eventTypes)...)
+		if err != nil {
+			return err
+		}
+		out.logger.Printf("wrote %d bytes of %s to %s", written, *format, *file)
+		return output.Close()
+	}
+}
+
+func setupWatch(flags *flag.FlagSet) runner {
+	deviceId := addDeviceFlag(flags)
+	eventTypes := addEventTypesFlag(flags)
+	interval := flags.Duration("interval", time.Minute, "Polling interval")
+
+	return func(ctx context.Context, client weather_client.WeatherClient, out *printer) error {
+		if err := checkDeviceId(*deviceId); err != nil {
+			return err
+		}
+		if *interval < time.Second {
+			return usageErrorf("invalid -interval %v, expected at least 1s", *interval)
+		}
+		out.streaming = true
+
+		// each poll also covers the previous interval, to catch the events recorded late,
+		// the events already printed being skipped
+		type eventKey struct {
+			time      time.Time
+			eventType string
+		}
+		printed := map[eventKey]bool{}
+		fromTime := time.Now().Add(-*interval)
+		ticker := time.NewTicker(*interval)
+		defer ticker.Stop()
+		for {
+			toTime := time.Now()
+			events, err := client.QueryEvents(ctx, *deviceId, fromTime, toTime, splitEventTypes(*eventTypes)...)
+			if ctx.Err() != nil {
+				return nil
+			}
+			if err != nil {
+				var problem *weather_client.ProblemError
+				if errors.As(err, &problem) && problem.Status < 500 && problem.Status != http.StatusTooManyRequests {
+					return err
+				}
+				out.logger.Println("failed to poll the API, retrying at the next interval:", err)
+			} else {
+				newEvents := []weather_client.WeatherEvent{}
+				for _, event := range events {
+					key := eventKey{time: event.Time, eventType: event.EventType}
+					if !printed[key] && !event.Time.Before(fromTime.Add(-time.Second)) {
+						printed[key] = true
+						newEvents = append(newEvents, event)
+					}
+				}
+				if err := out.printEvents(newEvents); err != nil {
+					return err
+				}
+
+				fromTime = toTime.Add(-*interval)
+				for key := range printed {
+					if key.time.Before(fromTime.Add(-time.Second)) {
+						delete(printed, key)
+					}
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+			}
+		}
+	}
+}
+
+func setupPlot(flags *flag.FlagSet) runner {
+	deviceIds := addDeviceIdsFlag(flags)
+	period := addPeriodFlags(flags, 24*60)
+	eventTypes := addEventTypesFlag(flags)
+	style := flags.String("style", "sparkline", "Rendering of each event type: sparkline or chart")
+	width := flags.Int("width", 60, "Number of columns of the sparklines and charts, each one averaging the events of its time slot")
+	height := flags.Int("height", 10, "Number of rows of the charts")
+
+	return func(ctx context.Context, client weather_client.WeatherClient, out *printer) error {
+		ids, err := parseDeviceIds(*deviceIds)
+		if err != nil {
+			return err
+		}
+		fromTime, toTime, err := period.period()
+		if err != nil {
+			return err
+		}
+		if *style != "sparkline" && *style != "chart" {
+			return usageErrorf("invalid -style %q, expected sparkline or chart", *style)
+		}
+		if *width < 1 || *height < 2 {
+			return usageErrorf("invalid -width %d or -height %d, expected at least 1 and 2", *width, *height)
+		}
+
+		events := []weather_client.WeatherEvent{}
+		for _, id := range ids {
+			deviceEvents, err := client.QueryEvents(ctx, id, fromTime, toTime, splitEventTypes(*eventTypes)...)
+			if err != nil {
+				return fmt.Errorf("failed to query the events of device %d: %w", id, err)
+			}
+			events = append(events, deviceEvents...)
+		}
+		plotEvents(out.out, events, fromTime, toTime, *style, *width, *height)
+		return nil
+	}
+}
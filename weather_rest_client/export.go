@@ -0,0 +1,242 @@
+// Bulk export of the events of several devices, as files partitioned by device and day, resumable when interrupted
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"weather_rest_client/weather_client"
+)
+
+// files of the export directory, next to the partitions
+const (
+	manifestFile = "export.json"
+	progressFile = "progress"
+)
+
+// exportManifest holds the parameters of a bulk export, saved in its directory to resume it with the same ones
+type exportManifest struct {
+	DeviceIds  []int
+	From       time.Time
+	To         time.Time
+	EventTypes []string
+	Format     string
+	Chunk      string
+}
+
+// exportChunk is the part of the export downloaded by a single request
+type exportChunk struct {
+	deviceId int
+	from     time.Time
+	to       time.Time
+}
+
+// path of the file of that chunk, relative to the export directory, e.g. device_id=1001/date=2024-03-01/20240301T000000Z.csv
+func (c exportChunk) path(format string) string {
+	return filepath.Join(
+		fmt.Sprintf("device_id=%d", c.deviceId),
+		"date="+c.from.UTC().Format(time.DateOnly),
+		c.from.UTC().Format("20060102T150405Z")+"."+format)
+}
+
+// checkChunk checks that chunk duration divides a day: the chunks being aligned on UTC multiples of it, each of them
+// is then within a single day, i.e. a single date partition
+func checkChunk(chunk time.Duration) error {
+	if chunk < time.Minute || 24*time.Hour%chunk != 0 {
+		return usageErrorf("invalid -chunk %v, expected a divisor of 24h of at least 1m", chunk)
+	}
+	return nil
+}
+
+// chunks splits the period of the export into chunks per device, aligned on multiples of the chunk duration
+func (m exportManifest) chunks(chunk time.Duration) []exportChunk {
+	chunks := []exportChunk{}
+	for _, deviceId := range m.DeviceIds {
+		for from := m.From; from.Before(m.To); {
+			to := from.UTC().Truncate(chunk).Add(chunk)
+			if to.After(m.To) {
+				to = m.To
+			}
+			chunks = append(chunks, exportChunk{deviceId: deviceId, from: from, to: to})
+			from = to
+		}
+	}
+	return chunks
+}
+
+// loadOrCreateManifest returns the manifest of the export in that directory if any, which must match the requested one,
+// or else saves the requested one. The period of an export being resumed is the one recorded at its start,
+// unless explicitly requested with -from.
+func loadOrCreateManifest(dir string, requested exportManifest, explicitPeriod bool) (exportManifest, error) {
+	manifestPath := filepath.Join(dir, manifestFile)
+	manifestBytes, err := os.ReadFile(manifestPath)
+	if errors.Is(err, os.ErrNotExist) {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return requested, err
+		}
+		manifestBytes, err := json.MarshalIndent(requested, "", "  ")
+		if err != nil {
+			return requested, err
+		}
+		return requested, os.WriteFile(manifestPath, manifestBytes, 0o644)
+	}
+	if err != nil {
+		return requested, err
+	}
+
+	var existing exportManifest
+	if err := json.Unmarshal(manifestBytes, &existing); err != nil {
+		return requested, fmt.Errorf("invalid %s: %w", manifestPath, err)
+	}
+	samePeriod := !explicitPeriod || (existing.From.Equal(requested.From) && existing.To.Equal(requested.To))
+	if !samePeriod || !slices.Equal(existing.DeviceIds, requested.DeviceIds) || !slices.Equal(existing.EventTypes, requested.EventTypes) ||
+		existing.Format != requested.Format || existing.Chunk != requested.Chunk {
+		return requested, usageErrorf("%s holds another export (see %s), use another -dir to start a new one", dir, manifestPath)
+	}
+	return existing, nil
+}
+
+// exportProgress records the chunks already written, one path per line of the progress file
+type exportProgress struct {
+	mu   sync.Mutex
+	file *os.File
+	done map[string]bool
+}
+
+func openProgress(dir string) (*exportProgress, error) {
+	file, err := os.OpenFile(filepath.Join(dir, progressFile), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	progress := &exportProgress{file: file, done: map[string]bool{}}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		progress.done[scanner.Text()] = true
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read the progress of the export: %w", err)
+	}
+	return progress, nil
+}
+
+func (p *exportProgress) isDone(path string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.done[path]
+}
+
+func (p *exportProgress) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.done)
+}
+
+func (p *exportProgress) markDone(path string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done[path] = true
+	_, err := fmt.Fprintln(p.file, path)
+	return err
+}
+
+// exportChunkFile downloads that chunk to its file, written to a temporary file first so that
+// an interrupted download never leaves a partial file behind
+func exportChunkFile(ctx context.Context, client weather_client.WeatherClient, dir string, manifest exportManifest, chunk exportChunk) (int64, error) {
+	path := filepath.Join(dir, chunk.path(manifest.Format))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return 0, err
+	}
+	tmp, err := os.Create(path + ".tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	written, err := client.DownloadEvents(ctx, chunk.deviceId, chunk.from, chunk.to, manifest.Format, tmp, manifest.EventTypes...)
+	if err != nil {
+		return written, err
+	}
+	if err := tmp.Close(); err != nil {
+		return written, err
+	}
+	return written, os.Rename(tmp.Name(), path)
+}
+
+// bulkExport downloads the chunks of that export not done yet into that directory, with that number of concurrent requests,
+// reporting its progress to that logger
+func bulkExport(ctx context.Context, client weather_client.WeatherClient, dir string, manifest exportManifest, chunk time.Duration, concurrency int, logger *log.Logger) error {
+	progress, err := openProgress(dir)
+	if err != nil {
+		return err
+	}
+	defer progress.file.Close()
+
+	chunks := manifest.chunks(chunk)
+	pending := make(chan exportChunk)
+	go func() {
+		defer close(pending)
+		for _, c := range chunks {
+			if progress.isDone(c.path(manifest.Format)) {
+				continue
+			}
+			select {
+			case pending <- c:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	logger.Printf("exporting %d devices from %s to %s into %s: %d of %d chunks already done",
+		len(manifest.DeviceIds), manifest.From.Format(time.RFC3339), manifest.To.Format(time.RFC3339), dir, progress.count(), len(chunks))
+
+	var mu sync.Mutex
+	var exported, failed int
+	var bytesWritten int64
+	var wg sync.WaitGroup
+	for range max(concurrency, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range pending {
+				written, err := exportChunkFile(ctx, client, dir, manifest, c)
+				if err == nil {
+					err = progress.markDone(c.path(manifest.Format))
+				}
+
+				mu.Lock()
+				if err != nil {
+					if ctx.Err() == nil {
+						failed++
+						logger.Printf("failed to export device %d from %s to %s: %v", c.deviceId, c.from.Format(time.RFC3339), c.to.Format(time.RFC3339), err)
+					}
+				} else {
+					exported++
+					bytesWritten += written
+					logger.Printf("[%d/%d] wrote %d bytes to %s", progress.count(), len(chunks), written, c.path(manifest.Format))
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	logger.Printf("exported %d chunks (%d bytes), %d failed", exported, bytesWritten, failed)
+	if ctx.Err() != nil {
+		return fmt.Errorf("export interrupted, run the same command again to resume it")
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to export %d chunks, run the same command again to retry them", failed)
+	}
+	return nil
+}
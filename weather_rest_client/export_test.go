@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckChunk(t *testing.T) {
+	tests := []struct {
+		chunk time.Duration
+		valid bool
+	}{
+		{time.Minute, true},
+		{15 * time.Minute, true},
+		{time.Hour, true},
+		{8 * time.Hour, true},
+		{24 * time.Hour, true},
+		{30 * time.Second, false},
+		{7 * time.Minute, false},
+		{5 * time.Hour, false},
+		{48 * time.Hour, false},
+		{744 * time.Hour, false},
+	}
+	for _, test := range tests {
+		if err := checkChunk(test.chunk); (err == nil) != test.valid {
+			t.Errorf("chunk %v: error %v, expected valid %v", test.chunk, err, test.valid)
+		}
+	}
+}
+
+func TestChunksWithinOneDay(t *testing.T) {
+	manifest := exportManifest{
+		DeviceIds: []int{1001, 1002},
+		From:      time.Date(2024, 3, 1, 22, 30, 0, 0, time.UTC),
+		To:        time.Date(2024, 3, 3, 1, 0, 0, 0, time.UTC),
+	}
+	for _, chunk := range []time.Duration{time.Hour, 3 * time.Hour, 24 * time.Hour} {
+		chunks := manifest.chunks(chunk)
+		covered := map[int]time.Duration{}
+		for _, c := range chunks {
+			if c.to.Sub(c.from) > chunk || c.to.Add(-time.Nanosecond).Format(time.DateOnly) != c.from.Format(time.DateOnly) {
+				t.Errorf("chunk %v: %s to %s, expected within a single day", chunk, c.from, c.to)
+			}
+			covered[c.deviceId] += c.to.Sub(c.from)
+		}
+		for _, deviceId := range manifest.DeviceIds {
+			if covered[deviceId] != manifest.To.Sub(manifest.From) {
+				t.Errorf("chunk %v: %v covered for device %d, expected the whole period", chunk, covered[deviceId], deviceId)
+			}
+		}
+	}
+}
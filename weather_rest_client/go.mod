@@ -1,3 +1,7 @@
 module weather_rest_client
 
 go 1.22.0
+
+require software.sslmate.com/src/go-pkcs12 v0.4.0
+
+require golang.org/x/crypto v0.11.0 // indirect
@@ -1,9 +1,15 @@
-// CLI app fetching fetching the weather events from the weather REST API
+// CLI app querying the weather REST API: weather events, their aggregates and the devices
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"log"
+	"os"
+	"os/signal"
 	"time"
 
 	"weather_rest_client/weather_client"
@@ -12,34 +18,214 @@ import (
 /*
 	./weather_rest_client  \
 		-url https://rest.weather-api-demo.poc.svend.xyz/weather  \
-		-deviceId 1001 \
-		-timeDelta 13 \
 		-apiKey to_be_fetched_from_aws \
 		-certFile certificates/clientCert.pem \
-		-keyFile certificates/clientKey.pem
+		-keyFile certificates/clientKey.pem \
+		-units imperial \
+		query -deviceId 1001 -timeDelta 13
+
+or, with the url and credentials read from the prod profile of ~/.config/weather_api/config:
+
+	./weather_rest_client -profile prod -output csv aggregate -deviceId 1001 -bucket 1h -timeDelta 1440
+	./weather_rest_client -profile prod latest -deviceId 1001
+	./weather_rest_client -profile prod devices -near 47.37,8.54 -radiusKm 50
+	./weather_rest_client -profile prod export -deviceId 1001 -timeDelta 60 -format parquet -file events.parquet
+	./weather_rest_client -profile prod export -deviceIds 1001-1050 -from 2024-03-01T00:00:00Z -to 2024-04-01T00:00:00Z -format parquet -dir march
+	./weather_rest_client -profile prod -cache plot -deviceIds 1001-1003 -timeDelta 1440 -style chart
+	./weather_rest_client -profile prod -output json watch -deviceId 1001 -interval 30s
 */
+
+// exit codes
+const (
+	exitOk = 0
+	// the API could not be queried or returned an error
+	exitError = 1
+	// invalid command or flags
+	exitUsage = 2
+)
+
+// command is a subcommand of the CLI, whose flags are declared by setup, which returns the function running it
+type command struct {
+	name    string
+	summary string
+	setup   func(flags *flag.FlagSet) runner
+}
+
+type runner func(ctx context.Context, client weather_client.WeatherClient, out *printer) error
+
+var commands = []command{
+	{name: "query", summary: "print the weather events of a device during a period", setup: setupQuery},
+	{name: "latest", summary: "print the latest reading of each sensor of a device", setup: setupLatest},
+	{name: "aggregate", summary: "print the count, min, max, avg and last value of the events of a device per time bucket", setup: setupAggregate},
+	{name: "devices", summary: "print the registered devices, or those around a location with their latest readings", setup: setupDevices},
+	{name: "export", summary: "write the weather events of a device to a file, or of several devices to a directory, as JSON, CSV, NDJSON or Parquet", setup: setupExport},
+	{name: "plot", summary: "render the weather events of one or several devices as sparklines or line charts, with their min, max and avg", setup: setupPlot},
+	{name: "watch", summary: "print the new weather events of a device as they are recorded, until interrupted", setup: setupWatch},
+}
+
+// usageError reports an invalid command or flag
+type usageError struct {
+	msg string
+}
+
+func (e usageError) Error() string {
+	return e.msg
+}
+
+func usageErrorf(format string, args ...any) error {
+	return usageError{msg: fmt.Sprintf(format, args...)}
+}
+
 func main() {
-	deviceId := flag.Int("deviceId", -1, "Id of the device")
-	timeDelta := flag.Int("timeDelta", -1, "Duration in minutes of the queried period, ending now")
-	apiUrl := flag.String("url", "", "URL of the REST endpoint")
-	apiKey := flag.String("apiKey", "", "API key")
-	certFile := flag.String("certFile", "", "PEM file containing the client public certificate")
-	keyFile := flag.String("keyFile", "", "PEM file containing the client private key")
-	toTime := time.Now()
-	fromTime := toTime.Add(-10 * time.Minute)
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run executes the command of those arguments, and returns the exit code
+func run(args []string, stdout, stderr io.Writer) int {
+	globalFlags := flag.NewFlagSet("weather_rest_client", flag.ContinueOnError)
+	globalFlags.SetOutput(stderr)
+	apiUrl := globalFlags.String("url", "", "URL of the REST endpoint")
+	apiKey := globalFlags.String("apiKey", "", "API key")
+	certFile := globalFlags.String("certFile", "", "PEM file containing the client public certificate")
+	keyFile := globalFlags.String("keyFile", "", "PEM file containing the client private key")
+	pkcs12File := globalFlags.String("pkcs12File", "", "PKCS#12 bundle containing the client certificate and private key, whose password is read from WEATHER_PKCS12_PASSWORD")
+	caFile := globalFlags.String("caFile", "", "PEM bundle of the root CAs trusted to sign the server certificate, instead of the system ones")
+	serverPin := globalFlags.String("serverPin", "", "base64 SHA-256 hash of the public key of the server certificate")
+	profile := globalFlags.String("profile", os.Getenv("WEATHER_PROFILE"), "Profile of the config file to read the credentials from, overridden by the WEATHER_* env vars and the flags")
+	units := globalFlags.String("units", "metric", "Unit system of the values: metric, imperial or si")
+	output := globalFlags.String("output", "table", "Output format: table, json or csv")
+	timeout := globalFlags.Duration("timeout", 30*time.Second, "Timeout of each request")
+	maxAttempts := globalFlags.Int("maxAttempts", weather_client.DefaultRetryPolicy.MaxAttempts, "Number of attempts of each request throttled or failed by the API")
+	rateLimit := globalFlags.Float64("rateLimit", 50, "Max number of requests per second, as allowed by the usage plan of the API key. No limit if 0")
+	cache := globalFlags.Bool("cache", false, "Read the events of the past periods from the on-disk cache, in WEATHER_CACHE_DIR or ~/.cache/weather_api, instead of querying them again")
+	verbose := globalFlags.Bool("verbose", false, "Log each request sent to the API")
+	globalFlags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: weather_rest_client [global flags] <command> [command flags]\n\nCommands:\n")
+		for _, cmd := range commands {
+			fmt.Fprintf(stderr, "  %-10s %s\n", cmd.name, cmd.summary)
+		}
+		fmt.Fprintf(stderr, "\nRun 'weather_rest_client <command> -h' for the flags of a command.\n\nGlobal flags:\n")
+		globalFlags.PrintDefaults()
+	}
+
+	if err := globalFlags.Parse(args); err != nil {
+		return parseErrorExitCode(err)
+	}
+	if globalFlags.NArg() == 0 {
+		globalFlags.Usage()
+		return exitUsage
+	}
+
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == globalFlags.Arg(0) {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(stderr, "unknown command %q\n\n", globalFlags.Arg(0))
+		globalFlags.Usage()
+		return exitUsage
+	}
 
-	if flag.Parse(); *deviceId == -1 || *timeDelta == -1 || len(*apiUrl) == 0 {
-		flag.Usage()
+	cmdFlags := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	cmdFlags.SetOutput(stderr)
+	runCommand := cmd.setup(cmdFlags)
+	if err := cmdFlags.Parse(globalFlags.Args()[1:]); err != nil {
+		return parseErrorExitCode(err)
 	}
 
-	client := weather_client.New(*apiUrl, *apiKey, *certFile, *keyFile)
-	events, err := client.QueryEvents(*deviceId, fromTime, toTime)
+	out, err := newPrinter(*output, stdout, stderr)
 	if err != nil {
-		log.Fatal(err)
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+
+	credentials, err := loadCredentials(*profile, weather_client.Credentials{
+		ApiUrl:     *apiUrl,
+		ApiKey:     *apiKey,
+		CertFile:   *certFile,
+		KeyFile:    *keyFile,
+		Pkcs12File: *pkcs12File,
+		CaFile:     *caFile,
+		ServerPin:  *serverPin,
+	})
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+	if len(credentials.ApiUrl) == 0 {
+		fmt.Fprintln(stderr, "missing API URL: set -url, WEATHER_API_URL or a -profile")
+		return exitUsage
+	}
+
+	logger := log.New(io.Discard, "", 0)
+	if *verbose {
+		logger = log.New(stderr, "", log.LstdFlags)
+	}
+	retryPolicy := weather_client.DefaultRetryPolicy
+	retryPolicy.MaxAttempts = *maxAttempts
+	options := []weather_client.Option{
+		weather_client.WithUnits(*units),
+		weather_client.WithTimeout(*timeout),
+		weather_client.WithRetryPolicy(retryPolicy),
+		weather_client.WithLogger(logger),
 	}
+	if *rateLimit > 0 {
+		options = append(options, weather_client.WithRateLimiter(weather_client.NewRateLimiter(*rateLimit)))
+	}
+	if *cache {
+		cacheDir, err := weather_client.DefaultCacheDir()
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitError
+		}
+		eventsCache, err := weather_client.NewCache(cacheDir, weather_client.DefaultCacheChunk)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitError
+		}
+		options = append(options, weather_client.WithCache(eventsCache))
+	}
+	client, err := weather_client.NewFromCredentials(credentials, options...)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := runCommand(ctx, client, out); err != nil {
+		fmt.Fprintln(stderr, err)
+		if errors.As(err, &usageError{}) {
+			cmdFlags.Usage()
+			return exitUsage
+		}
+		return exitError
+	}
+	return exitOk
+}
+
+// parseErrorExitCode returns the exit code of a failure to parse the flags, which the flag set already reported
+func parseErrorExitCode(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOk
+	}
+	return exitUsage
+}
 
-	log.Printf("\n\nlast %d minutes of weather events of device %d:\n\n", *timeDelta, *deviceId)
-	for _, event := range events {
-		log.Println(event)
+// loadCredentials reads the credentials from that profile of the config file, if any,
+// overridden by the WEATHER_* env vars and then by those passed as flags
+func loadCredentials(profile string, flagCredentials weather_client.Credentials) (weather_client.Credentials, error) {
+	credentials := weather_client.Credentials{}
+	if len(profile) > 0 {
+		configFile, err := weather_client.DefaultConfigFile()
+		if err != nil {
+			return credentials, err
+		}
+		if credentials, err = weather_client.LoadProfile(configFile, profile); err != nil {
+			return credentials, err
+		}
 	}
+	return credentials.Merge(weather_client.CredentialsFromEnv()).Merge(flagCredentials), nil
 }
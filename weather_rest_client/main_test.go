@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"weather_rest_client/weather_client"
+)
+
+func TestLoadCredentials(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config")
+	config := "[prod]\nurl = https://profile.example.com/weather\napi_key = profile-key\ncert_file = clientCert.pem\nkey_file = clientKey.pem\n"
+	if err := os.WriteFile(configFile, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("WEATHER_CONFIG_FILE", configFile)
+	for _, envVar := range []string{"WEATHER_API_URL", "WEATHER_CERT_FILE", "WEATHER_KEY_FILE", "WEATHER_PKCS12_FILE", "WEATHER_PKCS12_PASSWORD", "WEATHER_SERVER_PIN"} {
+		t.Setenv(envVar, "")
+	}
+	t.Setenv("WEATHER_API_KEY", "env-key")
+	t.Setenv("WEATHER_CA_FILE", "/env/ca.pem")
+
+	tests := []struct {
+		name     string
+		profile  string
+		flags    weather_client.Credentials
+		expected weather_client.Credentials
+	}{
+		{"env over profile", "prod", weather_client.Credentials{}, weather_client.Credentials{
+			ApiUrl:   "https://profile.example.com/weather",
+			ApiKey:   "env-key",
+			CertFile: filepath.Join(filepath.Dir(configFile), "clientCert.pem"),
+			KeyFile:  filepath.Join(filepath.Dir(configFile), "clientKey.pem"),
+			CaFile:   "/env/ca.pem",
+		}},
+		{"flags over env and profile", "prod", weather_client.Credentials{ApiKey: "flag-key", CertFile: "flag.pem", CaFile: "/flag/ca.pem"}, weather_client.Credentials{
+			ApiUrl:   "https://profile.example.com/weather",
+			ApiKey:   "flag-key",
+			CertFile: "flag.pem",
+			KeyFile:  filepath.Join(filepath.Dir(configFile), "clientKey.pem"),
+			CaFile:   "/flag/ca.pem",
+		}},
+		{"no profile", "", weather_client.Credentials{ApiUrl: "https://flag.example.com/weather"}, weather_client.Credentials{
+			ApiUrl: "https://flag.example.com/weather",
+			ApiKey: "env-key",
+			CaFile: "/env/ca.pem",
+		}},
+	}
+	for _, test := range tests {
+		credentials, err := loadCredentials(test.profile, test.flags)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if credentials != test.expected {
+			t.Errorf("%s: credentials %+v, expected %+v", test.name, credentials, test.expected)
+		}
+	}
+
+	if _, err := loadCredentials("staging", weather_client.Credentials{}); err == nil {
+		t.Error("loaded the credentials of a missing profile")
+	}
+}
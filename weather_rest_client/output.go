@@ -0,0 +1,164 @@
+// Printing of the events, aggregates and devices as a table, JSON or CSV
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"weather_rest_client/weather_client"
+)
+
+var outputFormats = []string{"table", "json", "csv"}
+
+// printer writes the results of the commands in the selected output format, and their progress messages
+type printer struct {
+	format string
+	out    io.Writer
+	// progress and warning messages, kept apart from the results (i.e. on stderr)
+	logger *log.Logger
+	// whether results are printed in several batches (e.g. by watch), in which case the table and CSV
+	// headers are only printed once, and JSON records are printed one per line
+	streaming     bool
+	headerPrinted bool
+}
+
+func newPrinter(format string, out io.Writer, errOut io.Writer) (*printer, error) {
+	for _, outputFormat := range outputFormats {
+		if format == outputFormat {
+			return &printer{format: format, out: out, logger: log.New(errOut, "", log.LstdFlags)}, nil
+		}
+	}
+	return nil, usageErrorf("invalid -output %q, expected one of %s", format, strings.Join(outputFormats, ", "))
+}
+
+func (p *printer) printEvents(events []weather_client.WeatherEvent) error {
+	header := []string{"TIME", "DEVICE", "TYPE", "VALUE", "UNIT", "FAULT", "ARCHIVED"}
+	rows := make([][]string, 0, len(events))
+	for _, event := range events {
+		rows = append(rows, []string{
+			event.Time.Format(time.RFC3339),
+			strconv.FormatInt(event.DeviceId, 10),
+			event.EventType,
+			formatValue(event.Value),
+			event.Unit,
+			event.Fault,
+			strconv.FormatBool(event.Archived),
+		})
+	}
+	return p.print(events, header, rows)
+}
+
+func (p *printer) printAggregates(aggregates []weather_client.Aggregate) error {
+	header := []string{"START", "DEVICE", "TYPE", "COUNT", "MIN", "MAX", "AVG", "LAST", "UNIT"}
+	rows := make([][]string, 0, len(aggregates))
+	for _, aggregate := range aggregates {
+		rows = append(rows, []string{
+			aggregate.Start.Format(time.RFC3339),
+			strconv.FormatInt(aggregate.DeviceId, 10),
+			aggregate.EventType,
+			strconv.FormatInt(aggregate.Count, 10),
+			formatValue(aggregate.Min),
+			formatValue(aggregate.Max),
+			formatValue(aggregate.Avg),
+			formatValue(aggregate.Last),
+			aggregate.Unit,
+		})
+	}
+	return p.print(aggregates, header, rows)
+}
+
+func (p *printer) printDevices(devices []weather_client.Device) error {
+	header := []string{"DEVICE", "NAME", "STATUS", "LATITUDE", "LONGITUDE", "ALTITUDE", "SENSORS", "INSTALLED"}
+	rows := make([][]string, 0, len(devices))
+	for _, device := range devices {
+		rows = append(rows, deviceRow(device))
+	}
+	return p.print(devices, header, rows)
+}
+
+func (p *printer) printNearbyDevices(devices []weather_client.LocatedDevice) error {
+	header := []string{"DEVICE", "NAME", "STATUS", "LATITUDE", "LONGITUDE", "ALTITUDE", "SENSORS", "INSTALLED", "DISTANCE_KM", "LATEST_READINGS"}
+	rows := make([][]string, 0, len(devices))
+	for _, device := range devices {
+		readings := []string{}
+		for _, event := range device.LatestReadings {
+			readings = append(readings, fmt.Sprintf("%s=%s%s", event.EventType, formatValue(event.Value), event.Unit))
+		}
+		rows = append(rows, append(deviceRow(device.Device), strconv.FormatFloat(device.DistanceKm, 'f', 1, 64), strings.Join(readings, " ")))
+	}
+	return p.print(devices, header, rows)
+}
+
+func deviceRow(device weather_client.Device) []string {
+	return []string{
+		strconv.FormatInt(device.DeviceId, 10),
+		device.Name,
+		device.Status,
+		formatValue(device.Location.Latitude),
+		formatValue(device.Location.Longitude),
+		formatValue(device.Location.Altitude),
+		strings.Join(device.Sensors, ","),
+		device.InstalledAt.Format(time.RFC3339),
+	}
+}
+
+// print writes those records as JSON, or their rows as a table or CSV
+func (p *printer) print(records any, header []string, rows [][]string) error {
+	switch p.format {
+	case "json":
+		return p.printJson(records)
+	case "csv":
+		writer := csv.NewWriter(p.out)
+		if !p.headerPrinted {
+			writer.Write(header)
+		}
+		writer.WriteAll(rows)
+		p.headerPrinted = true
+		return writer.Error()
+	default:
+		writer := tabwriter.NewWriter(p.out, 0, 0, 2, ' ', 0)
+		if !p.headerPrinted {
+			fmt.Fprintln(writer, strings.Join(header, "\t"))
+		}
+		for _, row := range rows {
+			fmt.Fprintln(writer, strings.Join(row, "\t"))
+		}
+		p.headerPrinted = true
+		return writer.Flush()
+	}
+}
+
+func (p *printer) printJson(records any) error {
+	if !p.streaming {
+		encoder := json.NewEncoder(p.out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(records)
+	}
+
+	// one record per line, so that the output of watch can be piped
+	var items []json.RawMessage
+	recordsBytes, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(recordsBytes, &items); err != nil {
+		return err
+	}
+	for _, item := range items {
+		if _, err := fmt.Fprintln(p.out, string(item)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
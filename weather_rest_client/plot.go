@@ -0,0 +1,199 @@
+// Rendering of the weather events as sparklines or ASCII line charts in the terminal
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"weather_rest_client/weather_client"
+)
+
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// series are the values of one event type of one device over time
+type series struct {
+	deviceId  int64
+	eventType string
+	unit      string
+	events    []weather_client.WeatherEvent
+}
+
+func (s series) summary() string {
+	minValue, maxValue, sum := math.Inf(1), math.Inf(-1), 0.0
+	for _, event := range s.events {
+		minValue = min(minValue, event.Value)
+		maxValue = max(maxValue, event.Value)
+		sum += event.Value
+	}
+	return fmt.Sprintf("min %s  max %s  avg %s  last %s %s  (%d events)",
+		formatSummaryValue(minValue), formatSummaryValue(maxValue), formatSummaryValue(sum/float64(len(s.events))),
+		formatSummaryValue(s.events[len(s.events)-1].Value), s.unit, len(s.events))
+}
+
+func formatSummaryValue(value float64) string {
+	return fmt.Sprintf("%.2f", value)
+}
+
+// groupSeries splits those events per device and event type, sorted by device, event type and time
+func groupSeries(events []weather_client.WeatherEvent) []series {
+	type seriesKey struct {
+		deviceId  int64
+		eventType string
+	}
+	byKey := map[seriesKey]*series{}
+	for _, event := range events {
+		key := seriesKey{deviceId: event.DeviceId, eventType: event.EventType}
+		if _, ok := byKey[key]; !ok {
+			byKey[key] = &series{deviceId: event.DeviceId, eventType: event.EventType, unit: event.Unit}
+		}
+		byKey[key].events = append(byKey[key].events, event)
+	}
+
+	allSeries := make([]series, 0, len(byKey))
+	for _, s := range byKey {
+		sort.Slice(s.events, func(i, j int) bool { return s.events[i].Time.Before(s.events[j].Time) })
+		allSeries = append(allSeries, *s)
+	}
+	sort.Slice(allSeries, func(i, j int) bool {
+		if allSeries[i].deviceId != allSeries[j].deviceId {
+			return allSeries[i].deviceId < allSeries[j].deviceId
+		}
+		return allSeries[i].eventType < allSeries[j].eventType
+	})
+	return allSeries
+}
+
+// columns averages the values of those events within each of width time slots of the period,
+// NaN marking the slots without event
+func columns(events []weather_client.WeatherEvent, fromTime, toTime time.Time, width int) []float64 {
+	sums := make([]float64, width)
+	counts := make([]int, width)
+	period := toTime.Sub(fromTime)
+	for _, event := range events {
+		column := 0
+		if period > 0 {
+			column = int(float64(event.Time.Sub(fromTime)) / float64(period) * float64(width))
+		}
+		column = min(max(column, 0), width-1)
+		sums[column] += event.Value
+		counts[column]++
+	}
+
+	values := make([]float64, width)
+	for i := range values {
+		if counts[i] == 0 {
+			values[i] = math.NaN()
+		} else {
+			values[i] = sums[i] / float64(counts[i])
+		}
+	}
+	return values
+}
+
+// valueRange returns the min and max of those values, ignoring NaNs, widened if equal
+func valueRange(values []float64) (float64, float64) {
+	low, high := math.Inf(1), math.Inf(-1)
+	for _, value := range values {
+		if !math.IsNaN(value) {
+			low, high = min(low, value), max(high, value)
+		}
+	}
+	if low == high {
+		low, high = low-1, high+1
+	}
+	return low, high
+}
+
+// sparkline renders those values as a line of blocks, whose height is relative to the min and max of the values
+func sparkline(values []float64) string {
+	low, high := valueRange(values)
+	var line strings.Builder
+	for _, value := range values {
+		if math.IsNaN(value) {
+			line.WriteRune(' ')
+			continue
+		}
+		level := int((value - low) / (high - low) * float64(len(sparkBlocks)-1))
+		line.WriteRune(sparkBlocks[min(max(level, 0), len(sparkBlocks)-1)])
+	}
+	return line.String()
+}
+
+// lineChart renders those values as an ASCII chart of that height, with the y axis on the left
+func lineChart(values []float64, height int) []string {
+	low, high := valueRange(values)
+	rowOf := func(value float64) int {
+		return int(math.Round((value - low) / (high - low) * float64(height-1)))
+	}
+
+	grid := make([][]rune, height)
+	for row := range grid {
+		grid[row] = []rune(strings.Repeat(" ", len(values)))
+	}
+	previousRow := -1
+	for column, value := range values {
+		if math.IsNaN(value) {
+			previousRow = -1
+			continue
+		}
+		row := rowOf(value)
+		// vertical segment joining the previous point, if in the previous column
+		if previousRow >= 0 {
+			for between := min(row, previousRow) + 1; between < max(row, previousRow); between++ {
+				grid[between][column] = '│'
+			}
+		}
+		grid[row][column] = '•'
+		previousRow = row
+	}
+
+	labelWidth := max(len(formatSummaryValue(low)), len(formatSummaryValue(high)))
+	lines := make([]string, 0, height+1)
+	for row := height - 1; row >= 0; row-- {
+		label := ""
+		if row == height-1 {
+			label = formatSummaryValue(high)
+		} else if row == 0 {
+			label = formatSummaryValue(low)
+		} else if row == (height-1)/2 && height > 2 {
+			label = formatSummaryValue(low + (high-low)*float64(row)/float64(height-1))
+		}
+		lines = append(lines, fmt.Sprintf("%*s ┤%s", labelWidth, label, string(grid[row])))
+	}
+	lines = append(lines, fmt.Sprintf("%*s └%s", labelWidth, "", strings.Repeat("─", len(values))))
+	return lines
+}
+
+// plotEvents renders the events of each device and event type during that period, as sparklines or charts
+func plotEvents(out io.Writer, events []weather_client.WeatherEvent, fromTime, toTime time.Time, style string, width, height int) {
+	fmt.Fprintf(out, "%s → %s\n", fromTime.Format(time.RFC3339), toTime.Format(time.RFC3339))
+	if len(events) == 0 {
+		fmt.Fprintln(out, "no event")
+		return
+	}
+
+	allSeries := groupSeries(events)
+	typeWidth := 0
+	for _, s := range allSeries {
+		typeWidth = max(typeWidth, len(s.eventType))
+	}
+
+	for i, s := range allSeries {
+		if i == 0 || allSeries[i-1].deviceId != s.deviceId {
+			fmt.Fprintf(out, "\ndevice %d\n", s.deviceId)
+		}
+		values := columns(s.events, fromTime, toTime, width)
+		if style == "chart" {
+			fmt.Fprintf(out, "\n  %s  %s\n", s.eventType, s.summary())
+			for _, line := range lineChart(values, height) {
+				fmt.Fprintf(out, "  %s\n", line)
+			}
+		} else {
+			fmt.Fprintf(out, "  %-*s %s  %s\n", typeWidth, s.eventType, sparkline(values), s.summary())
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package weather_client
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Aggregate summarizes the events of one type within one time bucket
+type Aggregate struct {
+	DeviceId  int64
+	EventType string
+	// start of the bucket
+	Start time.Time
+	Count int64
+	Min   float64
+	Max   float64
+	Avg   float64
+	// value of the most recent event of the bucket
+	Last float64
+	Unit string
+}
+
+func (a Aggregate) String() string {
+	return fmt.Sprintf("%s device %d %-15s count %4d min %10.2f max %10.2f avg %10.2f last %10.2f %s",
+		a.Start.Format(time.RFC3339), a.DeviceId, a.EventType, a.Count, a.Min, a.Max, a.Avg, a.Last, a.Unit)
+}
+
+// QueryAggregates returns the aggregates per bucket of the events of that device during that period,
+// restricted to those event types if any. Buckets of 1h or 24h between whole hours or days are
+// read from the rollups maintained by the API, and are thus cheaper.
+func (c WeatherClient) QueryAggregates(ctx context.Context, deviceId int, fromTime time.Time, toTime time.Time, bucket time.Duration, eventTypes ...string) ([]Aggregate, error) {
+	c.logf("looking for %v aggregates of device %v from %s to %v", bucket, deviceId, fromTime, toTime)
+
+	q := c.eventsQuery(deviceId, fromTime, toTime, eventTypes)
+	q.Add("bucket", bucket.String())
+
+	var data []Aggregate
+	if err := c.getJson(ctx, c.ApiUrl, q, &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// QueryLatestEvents returns the most recent event of each type of that device, among those of the last lookback period
+func (c WeatherClient) QueryLatestEvents(ctx context.Context, deviceId int, lookback time.Duration, eventTypes ...string) ([]WeatherEvent, error) {
+	toTime := time.Now()
+	weatherEvents, err := c.QueryEvents(ctx, deviceId, toTime.Add(-lookback), toTime, eventTypes...)
+	if err != nil {
+		return nil, err
+	}
+
+	latest := []WeatherEvent{}
+	indexes := map[string]int{}
+	for _, event := range weatherEvents {
+		if i, ok := indexes[event.EventType]; !ok {
+			indexes[event.EventType] = len(latest)
+			latest = append(latest, event)
+		} else if event.Time.After(latest[i].Time) {
+			latest[i] = event
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,249 @@
+package weather_client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+)
+
+// DefaultCacheChunk is the default period of the events cached together
+const DefaultCacheChunk = 24 * time.Hour
+
+// delay after the end of a chunk from which its events are considered complete, devices sending some of them late
+const cacheSettleDelay = 15 * time.Minute
+
+// key of the events of all types, cached when no event type is requested
+const allEventTypes = "_all"
+
+// Cache stores the events of the past periods on disk, per device, event type and time chunk, since they never change:
+// only the chunks not cached yet, or still open, are queried from the API.
+// The same cache may be shared by several clients, including clients of different APIs or unit systems.
+type Cache struct {
+	dir string
+	// period of the events cached together, the chunks being aligned on its multiples
+	chunk time.Duration
+}
+
+// NewCache creates a cache storing its files in that directory, in chunks of that period, such as DefaultCacheChunk.
+// The periods missing from the cache being queried by whole chunks, longer chunks mean fewer but larger queries.
+func NewCache(dir string, chunk time.Duration) (*Cache, error) {
+	if chunk < time.Minute {
+		return nil, fmt.Errorf("invalid cache chunk %v, expected at least 1m", chunk)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("could not create the cache directory: %w", err)
+	}
+	return &Cache{dir: dir, chunk: chunk}, nil
+}
+
+// DefaultCacheDir is the directory of the WEATHER_CACHE_DIR environment variable if set,
+// or else weather_api in the user cache directory (e.g. ~/.cache/weather_api on Linux)
+func DefaultCacheDir() (string, error) {
+	if cacheDir := os.Getenv("WEATHER_CACHE_DIR"); cacheDir != "" {
+		return cacheDir, nil
+	}
+	userCacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("could not locate the cache directory: %w", err)
+	}
+	return filepath.Join(userCacheDir, "weather_api"), nil
+}
+
+// WithCache serves the events of QueryEvents from that cache when they are in the past
+func WithCache(cache *Cache) Option {
+	return func(c *WeatherClient) {
+		c.cache = cache
+	}
+}
+
+// cacheChunk is a period of the events of a device, between start included and end excluded
+type cacheChunk struct {
+	start time.Time
+	end   time.Time
+	// whether the chunk is over, and hence can be cached
+	past bool
+}
+
+// chunks returns the chunks covering that period, aligned on multiples of the chunk period
+func (cache *Cache) chunks(fromTime, toTime, now time.Time) []cacheChunk {
+	chunks := []cacheChunk{}
+	for start := fromTime.UTC().Truncate(cache.chunk); !start.After(toTime); start = start.Add(cache.chunk) {
+		end := start.Add(cache.chunk)
+		chunks = append(chunks, cacheChunk{start: start, end: end, past: !end.After(now.Add(-cacheSettleDelay))})
+	}
+	return chunks
+}
+
+// path of the file of the events of that type of that chunk of that device, e.g.
+// <dir>/<hash of the API URL>/metric/1001/20240301T000000Z/Temperature.json
+func (cache *Cache) path(c WeatherClient, deviceId int, chunk cacheChunk, eventType string) string {
+	apiHash := sha256.Sum256([]byte(c.ApiUrl))
+	units := c.Units
+	if units == "" {
+		units = "metric"
+	}
+	return filepath.Join(cache.dir, hex.EncodeToString(apiHash[:6]), url.PathEscape(units), fmt.Sprint(deviceId),
+		chunk.start.Format("20060102T150405Z"), url.PathEscape(eventType)+".json")
+}
+
+func (cache *Cache) read(path string) ([]WeatherEvent, bool) {
+	eventsBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	var events []WeatherEvent
+	if err := json.Unmarshal(eventsBytes, &events); err != nil {
+		return nil, false
+	}
+	return events, true
+}
+
+// write stores those events in that file, through a temporary file so that concurrent readers never see a partial one
+func (cache *Cache) write(path string, events []WeatherEvent) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	eventsBytes, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(eventsBytes); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// load returns the cached events of that chunk of that device, restricted to those event types if any.
+// Those of a type may also be found among the events of all types of the chunk.
+func (cache *Cache) load(c WeatherClient, deviceId int, chunk cacheChunk, eventTypes []string) ([]WeatherEvent, bool) {
+	if len(eventTypes) == 0 {
+		return cache.read(cache.path(c, deviceId, chunk, allEventTypes))
+	}
+
+	events := []WeatherEvent{}
+	var allEvents []WeatherEvent
+	allLoaded := false
+	for _, eventType := range eventTypes {
+		if typeEvents, ok := cache.read(cache.path(c, deviceId, chunk, eventType)); ok {
+			events = append(events, typeEvents...)
+			continue
+		}
+		if !allLoaded {
+			var ok bool
+			if allEvents, ok = cache.read(cache.path(c, deviceId, chunk, allEventTypes)); !ok {
+				return nil, false
+			}
+			allLoaded = true
+		}
+		for _, event := range allEvents {
+			if event.EventType == eventType {
+				events = append(events, event)
+			}
+		}
+	}
+	return events, true
+}
+
+// store caches the events of that chunk of that device, as those of all types if no event type is requested,
+// or else per event type, including the types without events
+func (cache *Cache) store(c WeatherClient, deviceId int, chunk cacheChunk, eventTypes []string, events []WeatherEvent) error {
+	if len(eventTypes) == 0 {
+		return cache.write(cache.path(c, deviceId, chunk, allEventTypes), events)
+	}
+	for _, eventType := range eventTypes {
+		typeEvents := []WeatherEvent{}
+		for _, event := range events {
+			if event.EventType == eventType {
+				typeEvents = append(typeEvents, event)
+			}
+		}
+		if err := cache.write(cache.path(c, deviceId, chunk, eventType), typeEvents); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queryEventsCached returns the events of that device during that period, reading the past chunks from the cache
+// and querying the API for the other ones, each run of consecutive chunks missing from the cache in a single query
+func (c WeatherClient) queryEventsCached(ctx context.Context, deviceId int, fromTime time.Time, toTime time.Time, eventTypes []string) ([]WeatherEvent, error) {
+	chunks := c.cache.chunks(fromTime, toTime, time.Now())
+	cached := make([][]WeatherEvent, len(chunks))
+	hits := make([]bool, len(chunks))
+	hitCount := 0
+	for i, chunk := range chunks {
+		if chunk.past {
+			if cached[i], hits[i] = c.cache.load(c, deviceId, chunk, eventTypes); hits[i] {
+				hitCount++
+			}
+		}
+	}
+	c.logf("found %d of %d chunks of the weather events for device %v from %s to %v in the cache", hitCount, len(chunks), deviceId, fromTime, toTime)
+
+	events := []WeatherEvent{}
+	for i := 0; i < len(chunks); {
+		if hits[i] {
+			events = append(events, cached[i]...)
+			i++
+			continue
+		}
+
+		// the whole past chunks are queried to be cached, the open ones only until toTime
+		last := i
+		for last+1 < len(chunks) && !hits[last+1] {
+			last++
+		}
+		queryFrom, queryTo := chunks[i].start, toTime
+		if !chunks[i].past && queryFrom.Before(fromTime) {
+			queryFrom = fromTime
+		}
+		if chunks[last].past {
+			queryTo = chunks[last].end
+		}
+		// the API reads all the events of the period or fails, hence only complete chunks are cached
+		queried, err := c.queryEvents(ctx, deviceId, queryFrom, queryTo, eventTypes)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, chunk := range chunks[i : last+1] {
+			chunkEvents := []WeatherEvent{}
+			for _, event := range queried {
+				if !event.Time.Before(chunk.start) && event.Time.Before(chunk.end) {
+					chunkEvents = append(chunkEvents, event)
+				}
+			}
+			if chunk.past {
+				if err := c.cache.store(c, deviceId, chunk, eventTypes, chunkEvents); err != nil {
+					c.logf("failed to cache the weather events for device %v from %s: %v", deviceId, chunk.start, err)
+				}
+			}
+			events = append(events, chunkEvents...)
+		}
+		i = last + 1
+	}
+
+	// the API returns the events within the period, bounds included
+	events = slices.DeleteFunc(events, func(event WeatherEvent) bool {
+		return event.Time.Before(fromTime) || event.Time.After(toTime)
+	})
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+	return events, nil
+}
@@ -0,0 +1,36 @@
+package weather_client
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCacheStoresOnlySuccessfulQueries(t *testing.T) {
+	cache, err := NewCache(t.TempDir(), DefaultCacheChunk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := &testApi{statuses: []int{http.StatusInternalServerError, http.StatusOK}}
+	client := newTestClient(t, api, WithRetryPolicy(NoRetry), WithCache(cache))
+	fromTime := time.Now().Add(-3 * DefaultCacheChunk).Truncate(DefaultCacheChunk)
+	toTime := fromTime.Add(DefaultCacheChunk)
+	queryPast := func() error {
+		_, err := client.QueryEvents(context.Background(), 1001, fromTime, toTime)
+		return err
+	}
+
+	if err := queryPast(); statusOf(err) != http.StatusInternalServerError {
+		t.Fatalf("error %v, expected the 500 problem", err)
+	}
+	if err := queryPast(); err != nil {
+		t.Fatalf("error %v, expected the failed chunk to be queried again", err)
+	}
+	if err := queryPast(); err != nil {
+		t.Fatal(err)
+	}
+	if requests := len(api.requestTimes()); requests != 2 {
+		t.Errorf("%d requests, expected the chunk to be cached only after the successful query", requests)
+	}
+}
@@ -1,13 +1,14 @@
 package weather_client
 
 import (
-	"crypto/tls"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 )
 
@@ -16,72 +17,194 @@ type WeatherEvent struct {
 	Time      time.Time
 	EventType string
 	Value     float64
+	Unit      string
+	Fault     string
+	// whether the event was read from the archive of the API, as opposed to its live storage
+	Archived bool
+}
+
+func (e WeatherEvent) String() string {
+	description := fmt.Sprintf("%s device %d %-15s %10.2f %s", e.Time.Format(time.RFC3339), e.DeviceId, e.EventType, e.Value, e.Unit)
+	if e.Fault != "" {
+		description += fmt.Sprintf(" (fault: %s)", e.Fault)
+	}
+	if e.Archived {
+		description += " (archived)"
+	}
+	return description
 }
 
 type WeatherClient struct {
-	ApiUrl     string
+	ApiUrl string
+	// unit system of the returned values: metric, imperial or si. Defaults to metric if empty
+	Units      string
 	httpClient *http.Client
 	apiKey     string
+	logger     Logger
+	// timeout of each request, overriding the one of httpClient if not 0
+	timeout     time.Duration
+	retryPolicy RetryPolicy
+	// nil if disabled
+	breaker *CircuitBreaker
+	// nil if disabled
+	limiter *RateLimiter
+	// nil if disabled
+	cache *Cache
 }
 
-func New(url, apiKey, certFile, keyFile string) WeatherClient {
-
-	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
-	if err != nil {
-		log.Fatal(err)
-	}
+// New creates a client of the API at that URL, authenticated by that API key and by the client certificate
+// and private key stored in those PEM files, unless the HTTP client is provided through WithHTTPClient.
+// See NewFromCredentials for the other authentication means.
+func New(url, apiKey, certFile, keyFile string, options ...Option) (WeatherClient, error) {
+	return NewFromCredentials(Credentials{ApiUrl: url, ApiKey: apiKey, CertFile: certFile, KeyFile: keyFile}, options...)
+}
 
-	return WeatherClient{
-		ApiUrl: url,
-		httpClient: &http.Client{
-			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{
-					Certificates: []tls.Certificate{cert},
-				},
-			},
-		},
-		apiKey: apiKey,
+// QueryEvents returns the events of that device during that period, restricted to those event types if any.
+// The past events are read from the cache if the client has one.
+func (c WeatherClient) QueryEvents(ctx context.Context, deviceId int, fromTime time.Time, toTime time.Time, eventTypes ...string) ([]WeatherEvent, error) {
+	if c.cache != nil {
+		return c.queryEventsCached(ctx, deviceId, fromTime, toTime, eventTypes)
 	}
+	return c.queryEvents(ctx, deviceId, fromTime, toTime, eventTypes)
 }
 
-func (c WeatherClient) QueryEvents(deviceId int, fromTime time.Time, toTime time.Time) ([]WeatherEvent, error) {
-	log.Printf("looking for weather events for device %v from %s to %v", deviceId, fromTime, toTime)
+func (c WeatherClient) queryEvents(ctx context.Context, deviceId int, fromTime time.Time, toTime time.Time, eventTypes []string) ([]WeatherEvent, error) {
+	c.logf("looking for weather events for device %v from %s to %v", deviceId, fromTime, toTime)
 
-	req, err := http.NewRequest("GET", c.ApiUrl, nil)
-	if err != nil {
-		log.Fatal(err)
+	var data []WeatherEvent
+	if err := c.getJson(ctx, c.ApiUrl, c.eventsQuery(deviceId, fromTime, toTime, eventTypes), &data); err != nil {
+		return nil, err
 	}
-	iso8601Format := "2006-01-02T15:04:05-0700"
+	return data, nil
+}
 
+// eventsQuery returns the query params selecting the events of that device during that period
+func (c WeatherClient) eventsQuery(deviceId int, fromTime time.Time, toTime time.Time, eventTypes []string) url.Values {
 	q := url.Values{}
 	q.Add("device_id", fmt.Sprint(deviceId))
-	q.Add("from", fromTime.Format(iso8601Format))
-	q.Add("to", toTime.Format(iso8601Format))
-	req.URL.RawQuery = q.Encode()
-	log.Printf("querying URL %s", req.URL.String())
+	q.Add("from", fromTime.Format(time.RFC3339))
+	q.Add("to", toTime.Format(time.RFC3339))
+	if len(eventTypes) > 0 {
+		q.Add("event_type", strings.Join(eventTypes, ","))
+	}
+	if c.Units != "" {
+		q.Add("units", c.Units)
+	}
+	return q
+}
 
-	req.Header["X-API-Key"] = []string{c.apiKey}
-	resp, err := c.httpClient.Do(req)
+// getJson sends a GET request to that endpoint and parses the JSON response into result.
+// Error responses are returned as *ProblemError.
+func (c WeatherClient) getJson(ctx context.Context, endpoint string, q url.Values, result any) error {
+	resp, err := c.doGet(ctx, endpoint, q, "application/json")
 	if err != nil {
-		log.Panicln(err)
-		return nil, fmt.Errorf("could not query API: %w", err)
+		return err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("error response from server: %s", resp.Status)
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
 	}
 
-	bodyBytes, err := io.ReadAll(resp.Body)
+	err = json.Unmarshal(bodyBytes, result)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+		return fmt.Errorf("failed to parse response body: %w", err)
 	}
 
-	var data []WeatherEvent
-	err = json.Unmarshal(bodyBytes, &data)
+	return nil
+}
+
+// doGet sends a GET request to that endpoint, accepting that media type, and retries it according to the retry policy.
+// Error responses are returned as *ProblemError, otherwise the caller must close the response body.
+func (c WeatherClient) doGet(ctx context.Context, endpoint string, q url.Values, accept string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse response body: %w", err)
+		return nil, fmt.Errorf("invalid request: %w", err)
 	}
+	req.URL.RawQuery = q.Encode()
+	req.Header["X-API-Key"] = []string{c.apiKey}
+	req.Header.Set("Accept", accept)
 
-	return data, nil
+	for attempt := 1; ; attempt++ {
+		c.logf("querying URL %s", req.URL.String())
+		resp, err := c.send(req)
+		lastAttempt := attempt >= c.retryPolicy.MaxAttempts || errors.Is(err, ErrCircuitOpen) || ctx.Err() != nil
+
+		if err != nil && lastAttempt {
+			return nil, err
+		}
+		if err == nil && (!retryableStatuses[resp.StatusCode] || lastAttempt) {
+			if resp.StatusCode != http.StatusOK {
+				defer resp.Body.Close()
+				bodyBytes, err := io.ReadAll(resp.Body)
+				if err != nil {
+					return nil, fmt.Errorf("failed to read response body: %w", err)
+				}
+				return nil, newProblemError(resp, bodyBytes)
+			}
+			return resp, nil
+		}
+
+		wait := c.retryPolicy.backoff(attempt)
+		if err == nil {
+			if retryAfter, ok := c.retryPolicy.retryAfter(resp, time.Now()); ok {
+				wait = retryAfter
+			}
+			err = fmt.Errorf("status %s", resp.Status)
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		c.logf("attempt %d failed (%v), retrying in %v", attempt, err, wait)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("could not query API: %w", ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// send sends that request once, unless the circuit breaker is open, waiting for the rate limiter if any
+func (c WeatherClient) send(req *http.Request) (*http.Response, error) {
+	if c.limiter != nil {
+		if err := c.limiter.wait(req.Context()); err != nil {
+			return nil, err
+		}
+	}
+	trial := false
+	if c.breaker != nil {
+		var err error
+		if trial, err = c.breaker.allow(time.Now()); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := c.httpClient.Do(req)
+
+	if c.breaker != nil {
+		if req.Context().Err() != nil {
+			// cancelled by the caller, telling nothing about the API
+			c.breaker.release(trial)
+		} else {
+			c.breaker.record(trial, err == nil && resp.StatusCode < 500, time.Now())
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not query API: %w", err)
+	}
+	return resp, nil
+}
+
+// resourceUrl resolves the URL of another resource of the API, relative to ApiUrl.
+// e.g. "devices/near" for the ApiUrl https://rest.weather-api-demo.poc.svend.xyz/weather
+// is https://rest.weather-api-demo.poc.svend.xyz/devices/near
+func (c WeatherClient) resourceUrl(resource string) (string, error) {
+	apiUrl, err := url.Parse(c.ApiUrl)
+	if err != nil {
+		return "", fmt.Errorf("invalid API URL %s: %w", c.ApiUrl, err)
+	}
+	return apiUrl.ResolveReference(&url.URL{Path: resource}).String(), nil
 }
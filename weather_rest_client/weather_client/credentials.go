@@ -0,0 +1,273 @@
+package weather_client
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// Credentials tell where the API is and how to authenticate to it. They may be loaded from
+// the environment, from a profile of the config file, or set directly.
+type Credentials struct {
+	ApiUrl string
+	ApiKey string
+	// PEM files containing the client certificate and its private key
+	CertFile string
+	KeyFile  string
+	// PKCS#12 bundle containing the client certificate and its private key, instead of CertFile and KeyFile
+	Pkcs12File     string
+	Pkcs12Password string
+	// PEM bundle of the root CAs trusted to sign the certificate of the server, instead of the system ones
+	CaFile string
+	// base64 SHA-256 hash of the public key (SPKI) of the certificate of the server, if pinned
+	ServerPin string
+}
+
+// environment variables of each field of Credentials
+var credentialsEnvVars = map[string]string{
+	"ApiUrl":         "WEATHER_API_URL",
+	"ApiKey":         "WEATHER_API_KEY",
+	"CertFile":       "WEATHER_CERT_FILE",
+	"KeyFile":        "WEATHER_KEY_FILE",
+	"Pkcs12File":     "WEATHER_PKCS12_FILE",
+	"Pkcs12Password": "WEATHER_PKCS12_PASSWORD",
+	"CaFile":         "WEATHER_CA_FILE",
+	"ServerPin":      "WEATHER_SERVER_PIN",
+}
+
+// keys of each field of Credentials in the profiles of the config file
+var credentialsConfigKeys = map[string]string{
+	"ApiUrl":         "url",
+	"ApiKey":         "api_key",
+	"CertFile":       "cert_file",
+	"KeyFile":        "key_file",
+	"Pkcs12File":     "pkcs12_file",
+	"Pkcs12Password": "pkcs12_password",
+	"CaFile":         "ca_file",
+	"ServerPin":      "server_pin",
+}
+
+// fields returns the fields of those credentials, per name
+func (c *Credentials) fields() map[string]*string {
+	return map[string]*string{
+		"ApiUrl":         &c.ApiUrl,
+		"ApiKey":         &c.ApiKey,
+		"CertFile":       &c.CertFile,
+		"KeyFile":        &c.KeyFile,
+		"Pkcs12File":     &c.Pkcs12File,
+		"Pkcs12Password": &c.Pkcs12Password,
+		"CaFile":         &c.CaFile,
+		"ServerPin":      &c.ServerPin,
+	}
+}
+
+// CredentialsFromEnv reads the credentials from the WEATHER_* environment variables, e.g. WEATHER_API_KEY
+func CredentialsFromEnv() Credentials {
+	credentials := Credentials{}
+	fields := credentials.fields()
+	for field, envVar := range credentialsEnvVars {
+		*fields[field] = os.Getenv(envVar)
+	}
+	return credentials
+}
+
+// DefaultConfigFile is the config file of the WEATHER_CONFIG_FILE environment variable if set,
+// or else weather_api/config in the user config directory (e.g. ~/.config/weather_api/config on Linux)
+func DefaultConfigFile() (string, error) {
+	if configFile := os.Getenv("WEATHER_CONFIG_FILE"); configFile != "" {
+		return configFile, nil
+	}
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("could not locate the config file: %w", err)
+	}
+	return filepath.Join(configDir, "weather_api", "config"), nil
+}
+
+// LoadProfile reads the credentials of that profile of the config file, made of
+// [profile] sections of key = value lines, relative paths being resolved from the config file:
+//
+//	[prod]
+//	url = https://rest.weather-api-demo.poc.svend.xyz/weather
+//	api_key = ...
+//	cert_file = certificates/clientCert.pem
+//	key_file = certificates/clientKey.pem
+//	ca_file = certificates/ca.pem
+func LoadProfile(configFile, profile string) (Credentials, error) {
+	file, err := os.Open(configFile)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("could not open the config file: %w", err)
+	}
+	defer file.Close()
+
+	credentials := Credentials{}
+	fields := credentials.fields()
+	found := false
+	section := ""
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			found = found || section == profile
+			continue
+		}
+		if section != profile {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return Credentials{}, fmt.Errorf("invalid line %d of %s: expected key = value", lineNumber, configFile)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		field := ""
+		for fieldName, configKey := range credentialsConfigKeys {
+			if configKey == key {
+				field = fieldName
+			}
+		}
+		if field == "" {
+			return Credentials{}, fmt.Errorf("unknown key %q at line %d of %s", key, lineNumber, configFile)
+		}
+		if strings.HasSuffix(field, "File") && value != "" && !filepath.IsAbs(value) {
+			value = filepath.Join(filepath.Dir(configFile), value)
+		}
+		*fields[field] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return Credentials{}, fmt.Errorf("could not read the config file: %w", err)
+	}
+	if !found {
+		return Credentials{}, fmt.Errorf("no profile %q in %s", profile, configFile)
+	}
+	return credentials, nil
+}
+
+// Merge returns those credentials, overridden by the non empty fields of others
+func (c Credentials) Merge(others Credentials) Credentials {
+	merged := c
+	mergedFields := merged.fields()
+	for field, value := range others.fields() {
+		if *value != "" {
+			*mergedFields[field] = *value
+		}
+	}
+	return merged
+}
+
+// NewFromCredentials creates a client of the API authenticated by those credentials,
+// unless the HTTP client is provided through WithHTTPClient.
+func NewFromCredentials(credentials Credentials, options ...Option) (WeatherClient, error) {
+	if credentials.ApiUrl == "" {
+		return WeatherClient{}, errors.New("missing API URL")
+	}
+
+	client := WeatherClient{
+		ApiUrl:      credentials.ApiUrl,
+		apiKey:      credentials.ApiKey,
+		logger:      defaultLogger(),
+		retryPolicy: DefaultRetryPolicy,
+	}
+	for _, option := range options {
+		option(&client)
+	}
+
+	if client.httpClient == nil {
+		tlsConfig, err := credentials.tlsConfig()
+		if err != nil {
+			return WeatherClient{}, err
+		}
+		client.httpClient = &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: tlsConfig,
+			},
+			Timeout: defaultTimeout,
+		}
+	}
+	if client.timeout > 0 {
+		httpClient := *client.httpClient
+		httpClient.Timeout = client.timeout
+		client.httpClient = &httpClient
+	}
+	return client, nil
+}
+
+// tlsConfig presents the client certificate and checks the one of the server against the CAs and pin, if any
+func (c Credentials) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+
+	if c.Pkcs12File != "" {
+		cert, err := loadPkcs12(c.Pkcs12File, c.Pkcs12Password)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	} else if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if c.CaFile != "" {
+		caBundle, err := os.ReadFile(c.CaFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the CA bundle: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caBundle) {
+			return nil, fmt.Errorf("no PEM certificate found in the CA bundle %s", c.CaFile)
+		}
+	}
+
+	if c.ServerPin != "" {
+		tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 {
+				return errors.New("no server certificate to check against the pin")
+			}
+			hash := sha256.Sum256(state.PeerCertificates[0].RawSubjectPublicKeyInfo)
+			if pin := base64.StdEncoding.EncodeToString(hash[:]); pin != c.ServerPin {
+				return fmt.Errorf("public key of the server certificate does not match the pin: got %s", pin)
+			}
+			return nil
+		}
+	}
+	return tlsConfig, nil
+}
+
+// loadPkcs12 reads the client certificate, its chain and its private key from that PKCS#12 bundle
+func loadPkcs12(pkcs12File, password string) (tls.Certificate, error) {
+	bundle, err := os.ReadFile(pkcs12File)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to read the PKCS#12 bundle: %w", err)
+	}
+	privateKey, cert, chain, err := pkcs12.DecodeChain(bundle, password)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to decode the PKCS#12 bundle %s: %w", pkcs12File, err)
+	}
+
+	tlsCert := tls.Certificate{
+		Certificate: [][]byte{cert.Raw},
+		PrivateKey:  privateKey,
+		Leaf:        cert,
+	}
+	for _, caCert := range chain {
+		tlsCert.Certificate = append(tlsCert.Certificate, caCert.Raw)
+	}
+	return tlsCert, nil
+}
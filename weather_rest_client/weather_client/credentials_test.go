@@ -0,0 +1,278 @@
+package weather_client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+const testConfig = `# weather API profiles
+[prod]
+url = https://rest.weather-api-demo.poc.svend.xyz/weather
+api_key = prod-key
+cert_file = certificates/clientCert.pem
+key_file = /etc/weather/clientKey.pem
+ca_file =
+server_pin = 47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=
+
+; older setup
+[ staging ]
+url=https://staging.example.com/weather
+pkcs12_file = ../client.p12
+pkcs12_password = se=cret
+`
+
+func writeFile(t *testing.T, path, content string) string {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadProfile(t *testing.T) {
+	configDir := filepath.Join(t.TempDir(), "weather_api")
+	configFile := writeFile(t, filepath.Join(configDir, "config"), testConfig)
+
+	tests := []struct {
+		profile  string
+		expected Credentials
+	}{
+		{"prod", Credentials{
+			ApiUrl: "https://rest.weather-api-demo.poc.svend.xyz/weather",
+			ApiKey: "prod-key",
+			// relative to the config file
+			CertFile: filepath.Join(configDir, "certificates", "clientCert.pem"),
+			KeyFile:  "/etc/weather/clientKey.pem",
+			// empty paths are left empty
+			CaFile:    "",
+			ServerPin: "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=",
+		}},
+		{"staging", Credentials{
+			ApiUrl:         "https://staging.example.com/weather",
+			Pkcs12File:     filepath.Join(filepath.Dir(configDir), "client.p12"),
+			Pkcs12Password: "se=cret",
+		}},
+	}
+	for _, test := range tests {
+		credentials, err := LoadProfile(configFile, test.profile)
+		if err != nil {
+			t.Errorf("%s: %v", test.profile, err)
+			continue
+		}
+		if credentials != test.expected {
+			t.Errorf("%s: credentials %+v, expected %+v", test.profile, credentials, test.expected)
+		}
+	}
+}
+
+func TestLoadProfileRefusesInvalidConfig(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		config  string
+		profile string
+		message string
+	}{
+		{"unknown profile", testConfig, "dev", `no profile "dev"`},
+		{"profile name case", testConfig, "Prod", `no profile "Prod"`},
+		{"line without value", "[prod]\nurl https://example.com\n", "prod", "invalid line 2"},
+		{"unknown key", "[prod]\nurl = https://example.com\npassword = secret\n", "prod", `unknown key "password" at line 3`},
+		// the lines of the other profiles are not checked
+		{"invalid line of another profile", "[dev]\nurl\n[prod]\nurl = https://example.com\n", "dev", "invalid line 2"},
+	}
+	for _, test := range tests {
+		configFile := writeFile(t, filepath.Join(dir, strings.ReplaceAll(test.name, " ", "_")), test.config)
+		credentials, err := LoadProfile(configFile, test.profile)
+		if err == nil || !strings.Contains(err.Error(), test.message) {
+			t.Errorf("%s: credentials %+v and error %v, expected %q", test.name, credentials, err, test.message)
+		}
+	}
+
+	if _, err := LoadProfile(filepath.Join(dir, "missing"), "prod"); err == nil {
+		t.Error("loaded a missing config file")
+	}
+	if credentials, err := LoadProfile(writeFile(t, filepath.Join(dir, "other"), "[dev]\nurl\n[prod]\nurl = https://example.com\n"), "prod"); err != nil || credentials.ApiUrl != "https://example.com" {
+		t.Errorf("credentials %+v and error %v, expected the invalid line of the dev profile to be ignored", credentials, err)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	profile := Credentials{ApiUrl: "https://profile.example.com", ApiKey: "profile-key", CertFile: "profile.pem", KeyFile: "profile.key"}
+	env := Credentials{ApiKey: "env-key", CaFile: "env-ca.pem"}
+	flags := Credentials{ApiKey: "flag-key", CertFile: "flag.pem"}
+
+	merged := profile.Merge(env).Merge(flags)
+
+	expected := Credentials{ApiUrl: "https://profile.example.com", ApiKey: "flag-key", CertFile: "flag.pem", KeyFile: "profile.key", CaFile: "env-ca.pem"}
+	if merged != expected {
+		t.Errorf("merged %+v, expected %+v", merged, expected)
+	}
+	if profile.ApiKey != "profile-key" {
+		t.Errorf("merge modified the merged credentials %+v", profile)
+	}
+}
+
+func TestCredentialsFromEnv(t *testing.T) {
+	for field, envVar := range credentialsEnvVars {
+		t.Setenv(envVar, "")
+		if field == "ApiKey" || field == "ServerPin" {
+			t.Setenv(envVar, "from-"+envVar)
+		}
+	}
+
+	credentials := CredentialsFromEnv()
+
+	expected := Credentials{ApiKey: "from-WEATHER_API_KEY", ServerPin: "from-WEATHER_SERVER_PIN"}
+	if credentials != expected {
+		t.Errorf("credentials %+v, expected %+v", credentials, expected)
+	}
+}
+
+// newTestCertificate generates a self-signed certificate of that common name, and its private key
+func newTestCertificate(t *testing.T, commonName string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func certificatePEM(cert *x509.Certificate) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+}
+
+func spkiPin(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+func TestTlsConfig(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the common name of the client certificate
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	dir := t.TempDir()
+	caFile := writeFile(t, filepath.Join(dir, "ca.pem"), certificatePEM(server.Certificate()))
+	clientCert, clientKey := newTestCertificate(t, "pem-client")
+	keyDer, err := x509.MarshalPKCS8PrivateKey(clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := writeFile(t, filepath.Join(dir, "client.pem"), certificatePEM(clientCert))
+	keyFile := writeFile(t, filepath.Join(dir, "client.key"), string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer})))
+	bundledCert, bundledKey := newTestCertificate(t, "pkcs12-client")
+	bundle, err := pkcs12.Modern.Encode(bundledKey, bundledCert, nil, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs12File := writeFile(t, filepath.Join(dir, "client.p12"), string(bundle))
+	otherCert, _ := newTestCertificate(t, "other")
+	certificateHash := sha256.Sum256(server.Certificate().Raw)
+
+	tests := []struct {
+		name        string
+		credentials Credentials
+		// common name of the client certificate received by the server, or expected error
+		expected string
+	}{
+		{"PEM client certificate", Credentials{CertFile: certFile, KeyFile: keyFile, CaFile: caFile}, "pem-client"},
+		{"PKCS#12 client certificate", Credentials{Pkcs12File: pkcs12File, Pkcs12Password: "secret", CaFile: caFile}, "pkcs12-client"},
+		{"PKCS#12 over PEM", Credentials{CertFile: certFile, KeyFile: keyFile, Pkcs12File: pkcs12File, Pkcs12Password: "secret", CaFile: caFile}, "pkcs12-client"},
+		{"pinned server", Credentials{CertFile: certFile, KeyFile: keyFile, CaFile: caFile, ServerPin: spkiPin(server.Certificate())}, "pem-client"},
+		{"other pin", Credentials{CertFile: certFile, KeyFile: keyFile, CaFile: caFile, ServerPin: spkiPin(otherCert)}, "does not match the pin"},
+		{"pin of the certificate rather than its public key", Credentials{CertFile: certFile, KeyFile: keyFile, CaFile: caFile, ServerPin: base64.StdEncoding.EncodeToString(certificateHash[:])}, "does not match the pin"},
+		{"untrusted server", Credentials{CertFile: certFile, KeyFile: keyFile}, "certificate"},
+		{"no client certificate", Credentials{CaFile: caFile}, "certificate"},
+	}
+	for _, test := range tests {
+		tlsConfig, err := test.credentials.tlsConfig()
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}, Timeout: 5 * time.Second}
+		response, err := client.Get(server.URL)
+		if err != nil {
+			if !strings.Contains(err.Error(), test.expected) {
+				t.Errorf("%s: error %v, expected %q", test.name, err, test.expected)
+			}
+			continue
+		}
+		body := make([]byte, 64)
+		n, _ := response.Body.Read(body)
+		response.Body.Close()
+		if string(body[:n]) != test.expected {
+			t.Errorf("%s: server received the client certificate %q, expected %q", test.name, body[:n], test.expected)
+		}
+	}
+}
+
+func TestTlsConfigRefusesInvalidFiles(t *testing.T) {
+	dir := t.TempDir()
+	notPem := writeFile(t, filepath.Join(dir, "ca.txt"), "not a certificate")
+	cert, key := newTestCertificate(t, "client")
+	bundle, err := pkcs12.Modern.Encode(key, cert, nil, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs12File := writeFile(t, filepath.Join(dir, "client.p12"), string(bundle))
+	certFile := writeFile(t, filepath.Join(dir, "client.pem"), certificatePEM(cert))
+
+	tests := []struct {
+		name        string
+		credentials Credentials
+	}{
+		{"missing certificate", Credentials{CertFile: filepath.Join(dir, "missing.pem"), KeyFile: filepath.Join(dir, "missing.key")}},
+		{"certificate without key", Credentials{CertFile: certFile}},
+		{"missing CA bundle", Credentials{CaFile: filepath.Join(dir, "missing.pem")}},
+		{"CA bundle without certificate", Credentials{CaFile: notPem}},
+		{"missing PKCS#12 bundle", Credentials{Pkcs12File: filepath.Join(dir, "missing.p12")}},
+		{"wrong PKCS#12 password", Credentials{Pkcs12File: pkcs12File, Pkcs12Password: "guess"}},
+	}
+	for _, test := range tests {
+		if _, err := test.credentials.tlsConfig(); err == nil {
+			t.Errorf("%s: no error", test.name)
+		}
+	}
+}
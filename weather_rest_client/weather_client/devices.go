@@ -0,0 +1,98 @@
+package weather_client
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+type Device struct {
+	DeviceId    int64
+	Name        string
+	Location    Location
+	Sensors     []string
+	Status      string
+	InstalledAt time.Time
+	// whether the readings of this device are generated by the data generator
+	Simulated bool
+}
+
+type Location struct {
+	Latitude  float64
+	Longitude float64
+	Altitude  float64
+}
+
+// LocatedDevice is a device found by a geospatial lookup, along with the latest reading of each of its sensors
+type LocatedDevice struct {
+	Device         Device
+	DistanceKm     float64
+	LatestReadings []WeatherEvent
+}
+
+// DevicePage is one page of the devices of the registry
+type DevicePage struct {
+	Devices []Device
+	// token of the next page, empty on the last one
+	NextToken string
+}
+
+// ListDevices returns all the devices of the registry, fetching them page by page
+func (c WeatherClient) ListDevices(ctx context.Context) ([]Device, error) {
+	c.logf("listing the devices")
+
+	endpoint, err := c.resourceUrl("devices")
+	if err != nil {
+		return nil, err
+	}
+
+	devices := []Device{}
+	nextToken := ""
+	for {
+		q := url.Values{}
+		q.Add("limit", "100")
+		if nextToken != "" {
+			q.Add("next_token", nextToken)
+		}
+
+		var page DevicePage
+		if err := c.getJson(ctx, endpoint, q, &page); err != nil {
+			return nil, err
+		}
+		devices = append(devices, page.Devices...)
+		if page.NextToken == "" {
+			return devices, nil
+		}
+		nextToken = page.NextToken
+	}
+}
+
+// QueryNearbyDevices looks for the devices within radiusKm of that location, closest first
+func (c WeatherClient) QueryNearbyDevices(ctx context.Context, latitude, longitude, radiusKm float64) ([]LocatedDevice, error) {
+	c.logf("looking for devices within %g km of %g,%g", radiusKm, latitude, longitude)
+
+	endpoint, err := c.resourceUrl("devices/near")
+	if err != nil {
+		return nil, err
+	}
+
+	q := url.Values{}
+	q.Add("lat", strconv.FormatFloat(latitude, 'f', -1, 64))
+	q.Add("lon", strconv.FormatFloat(longitude, 'f', -1, 64))
+	q.Add("radius_km", strconv.FormatFloat(radiusKm, 'f', -1, 64))
+
+	var data struct {
+		Devices []LocatedDevice
+	}
+	if err := c.getJson(ctx, endpoint, q, &data); err != nil {
+		return nil, err
+	}
+	return data.Devices, nil
+}
+
+func (d LocatedDevice) String() string {
+	return fmt.Sprintf("device %d %q at %.1f km (%g,%g), status %s",
+		d.Device.DeviceId, d.Device.Name, d.DistanceKm, d.Device.Location.Latitude, d.Device.Location.Longitude, d.Device.Status)
+}
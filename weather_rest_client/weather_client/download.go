@@ -0,0 +1,38 @@
+package weather_client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// media type of each format the API can respond with
+var formatMediaTypes = map[string]string{
+	"json":    "application/json",
+	"csv":     "text/csv",
+	"ndjson":  "application/x-ndjson",
+	"parquet": "application/vnd.apache.parquet",
+}
+
+// DownloadEvents writes the events of that device during that period, restricted to those event types if any, to w,
+// as returned by the API in that format: json, csv, ndjson or parquet. It returns the number of bytes written.
+func (c WeatherClient) DownloadEvents(ctx context.Context, deviceId int, fromTime time.Time, toTime time.Time, format string, w io.Writer, eventTypes ...string) (int64, error) {
+	mediaType, ok := formatMediaTypes[format]
+	if !ok {
+		return 0, fmt.Errorf("unsupported format %q, expected json, csv, ndjson or parquet", format)
+	}
+	c.logf("downloading weather events for device %v from %s to %v as %s", deviceId, fromTime, toTime, format)
+
+	resp, err := c.doGet(ctx, c.ApiUrl, c.eventsQuery(deviceId, fromTime, toTime, eventTypes), mediaType)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	written, err := io.Copy(w, resp.Body)
+	if err != nil {
+		return written, fmt.Errorf("failed to write response body: %w", err)
+	}
+	return written, nil
+}
@@ -0,0 +1,50 @@
+package weather_client
+
+import (
+	"log"
+	"net/http"
+)
+
+// Logger receives the log messages of the client. *log.Logger implements it.
+type Logger interface {
+	Printf(format string, v ...any)
+}
+
+// Option customizes a WeatherClient created by New
+type Option func(*WeatherClient)
+
+// WithHTTPClient sends the requests through that client instead of one presenting the certificate passed to New.
+// The client is then responsible for the mutual TLS authentication.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *WeatherClient) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithLogger sends the log messages of the client to that logger instead of the standard one.
+// Pass log.New(io.Discard, "", 0) to silence the client.
+func WithLogger(logger Logger) Option {
+	return func(c *WeatherClient) {
+		c.logger = logger
+	}
+}
+
+// WithUnits sets the unit system of the returned values: metric, imperial or si
+func WithUnits(units string) Option {
+	return func(c *WeatherClient) {
+		c.Units = units
+	}
+}
+
+func defaultLogger() Logger {
+	return log.Default()
+}
+
+// logf logs through the logger of the client, or the standard one if the client was not created by New
+func (c WeatherClient) logf(format string, v ...any) {
+	if c.logger == nil {
+		log.Printf(format, v...)
+		return
+	}
+	c.logger.Printf(format, v...)
+}
@@ -0,0 +1,53 @@
+package weather_client
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// ProblemError is an error response of the API. Its fields are those of the RFC 7807 problem
+// document returned by the API, or are derived from the status and body of the response otherwise.
+type ProblemError struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail"`
+	// offending query parameter, if any
+	Param string `json:"param"`
+	// id of the request, to be mentioned when reporting an issue
+	RequestId string `json:"requestId"`
+}
+
+func (e *ProblemError) Error() string {
+	description := fmt.Sprintf("error response from server: %d %s", e.Status, e.Title)
+	if e.Detail != "" {
+		description += ": " + e.Detail
+	}
+	if e.RequestId != "" {
+		description += fmt.Sprintf(" (request id %s)", e.RequestId)
+	}
+	return description
+}
+
+// newProblemError reads the error response of the API
+func newProblemError(resp *http.Response, body []byte) *ProblemError {
+	problem := &ProblemError{}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == "application/problem+json" {
+		if err := json.Unmarshal(body, problem); err == nil {
+			if problem.Status == 0 {
+				problem.Status = resp.StatusCode
+			}
+			return problem
+		}
+	}
+
+	return &ProblemError{
+		Type:   "about:blank",
+		Title:  http.StatusText(resp.StatusCode),
+		Status: resp.StatusCode,
+		Detail: strings.TrimSpace(string(body)),
+	}
+}
@@ -0,0 +1,53 @@
+package weather_client
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RateLimiter spaces the requests evenly to stay under a number of requests per second, such as the rate limit
+// of the usage plan of the API key. The same limiter may be shared by several clients using the same key.
+type RateLimiter struct {
+	interval time.Duration
+
+	mu sync.Mutex
+	// time at which the next request may be sent
+	next time.Time
+}
+
+func NewRateLimiter(requestsPerSecond float64) *RateLimiter {
+	return &RateLimiter{interval: time.Duration(float64(time.Second) / max(requestsPerSecond, 0.001))}
+}
+
+// wait blocks until a request may be sent, or until that context is done
+func (l *RateLimiter) wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+
+	if wait == 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("could not query API: %w", ctx.Err())
+	case <-timer.C:
+		return nil
+	}
+}
+
+// WithRateLimiter delays the requests, including the retries, to comply with that limiter
+func WithRateLimiter(limiter *RateLimiter) Option {
+	return func(c *WeatherClient) {
+		c.limiter = limiter
+	}
+}
@@ -0,0 +1,161 @@
+package weather_client
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// default timeout of each request, including the reading of the response body
+const defaultTimeout = 30 * time.Second
+
+// statuses returned by the API Gateway when throttling or when the lambda is unavailable, worth retrying
+var retryableStatuses = map[int]bool{
+	http.StatusTooManyRequests:    true,
+	http.StatusBadGateway:         true,
+	http.StatusServiceUnavailable: true,
+	http.StatusGatewayTimeout:     true,
+}
+
+// RetryPolicy tells how often failed requests are retried: after transport errors or
+// 429, 502, 503 and 504 responses, waiting for their Retry-After header if any,
+// or else for an exponentially increasing backoff.
+type RetryPolicy struct {
+	// number of attempts of each request, including the first one. No retry if 1 or less
+	MaxAttempts int
+	// wait before the first retry, doubled at each following one
+	InitialBackoff time.Duration
+	// max wait before a retry, also capping the Retry-After header
+	MaxBackoff time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    4,
+	InitialBackoff: 200 * time.Millisecond,
+	MaxBackoff:     20 * time.Second,
+}
+
+// NoRetry sends each request only once
+var NoRetry = RetryPolicy{MaxAttempts: 1}
+
+// backoff returns the wait before the retry following that attempt, with up to 20% of jitter
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	backoff := p.InitialBackoff << (attempt - 1)
+	if backoff <= 0 || backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+	return backoff - time.Duration(rand.Int63n(int64(backoff)/5+1))
+}
+
+// retryAfter returns the wait requested by the Retry-After header of that response, as
+// a number of seconds or an HTTP date, if any
+func (p RetryPolicy) retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	header := resp.Header.Get("Retry-After")
+	if header == "" {
+		return 0, false
+	}
+
+	var wait time.Duration
+	if seconds, err := strconv.Atoi(header); err == nil {
+		wait = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(header); err == nil {
+		wait = date.Sub(now)
+	} else {
+		return 0, false
+	}
+	return min(max(wait, 0), p.MaxBackoff), true
+}
+
+// WithTimeout sets the timeout of each request, including the reading of the response body (30s by default).
+// It applies to a copy of the HTTP client passed to WithHTTPClient, if any.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *WeatherClient) {
+		c.timeout = timeout
+	}
+}
+
+// WithRetryPolicy replaces DefaultRetryPolicy
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(c *WeatherClient) {
+		c.retryPolicy = policy
+	}
+}
+
+// WithCircuitBreaker fails the requests fast while that breaker is open.
+// The same breaker may be shared by several clients of the same API.
+func WithCircuitBreaker(breaker *CircuitBreaker) Option {
+	return func(c *WeatherClient) {
+		c.breaker = breaker
+	}
+}
+
+// ErrCircuitOpen is returned instead of sending requests while the circuit breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker open: too many recent failures of the API")
+
+// CircuitBreaker opens after a number of consecutive failures of the API (transport errors or 5xx responses),
+// then rejects the requests during a cooldown, after which a single trial request is let through:
+// its success closes the breaker, its failure opens it again for another cooldown.
+type CircuitBreaker struct {
+	failureThreshold int
+	cooldown         time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	// whether a trial request is in progress after the cooldown
+	trial bool
+}
+
+func NewCircuitBreaker(failureThreshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		failureThreshold: max(failureThreshold, 1),
+		cooldown:         cooldown,
+	}
+}
+
+// allow tells whether a request may be sent now, and whether it is the trial request after the cooldown
+func (b *CircuitBreaker) allow(now time.Time) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.failureThreshold {
+		return false, nil
+	}
+	if b.trial || now.Before(b.openedAt.Add(b.cooldown)) {
+		return false, fmt.Errorf("%w, retry after %s", ErrCircuitOpen, b.openedAt.Add(b.cooldown).Format(time.RFC3339))
+	}
+	b.trial = true
+	return true, nil
+}
+
+// release ends a request it allowed, without outcome
+func (b *CircuitBreaker) release(trial bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if trial {
+		b.trial = false
+	}
+}
+
+// record updates the breaker with the outcome of a request it allowed. Only the trial request ends the trial,
+// the other ones having been sent before the breaker opened.
+func (b *CircuitBreaker) record(trial bool, success bool, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if trial {
+		b.trial = false
+	}
+	if success {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.failureThreshold {
+		b.openedAt = now
+	}
+}
@@ -0,0 +1,298 @@
+package weather_client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testApi is a local stand-in of the API, answering each request with the next status of its list
+// (the last one being repeated), and recording the time of the requests
+type testApi struct {
+	mutex    sync.Mutex
+	statuses []int
+	headers  http.Header
+	delay    time.Duration
+	requests []time.Time
+}
+
+func (a *testApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mutex.Lock()
+	a.requests = append(a.requests, time.Now())
+	status := a.statuses[min(len(a.requests), len(a.statuses))-1]
+	a.mutex.Unlock()
+
+	if a.delay > 0 {
+		select {
+		case <-time.After(a.delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	for name, values := range a.headers {
+		w.Header()[name] = values
+	}
+	if status != http.StatusOK {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(status)
+		io.WriteString(w, `{"type": "/problems/storage-failure", "title": "Failed", "status": 0}`)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	io.WriteString(w, `[]`)
+}
+
+func (a *testApi) requestTimes() []time.Time {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return append([]time.Time{}, a.requests...)
+}
+
+func (a *testApi) setStatuses(statuses ...int) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.statuses = statuses
+	a.requests = nil
+}
+
+func newTestClient(t *testing.T, api *testApi, options ...Option) WeatherClient {
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	options = append([]Option{WithHTTPClient(server.Client()), WithLogger(log.New(io.Discard, "", 0))}, options...)
+	client, err := New(server.URL+"/weather", "key", "", "", options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func query(client WeatherClient) error {
+	_, err := client.QueryEvents(context.Background(), 1001, time.Now().Add(-time.Hour), time.Now())
+	return err
+}
+
+func statusOf(err error) int {
+	problem := &ProblemError{}
+	if errors.As(err, &problem) {
+		return problem.Status
+	}
+	return 0
+}
+
+func TestRetryAfterHeader(t *testing.T) {
+	api := &testApi{statuses: []int{429, 200}, headers: http.Header{"Retry-After": {"1"}}}
+	// the Retry-After of 1s is capped by MaxBackoff
+	client := newTestClient(t, api, WithRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 100 * time.Millisecond}))
+
+	if err := query(client); err != nil {
+		t.Fatal(err)
+	}
+	requests := api.requestTimes()
+	if len(requests) != 2 {
+		t.Fatalf("%d requests, expected 2", len(requests))
+	}
+	if wait := requests[1].Sub(requests[0]); wait < 100*time.Millisecond || wait > time.Second {
+		t.Errorf("retried after %v, expected the Retry-After capped to 100ms", wait)
+	}
+}
+
+func TestRetryAfterParsing(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	policy := RetryPolicy{MaxBackoff: 20 * time.Second}
+	tests := []struct {
+		header   string
+		expected time.Duration
+		ok       bool
+	}{
+		{"", 0, false},
+		{"soon", 0, false},
+		{"3", 3 * time.Second, true},
+		{"120", 20 * time.Second, true},
+		{now.Add(5 * time.Second).Format(http.TimeFormat), 5 * time.Second, true},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0, true},
+	}
+	for _, test := range tests {
+		resp := &http.Response{Header: http.Header{}}
+		if test.header != "" {
+			resp.Header.Set("Retry-After", test.header)
+		}
+		wait, ok := policy.retryAfter(resp, now)
+		if wait != test.expected || ok != test.ok {
+			t.Errorf("Retry-After %q: %v %v, expected %v %v", test.header, wait, ok, test.expected, test.ok)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	tests := []struct {
+		attempt  int
+		expected time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond},
+		{5, time.Second},
+		{70, time.Second},
+	}
+	for _, test := range tests {
+		for range 20 {
+			// up to 20% of jitter
+			if backoff := policy.backoff(test.attempt); backoff > test.expected || backoff < test.expected*4/5 {
+				t.Errorf("backoff of attempt %d %v, expected between 80%% and 100%% of %v", test.attempt, backoff, test.expected)
+			}
+		}
+	}
+}
+
+func TestAttemptLimit(t *testing.T) {
+	api := &testApi{statuses: []int{503}}
+	client := newTestClient(t, api, WithRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}))
+
+	err := query(client)
+	if statusOf(err) != 503 {
+		t.Errorf("error %v, expected the 503 problem of the last attempt", err)
+	}
+	if requests := len(api.requestTimes()); requests != 3 {
+		t.Errorf("%d requests, expected 3 attempts", requests)
+	}
+}
+
+func TestNoRetryOfClientErrors(t *testing.T) {
+	api := &testApi{statuses: []int{400}}
+	client := newTestClient(t, api, WithRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}))
+
+	if err := query(client); statusOf(err) != 400 {
+		t.Errorf("error %v, expected the 400 problem", err)
+	}
+	if requests := len(api.requestTimes()); requests != 1 {
+		t.Errorf("%d requests, expected a single attempt", requests)
+	}
+}
+
+func TestCircuitBreakerStates(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	breaker := NewCircuitBreaker(2, time.Minute)
+
+	// closed
+	for range 2 {
+		if trial, err := breaker.allow(now); err != nil || trial {
+			t.Fatalf("closed breaker: trial %v, error %v", trial, err)
+		}
+		breaker.record(false, false, now)
+	}
+
+	// open
+	if _, err := breaker.allow(now.Add(30 * time.Second)); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("error %v during the cooldown, expected ErrCircuitOpen", err)
+	}
+
+	// half-open: a single trial request, whose failure opens the breaker again
+	trial, err := breaker.allow(now.Add(time.Minute))
+	if err != nil || !trial {
+		t.Fatalf("after the cooldown: trial %v, error %v, expected a trial request", trial, err)
+	}
+	if _, err := breaker.allow(now.Add(time.Minute)); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("error %v during the trial, expected ErrCircuitOpen", err)
+	}
+	breaker.record(trial, false, now.Add(time.Minute))
+	if _, err := breaker.allow(now.Add(90 * time.Second)); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("error %v after the failed trial, expected ErrCircuitOpen for another cooldown", err)
+	}
+
+	// the success of the next trial closes it
+	trial, err = breaker.allow(now.Add(2 * time.Minute))
+	if err != nil || !trial {
+		t.Fatalf("after the second cooldown: trial %v, error %v, expected a trial request", trial, err)
+	}
+	breaker.record(trial, true, now.Add(2*time.Minute))
+	if trial, err := breaker.allow(now.Add(2 * time.Minute)); err != nil || trial {
+		t.Errorf("closed breaker: trial %v, error %v", trial, err)
+	}
+}
+
+func TestCircuitBreakerTrialOnlyEndedByTrialRequest(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	breaker := NewCircuitBreaker(1, time.Minute)
+
+	// a slow request sent while the breaker is closed
+	slowTrial, _ := breaker.allow(now)
+	breaker.record(false, false, now)
+
+	trial, err := breaker.allow(now.Add(time.Minute))
+	if err != nil || !trial {
+		t.Fatalf("trial %v, error %v, expected a trial request", trial, err)
+	}
+	// the slow request fails during the trial, which goes on
+	breaker.record(slowTrial, false, now.Add(time.Minute))
+	if _, err := breaker.allow(now.Add(2 * time.Minute)); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("error %v, expected a single trial request at once", err)
+	}
+
+	// same for a cancelled one
+	breaker.release(false)
+	if _, err := breaker.allow(now.Add(2 * time.Minute)); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("error %v, expected a single trial request at once", err)
+	}
+	breaker.release(trial)
+	if trial, err := breaker.allow(now.Add(2 * time.Minute)); err != nil || !trial {
+		t.Errorf("trial %v, error %v, expected a new trial once the previous one is released", trial, err)
+	}
+}
+
+func TestClientWithCircuitBreaker(t *testing.T) {
+	api := &testApi{statuses: []int{500}}
+	client := newTestClient(t, api, WithRetryPolicy(NoRetry), WithCircuitBreaker(NewCircuitBreaker(2, 50*time.Millisecond)))
+
+	for range 2 {
+		if err := query(client); statusOf(err) != 500 {
+			t.Fatalf("error %v, expected the 500 problem", err)
+		}
+	}
+	if err := query(client); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("error %v, expected ErrCircuitOpen", err)
+	}
+	if requests := len(api.requestTimes()); requests != 2 {
+		t.Errorf("%d requests, expected none sent while open", requests)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	api.setStatuses(200)
+	for range 2 {
+		if err := query(client); err != nil {
+			t.Errorf("error %v, expected the trial request to close the breaker", err)
+		}
+	}
+	if requests := len(api.requestTimes()); requests != 2 {
+		t.Errorf("%d requests, expected 2 once closed", requests)
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	api := &testApi{statuses: []int{200}, delay: time.Second}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	httpClient := server.Client()
+	client, err := New(server.URL+"/weather", "key", "", "", WithHTTPClient(httpClient), WithTimeout(50*time.Millisecond),
+		WithRetryPolicy(NoRetry), WithLogger(log.New(io.Discard, "", 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if err := query(client); err == nil {
+		t.Error("no error, expected a timeout")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("failed after %v, expected the 50ms timeout", elapsed)
+	}
+	if httpClient.Timeout != 0 {
+		t.Errorf("timeout %v of the HTTP client passed to WithHTTPClient, expected it unchanged", httpClient.Timeout)
+	}
+}